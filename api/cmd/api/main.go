@@ -1,6 +1,7 @@
 package main
 
 import (
+	"embox/internal/api/routes"
 	"embox/internal/config"
 	"log"
 
@@ -22,5 +23,11 @@ func main() {
 	}()
 
 	infrastructure.InitLogger(apiConfig.Router.ReleaseMode)
-	infrastructure.InitServer(db, apiConfig)
+	router, services := routes.Init(db, apiConfig)
+	// Only the server runs the background jobs, not the tests or other tools that set up the services
+	services.Media.ResumeTranscoding()
+	services.Job.Start()
+	services.Trash.StartPurgeJob()
+	services.Upload.StartCleanupJob()
+	infrastructure.InitServer(router, apiConfig)
 }
@@ -0,0 +1,62 @@
+// Command embox-backfill creates the derived files that are missing for existing media,
+// such as thumbnail renditions added to MEDIA_THUMBNAIL_SIZES after the media was uploaded,
+// the previews and MP4/HLS versions of videos and the waveforms of audio files that were never processed.
+// Geotagged media get their place names once MEDIA_GEONAMES_FILE is configured,
+// images and videos their perceptual hashes for near-duplicate detection
+// and the dimensions, dominant colour and BlurHash shown by the media grid.
+// Originals are downloaded from the storage backend as needed.
+// It prints a JSON report and exits with status 1 if any media could not be processed.
+//
+// Usage:
+//
+//	embox-backfill [-concurrency 2]
+package main
+
+import (
+	"embox/internal/config"
+	"embox/internal/infrastructure"
+	"embox/internal/repositories"
+	"embox/internal/services"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+)
+
+func main() {
+	concurrency := flag.Int("concurrency", 2, "number of media processed in parallel")
+	flag.Parse()
+
+	dbConfig := config.LoadDbConfig()
+	apiConfig := config.LoadApiConfig()
+
+	db, err := infrastructure.InitDatabase(dbConfig)
+	if err != nil {
+		log.Fatalf("failed to connect to database: %v", err)
+	}
+
+	repos := repositories.Init(db)
+	storage := services.NewStorage(apiConfig.Storage)
+	// Jobs are not started here, the workers of the API server process pending uploads
+	jobService := services.NewJobService(apiConfig.Media, repos.Job, repos.User)
+	mediaService := services.NewMediaService(apiConfig.Upload, apiConfig.Media, storage, repos.Media, repos.User, jobService)
+	backfillService := services.NewBackfillService(mediaService, repos.Media)
+
+	report, err := backfillService.Run(*concurrency)
+	if err != nil {
+		log.Fatalf("backfill failed: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatalf("failed to write report: %v", err)
+	}
+
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+
+	if len(report.Failed) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,61 @@
+// Command embox-fsck checks the media table against the storage backend and the local media directory.
+// It prints a JSON report of media without original or thumbnail and of orphan files,
+// and exits with status 1 if any inconsistency was found.
+//
+// Usage:
+//
+//	embox-fsck [-regenerate-thumbnails] [-delete-orphans] [-mark-broken]
+package main
+
+import (
+	"embox/internal/api/dto"
+	"embox/internal/config"
+	"embox/internal/infrastructure"
+	"embox/internal/repositories"
+	"embox/internal/services"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+)
+
+func main() {
+	var opts dto.FsckRequestDto
+	flag.BoolVar(&opts.RegenerateThumbnails, "regenerate-thumbnails", false, "recreate missing thumbnails from the original file")
+	flag.BoolVar(&opts.DeleteOrphans, "delete-orphans", false, "delete files in the storage and the media dir that no media row points to")
+	flag.BoolVar(&opts.MarkBroken, "mark-broken", false, "flag media rows whose original file is missing")
+	flag.Parse()
+
+	dbConfig := config.LoadDbConfig()
+	apiConfig := config.LoadApiConfig()
+
+	db, err := infrastructure.InitDatabase(dbConfig)
+	if err != nil {
+		log.Fatalf("failed to connect to database: %v", err)
+	}
+
+	repos := repositories.Init(db)
+	storage := services.NewStorage(apiConfig.Storage)
+	// Jobs are not started here, the workers of the API server process pending uploads
+	jobService := services.NewJobService(apiConfig.Media, repos.Job, repos.User)
+	mediaService := services.NewMediaService(apiConfig.Upload, apiConfig.Media, storage, repos.Media, repos.User, jobService)
+	fsckService := services.NewFsckService(storage, mediaService, repos.Media)
+
+	report, err := fsckService.Run(opts)
+	if err != nil {
+		log.Fatalf("consistency check failed: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatalf("failed to write report: %v", err)
+	}
+
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+
+	if report.HasIssues() {
+		os.Exit(1)
+	}
+}
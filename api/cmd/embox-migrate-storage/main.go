@@ -0,0 +1,63 @@
+// Command embox-migrate-storage copies the originals of all media from one storage backend to another,
+// e.g. from LuckyCloud to S3. The source is configured by the STORAGE_* variables and the destination
+// by the same variables with the DEST_STORAGE_ prefix. Each copy is verified by size and SHA-256.
+// Progress is written to a state file, running the command again resumes an interrupted migration.
+// It prints a JSON report and exits with status 1 if any file failed to copy.
+//
+// Usage:
+//
+//	embox-migrate-storage [-from-prefix STORAGE_] [-to-prefix DEST_STORAGE_] [-concurrency 4] [-state migrate-storage.state]
+package main
+
+import (
+	"embox/internal/config"
+	"embox/internal/infrastructure"
+	"embox/internal/repositories"
+	"embox/internal/services"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+)
+
+func main() {
+	fromPrefix := flag.String("from-prefix", "STORAGE_", "environment variable prefix of the source storage")
+	toPrefix := flag.String("to-prefix", "DEST_STORAGE_", "environment variable prefix of the destination storage")
+	concurrency := flag.Int("concurrency", 4, "number of files copied in parallel")
+	stateFile := flag.String("state", "migrate-storage.state", "file recording the copied originals")
+	flag.Parse()
+
+	if *fromPrefix == *toPrefix {
+		log.Fatal("source and destination prefix must differ")
+	}
+
+	dbConfig := config.LoadDbConfig()
+
+	db, err := infrastructure.InitDatabase(dbConfig)
+	if err != nil {
+		log.Fatalf("failed to connect to database: %v", err)
+	}
+
+	repos := repositories.Init(db)
+	src := services.NewStorage(config.LoadStorageConfigWithPrefix(*fromPrefix))
+	dst := services.NewStorage(config.LoadStorageConfigWithPrefix(*toPrefix))
+	migration := services.NewStorageMigration(src, dst, repos.Media, *stateFile, *concurrency)
+
+	report, err := migration.Run()
+	if err != nil {
+		log.Fatalf("migration failed: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatalf("failed to write report: %v", err)
+	}
+
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+
+	if len(report.Failed) > 0 {
+		os.Exit(1)
+	}
+}
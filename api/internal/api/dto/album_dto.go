@@ -22,6 +22,7 @@ type AlbumMediaResponseDto struct {
 	Date        string    `json:"date"` // yyyy-mm-dd
 	Type        string    `json:"type"` // "Image", "Audio", "Video"
 	CreatedAt   time.Time `json:"createdAt"`
+	MediaLayoutDto
 }
 
 type AlbumResponseDto struct {
@@ -0,0 +1,20 @@
+package dto
+
+type BackfillFailureDto struct {
+	Id    uint   `json:"id"`
+	Error string `json:"error"`
+}
+
+type BackfillReportDto struct {
+	CheckedMedia int                  `json:"checkedMedia"`
+	Thumbnails   []uint               `json:"thumbnails"`   // media whose missing renditions were created
+	Previews     []uint               `json:"previews"`     // videos whose animated preview and sprites were created
+	Audio        []uint               `json:"audio"`        // audio files whose info, waveform and cover art were created
+	Transcoded   []uint               `json:"transcoded"`   // videos converted to MP4 and HLS
+	Geocoded     []uint               `json:"geocoded"`     // geotagged media whose place was named
+	Hashed       []uint               `json:"hashed"`       // images and videos whose perceptual hash was computed
+	Checksummed  []uint               `json:"checksummed"`  // media whose content hash (SHA-256 of the original) was computed
+	Placeholders []uint               `json:"placeholders"` // images and videos whose dimensions, dominant colour and BlurHash were stored
+	Reprocessed  []uint               `json:"reprocessed"`  // uploads whose failed processing was run again from the staged original
+	Failed       []BackfillFailureDto `json:"failed"`
+}
@@ -0,0 +1,37 @@
+package dto
+
+// FsckRequestDto selects the repairs of a consistency check. Without any option, the check only reports.
+type FsckRequestDto struct {
+	RegenerateThumbnails bool `json:"regenerateThumbnails"` // recreate missing thumbnails from the original
+	DeleteOrphans        bool `json:"deleteOrphans"`        // delete files no media row points to
+	MarkBroken           bool `json:"markBroken"`           // flag media rows whose original is missing
+}
+
+type FsckMediaDto struct {
+	Id   uint   `json:"id"`
+	Type string `json:"type"`
+	Path string `json:"path"`
+}
+
+type FsckFileDto struct {
+	Location string `json:"location"` // "storage" or "local"
+	Path     string `json:"path"`
+}
+
+type FsckReportDto struct {
+	CheckedMedia      int            `json:"checkedMedia"`
+	MissingOriginals  []FsckMediaDto `json:"missingOriginals"`
+	MissingThumbnails []FsckMediaDto `json:"missingThumbnails"`
+	OrphanFiles       []FsckFileDto  `json:"orphanFiles"`
+
+	// Repairs, only set when requested
+	RegeneratedThumbnails []uint        `json:"regeneratedThumbnails,omitempty"`
+	DeletedOrphans        []FsckFileDto `json:"deletedOrphans,omitempty"`
+	MarkedBroken          []uint        `json:"markedBroken,omitempty"`
+	Errors                []string      `json:"errors,omitempty"`
+}
+
+// HasIssues reports whether any inconsistency was found.
+func (r *FsckReportDto) HasIssues() bool {
+	return len(r.MissingOriginals) > 0 || len(r.MissingThumbnails) > 0 || len(r.OrphanFiles) > 0
+}
@@ -0,0 +1,23 @@
+package dto
+
+// GeoFeatureCollectionDto is a GeoJSON FeatureCollection of media clusters.
+type GeoFeatureCollectionDto struct {
+	Type     string          `json:"type"` // always "FeatureCollection"
+	Features []GeoFeatureDto `json:"features"`
+}
+
+type GeoFeatureDto struct {
+	Type       string                  `json:"type"` // always "Feature"
+	Geometry   GeoPointDto             `json:"geometry"`
+	Properties GeoClusterPropertiesDto `json:"properties"`
+}
+
+type GeoPointDto struct {
+	Type        string     `json:"type"`        // always "Point"
+	Coordinates [2]float64 `json:"coordinates"` // longitude, latitude
+}
+
+type GeoClusterPropertiesDto struct {
+	Count   int  `json:"count"`   // media in the cluster
+	MediaId uint `json:"mediaId"` // latest media of the cluster, e.g. for its thumbnail
+}
@@ -0,0 +1,17 @@
+package dto
+
+import "time"
+
+// JobResponseDto is the state of a background job, e.g. the processing of an upload.
+type JobResponseDto struct {
+	Id          uint      `json:"id"`
+	Type        string    `json:"type"` // "process_media"
+	MediaId     uint      `json:"mediaId,omitempty"`
+	Status      string    `json:"status"`   // "pending" (also while waiting for a retry), "running", "done" or "dead"
+	Progress    int       `json:"progress"` // percent
+	Attempts    int       `json:"attempts"`
+	MaxAttempts int       `json:"maxAttempts"`
+	Error       string    `json:"error,omitempty"` // of the last failed attempt
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
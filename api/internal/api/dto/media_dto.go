@@ -11,6 +11,22 @@ type MediaUploadRequestDto struct {
 	Caption  string `form:"caption"`
 }
 
+// MediaUploadResultDto is the outcome of one file of a multipart upload, in the order of the files.
+// Successful files carry the fields of the created media, failed ones an error.
+type MediaUploadResultDto struct {
+	Index    int    `json:"index"`
+	FileName string `json:"fileName"`
+	Status   int    `json:"status"` // HTTP status of the file: 200, 400, 409, 413, 415 or 500
+	*MediaResponseDto
+	Error *MediaUploadErrorDto `json:"error,omitempty"`
+}
+
+type MediaUploadErrorDto struct {
+	Code       string `json:"code"` // "invalid_date", "duplicate", "too_large", "type_mismatch" or "internal"
+	Message    string `json:"message"`
+	ExistingId uint   `json:"existingId,omitempty"` // duplicate: media with the same content
+}
+
 type MediaResponseDto struct {
 	Id          uint      `json:"id"`
 	IsFavourite bool      `json:"isFavourite"`
@@ -18,6 +34,67 @@ type MediaResponseDto struct {
 	Date        string    `json:"date"` // yyyy-mm-dd
 	Type        string    `json:"type"` // "Image", "Audio", "Video"
 	CreatedAt   time.Time `json:"createdAt"`
+	IsBroken    bool      `json:"isBroken,omitempty"`    // the original file is missing
+	DuplicateOf uint      `json:"duplicateOf,omitempty"` // upload only: ID of existing media with the same content
+	// "pending" while the thumbnails are created and the original is stored in the background, then "done" or "failed"
+	ProcessingStatus string `json:"processingStatus,omitempty"`
+	JobId            uint   `json:"jobId,omitempty"` // upload only: job processing the upload, see /jobs/:id
+	// Videos: "pending" while the web versions (stream.m3u8, video.mp4) are created, then "done" or "failed"
+	TranscodeStatus string `json:"transcodeStatus,omitempty"`
+	// Audio: read from the file, the waveform is at /media/:id/waveform
+	Duration float64 `json:"duration,omitempty"` // seconds
+	Bitrate  int     `json:"bitrate,omitempty"`  // bit/s
+	Title    string  `json:"title,omitempty"`
+	Artist   string  `json:"artist,omitempty"`
+	// Geotagged media: nearest city and its country, e.g. "Hamburg" and "Germany"
+	Place   string `json:"place,omitempty"`
+	Country string `json:"country,omitempty"`
+	MediaLayoutDto
+}
+
+// MediaLayoutDto lets the grid reserve the space of a thumbnail and show a placeholder while it loads.
+// Empty for media without thumbnail and until an upload is processed.
+type MediaLayoutDto struct {
+	Width         int     `json:"width,omitempty"` // pixels as displayed
+	Height        int     `json:"height,omitempty"`
+	AspectRatio   float64 `json:"aspectRatio,omitempty"`   // width / height
+	DominantColor string  `json:"dominantColor,omitempty"` // e.g. "#a0b1c2"
+	BlurHash      string  `json:"blurHash,omitempty"`      // decode with a BlurHash library, see blurha.sh
+}
+
+// MediaListFilterDto restricts the media list to a place or country, matched exactly.
+type MediaListFilterDto struct {
+	Place   string `form:"place"`
+	Country string `form:"country"`
+}
+
+// MediaDetailResponseDto is a media item with the metadata read from its file.
+type MediaDetailResponseDto struct {
+	MediaResponseDto
+	Metadata *MediaMetadataDto `json:"metadata,omitempty"`
+}
+
+// MediaMetadataDto holds the camera and location data of an image. Unknown values are omitted.
+type MediaMetadataDto struct {
+	CameraMake   string   `json:"cameraMake,omitempty"`
+	CameraModel  string   `json:"cameraModel,omitempty"`
+	LensModel    string   `json:"lensModel,omitempty"`
+	FocalLength  *float64 `json:"focalLength,omitempty"`  // mm
+	FNumber      *float64 `json:"fNumber,omitempty"`      // aperture, e.g. 2.8
+	ExposureTime string   `json:"exposureTime,omitempty"` // seconds, e.g. "1/250"
+	ISO          *int     `json:"iso,omitempty"`
+	Width        int      `json:"width,omitempty"`
+	Height       int      `json:"height,omitempty"`
+	Latitude     *float64 `json:"latitude,omitempty"`
+	Longitude    *float64 `json:"longitude,omitempty"`
+	Altitude     *float64 `json:"altitude,omitempty"`   // meters above sea level
+	OffsetTime   string   `json:"offsetTime,omitempty"` // time zone of the capture date, e.g. "+02:00"
+}
+
+// MediaWaveformDto is the peak waveform of an audio file, stored as JSON next to its thumbnails.
+type MediaWaveformDto struct {
+	Duration float64   `json:"duration"` // seconds
+	Peaks    []float64 `json:"peaks"`    // absolute peak per time slice, 0..1
 }
 
 /*
@@ -30,9 +107,27 @@ type MediaUpdateRequestDto struct {
 	ID      uint    `json:"id"`
 	Date    *string `json:"date,omitempty"`    // Optional: yyyy-mm-dd oder nil
 	Caption *string `json:"caption,omitempty"` // Optional: Text oder nil
+	// Optional: set both to place the media on the map, or removeLocation to remove it from there
+	Latitude       *float64 `json:"latitude,omitempty"`
+	Longitude      *float64 `json:"longitude,omitempty"`
+	RemoveLocation bool     `json:"removeLocation,omitempty"`
 }
 
 type MediaUserResponseDto struct {
 	ID   string `json:"id"`
 	Name string `json:"name"`
 }
+
+// DuplicateGroupDto is a group of visually similar media, oldest first.
+type DuplicateGroupDto struct {
+	Distance int                `json:"distance"` // largest Hamming distance of the perceptual hashes to the first media item
+	Media    []MediaResponseDto `json:"media"`
+}
+
+// DuplicateResolveRequestDto keeps one media item of a duplicate group and removes the others.
+type DuplicateResolveRequestDto struct {
+	KeepId    uint   `json:"keepId" binding:"required"`
+	Ids       []uint `json:"ids" binding:"required"` // media moved to the trash, or deleted if Permanent
+	Threshold *int   `json:"threshold"`              // largest Hamming distance of Ids to KeepId, the default threshold if nil
+	Permanent bool   `json:"permanent"`
+}
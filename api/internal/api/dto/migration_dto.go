@@ -0,0 +1,16 @@
+package dto
+
+type MigrationFailureDto struct {
+	Id    uint   `json:"id"`
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+type MigrationReportDto struct {
+	Total    int                   `json:"total"`
+	Copied   int                   `json:"copied"`
+	Skipped  int                   `json:"skipped"` // already copied in a previous run
+	Failed   []MigrationFailureDto `json:"failed"`
+	Bytes    int64                 `json:"bytes"`
+	Duration string                `json:"duration"`
+}
@@ -0,0 +1,28 @@
+package dto
+
+import "time"
+
+type TrashMediaDto struct {
+	MediaResponseDto
+	DeletedAt time.Time `json:"deletedAt"`
+	PurgeAt   time.Time `json:"purgeAt"` // the media is deleted permanently after this time
+}
+
+type TrashAlbumDto struct {
+	Id          uint      `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	MediaCount  int       `json:"mediaCount"`
+	DeletedAt   time.Time `json:"deletedAt"`
+	PurgeAt     time.Time `json:"purgeAt"` // the album is deleted permanently after this time
+}
+
+type TrashResponseDto struct {
+	Media  []TrashMediaDto `json:"media"`
+	Albums []TrashAlbumDto `json:"albums"`
+}
+
+type TrashRestoreRequestDto struct {
+	MediaIDs []uint `json:"mediaIds"`
+	AlbumIDs []uint `json:"albumIds"`
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"embox/internal/api/dto"
+	"embox/internal/api/response"
+	"embox/internal/services"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FsckHandler exposes the storage consistency check to admins
+type FsckHandler struct {
+	userService *services.UserService
+	fsckService *services.FsckService
+}
+
+func NewFsckHandler(userService *services.UserService, fsckService *services.FsckService) *FsckHandler {
+	return &FsckHandler{userService, fsckService}
+}
+
+// Check reports inconsistencies between the media table, the storage and the local media files
+func (h *FsckHandler) Check(c *gin.Context) {
+	if !h.assertAdmin(c) {
+		return
+	}
+
+	h.run(c, dto.FsckRequestDto{})
+}
+
+// Repair runs the check and applies the repairs selected in the request body
+func (h *FsckHandler) Repair(c *gin.Context) {
+	if !h.assertAdmin(c) {
+		return
+	}
+
+	var req dto.FsckRequestDto
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		response.JSONError(c, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+
+	h.run(c, req)
+}
+
+func (h *FsckHandler) run(c *gin.Context, req dto.FsckRequestDto) {
+	report, err := h.fsckService.Run(req)
+	if err != nil {
+		if errors.Is(err, services.ErrFsckRunning) {
+			response.JSONError(c, http.StatusConflict, "Consistency check already running", "")
+			return
+		}
+		response.JSONError(c, http.StatusInternalServerError, "Consistency check failed", err.Error())
+		return
+	}
+
+	response.JSONSuccess(c, report)
+}
+
+func (h *FsckHandler) assertAdmin(c *gin.Context) bool {
+	userEmail, ok := GetContextUserEmail(c)
+	if !ok {
+		response.JSONError(c, http.StatusUnauthorized, "Unauthorized", "")
+		return false
+	}
+	isAdmin, err := h.userService.IsAdmin(userEmail)
+	if err != nil {
+		response.JSONError(c, http.StatusInternalServerError, "Failed to verify permissions", err.Error())
+		return false
+	}
+	if !isAdmin {
+		response.JSONError(c, http.StatusForbidden, "Forbidden", "")
+		return false
+	}
+	return true
+}
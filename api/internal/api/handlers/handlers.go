@@ -13,6 +13,10 @@ type Handlers struct {
 	Media     *MediaHandler
 	Favourite *FavouriteHandler
 	Album     *AlbumHandler
+	Upload    *UploadHandler
+	Fsck      *FsckHandler
+	Trash     *TrashHandler
+	Job       *JobHandler
 }
 
 // Init initializes all handlers with the provided API configuration and services.
@@ -24,6 +28,10 @@ func Init(apiConfig *config.ApiConfig, services *services.Services) *Handlers {
 		Media:     NewMediaHandler(services.User, services.Media),
 		Favourite: NewFavouriteHandler(services.Favourite),
 		Album:     NewAlbumHandler(services.Album),
+		Upload:    NewUploadHandler(services.Upload),
+		Fsck:      NewFsckHandler(services.User, services.Fsck),
+		Trash:     NewTrashHandler(services.Trash),
+		Job:       NewJobHandler(services.Job),
 	}
 }
 
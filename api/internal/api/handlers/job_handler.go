@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"embox/internal/api/response"
+	"embox/internal/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type JobHandler struct {
+	jobService *services.JobService
+}
+
+func NewJobHandler(jobService *services.JobService) *JobHandler {
+	return &JobHandler{jobService}
+}
+
+// GetJob returns the state and progress of a background job, e.g. the processing of an upload
+func (h *JobHandler) GetJob(c *gin.Context) {
+	userEmail, ok := GetContextUserEmail(c)
+	if !ok {
+		response.JSONError(c, http.StatusUnauthorized, "Unauthorized", "")
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.JSONError(c, http.StatusBadRequest, "Invalid job ID", err.Error())
+		return
+	}
+
+	job, err := h.jobService.GetJob(uint(id), userEmail)
+	if err != nil {
+		response.JSONError(c, http.StatusInternalServerError, "Failed to fetch job", err.Error())
+		return
+	}
+	if job == nil {
+		response.JSONError(c, http.StatusNotFound, "Job not found", "")
+		return
+	}
+
+	response.JSONSuccess(c, job)
+}
@@ -6,9 +6,14 @@ import (
 	"embox/internal/api/response"
 	"embox/internal/services"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -21,6 +26,11 @@ type MediaHandler struct {
 //go:embed media/error.webp
 var defaultThumbnail []byte
 
+// defaultThumbnail for clients that did not accept WebP
+//
+//go:embed media/error.jpg
+var defaultThumbnailJPEG []byte
+
 func NewMediaHandler(userService *services.UserService, mediaService *services.MediaService) *MediaHandler {
 	return &MediaHandler{userService, mediaService}
 }
@@ -32,7 +42,13 @@ func (h *MediaHandler) GetMediaList(c *gin.Context) {
 		return
 	}
 
-	results, err := h.mediaService.GetMediaList(userEmail)
+	var filter dto.MediaListFilterDto
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		response.JSONError(c, http.StatusBadRequest, "Invalid filter", err.Error())
+		return
+	}
+
+	results, err := h.mediaService.GetMediaList(userEmail, filter)
 	if err != nil {
 		response.JSONError(c, http.StatusInternalServerError, "Failed to fetch media", err.Error())
 		return
@@ -41,7 +57,145 @@ func (h *MediaHandler) GetMediaList(c *gin.Context) {
 	response.JSONSuccess(c, results)
 }
 
-// Get media thumbnail as blob by ID
+// Get the media within ?bbox=minLon,minLat,maxLon,maxLat as GeoJSON, clustered for the map ?zoom= level
+func (h *MediaHandler) GetMediaGeo(c *gin.Context) {
+	if _, ok := GetContextUserEmail(c); !ok {
+		response.JSONError(c, 401, "Unauthorized", "")
+		return
+	}
+
+	bounds, err := parseBoundingBox(c.Query("bbox"))
+	if err != nil {
+		response.JSONError(c, http.StatusBadRequest, "Invalid bounding box", err.Error())
+		return
+	}
+	zoom, err := strconv.Atoi(c.DefaultQuery("zoom", "0"))
+	if err != nil || zoom < 0 || zoom > services.MaxGeoZoom {
+		response.JSONError(c, http.StatusBadRequest, "Invalid zoom level", c.Query("zoom"))
+		return
+	}
+
+	result, err := h.mediaService.GetGeoClusters(bounds[0], bounds[1], bounds[2], bounds[3], zoom)
+	if err != nil {
+		response.JSONError(c, http.StatusInternalServerError, "Failed to fetch media locations", err.Error())
+		return
+	}
+
+	response.JSONSuccess(c, result)
+}
+
+// Get groups of visually similar media, whose perceptual hashes differ by at most ?threshold= bits
+func (h *MediaHandler) GetMediaDuplicates(c *gin.Context) {
+	userEmail, ok := GetContextUserEmail(c)
+	if !ok {
+		response.JSONError(c, http.StatusUnauthorized, "Unauthorized", "")
+		return
+	}
+
+	threshold, err := strconv.Atoi(c.DefaultQuery("threshold", strconv.Itoa(services.DefaultDuplicateThreshold)))
+	if err != nil || threshold < 0 || threshold > services.MaxDuplicateThreshold {
+		response.JSONError(c, http.StatusBadRequest, "Invalid threshold", fmt.Sprintf("expected 0 to %d", services.MaxDuplicateThreshold))
+		return
+	}
+
+	groups, err := h.mediaService.GetDuplicateGroups(userEmail, threshold)
+	if err != nil {
+		response.JSONError(c, http.StatusInternalServerError, "Failed to fetch duplicates", err.Error())
+		return
+	}
+
+	response.JSONSuccess(c, groups)
+}
+
+// Keep one media item of a duplicate group, trash or delete the others and move their albums and favourites to it
+func (h *MediaHandler) ResolveMediaDuplicates(c *gin.Context) {
+	var payload dto.DuplicateResolveRequestDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		response.JSONError(c, http.StatusBadRequest, "Invalid payload", err.Error())
+		return
+	}
+	if len(payload.Ids) == 0 || slices.Contains(payload.Ids, payload.KeepId) {
+		response.JSONError(c, http.StatusBadRequest, "Invalid payload", "ids must list the media to remove, without keepId")
+		return
+	}
+	threshold := services.DefaultDuplicateThreshold
+	if payload.Threshold != nil {
+		threshold = *payload.Threshold
+	}
+	if threshold < 0 || threshold > services.MaxDuplicateThreshold {
+		response.JSONError(c, http.StatusBadRequest, "Invalid threshold", fmt.Sprintf("expected 0 to %d", services.MaxDuplicateThreshold))
+		return
+	}
+
+	// The kept media item takes over albums and favourites, so it must be the user's as well
+	if !h.assertOwnerOfAll(c, append([]uint{payload.KeepId}, payload.Ids...)) {
+		return
+	}
+
+	if err := h.mediaService.ResolveDuplicates(payload.KeepId, payload.Ids, threshold, payload.Permanent); err != nil {
+		if errors.Is(err, services.ErrNotDuplicate) {
+			response.JSONError(c, http.StatusBadRequest, "Not duplicates", err.Error())
+			return
+		}
+		response.JSONError(c, http.StatusInternalServerError, "Failed to resolve duplicates", err.Error())
+		return
+	}
+
+	response.JSONSuccess(c, gin.H{"message": "Duplicates resolved successfully"})
+}
+
+// parseBoundingBox parses a GeoJSON style bounding box: minLon,minLat,maxLon,maxLat.
+// minLon may be greater than maxLon for boxes spanning the antimeridian.
+func parseBoundingBox(bbox string) ([4]float64, error) {
+	var bounds [4]float64
+	parts := strings.Split(bbox, ",")
+	if len(parts) != 4 {
+		return bounds, fmt.Errorf("expected minLon,minLat,maxLon,maxLat")
+	}
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return bounds, fmt.Errorf("invalid coordinate %q", part)
+		}
+		bounds[i] = value
+	}
+
+	minLon, minLat, maxLon, maxLat := bounds[0], bounds[1], bounds[2], bounds[3]
+	if minLat > maxLat || minLat < -90 || maxLat > 90 || minLon < -180 || minLon > 180 || maxLon < -180 || maxLon > 180 {
+		return bounds, fmt.Errorf("coordinates out of range")
+	}
+	return bounds, nil
+}
+
+// Get a media item with the camera and location metadata read from its file
+func (h *MediaHandler) GetMedia(c *gin.Context) {
+	userEmail, ok := GetContextUserEmail(c)
+	if !ok {
+		response.JSONError(c, 401, "Unauthorized", "")
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		response.JSONError(c, http.StatusBadRequest, "Invalid media ID", err.Error())
+		return
+	}
+
+	result, err := h.mediaService.GetMediaDetail(uint(id), userEmail)
+	if err != nil {
+		response.JSONError(c, http.StatusInternalServerError, "Failed to fetch media", err.Error())
+		return
+	}
+	if result == nil {
+		response.JSONError(c, http.StatusNotFound, "Media not found", "")
+		return
+	}
+
+	response.JSONSuccess(c, result)
+}
+
+// Get media thumbnail as blob by ID, the rendition nearest to ?size= (width in pixels)
+// in the best image format the client accepts (AVIF, WebP or JPEG)
 func (h *MediaHandler) GetMediaThumbnail(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -49,16 +203,82 @@ func (h *MediaHandler) GetMediaThumbnail(c *gin.Context) {
 		return
 	}
 
-	filePath, _, err := h.mediaService.GetThumbnail(uint(id))
+	size := 0
+	if param := c.Query("size"); param != "" {
+		size, err = strconv.Atoi(param)
+		if err != nil || size <= 0 {
+			response.JSONError(c, http.StatusBadRequest, "Invalid thumbnail size", param)
+			return
+		}
+	}
+
+	// The response depends on the Accept header, also when the default thumbnail is sent
+	c.Header("Vary", "Accept")
+
+	format := negotiateThumbnailFormat(c.GetHeader("Accept"))
+	filePath, exact, err := h.mediaService.GetThumbnail(uint(id), size, format)
 	if err != nil {
-		c.Data(http.StatusOK, "image/webp", defaultThumbnail)
+		if format == services.ThumbnailFormatJPEG {
+			c.Data(http.StatusOK, "image/jpeg", defaultThumbnailJPEG)
+		} else {
+			c.Data(http.StatusOK, "image/webp", defaultThumbnail)
+		}
 		return
 	}
 
-	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	if exact {
+		c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		// A nearer rendition may be generated later by embox-backfill
+		c.Header("Cache-Control", "public, max-age=3600")
+	}
 	c.File(filePath)
 }
 
+// negotiateThumbnailFormat picks the thumbnail format from the Accept header.
+// AVIF and WebP are only sent if the client lists them explicitly, as older Safari versions
+// accept image/* without being able to decode WebP. JPEG is the fallback every client decodes.
+// Without Accept header, WebP is sent.
+func negotiateThumbnailFormat(accept string) string {
+	if strings.TrimSpace(accept) == "" {
+		return services.ThumbnailFormatWebP
+	}
+
+	quality := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		quality[strings.ToLower(strings.TrimSpace(mediaType))] = q
+	}
+
+	jpegQuality, ok := quality["image/jpeg"]
+	if !ok {
+		jpegQuality, ok = quality["image/*"]
+	}
+	if !ok {
+		jpegQuality = quality["*/*"]
+	}
+
+	// Highest quality wins, on a tie the smaller format
+	best, bestQuality := services.ThumbnailFormatJPEG, jpegQuality
+	for _, candidate := range []struct{ format, mediaType string }{
+		{services.ThumbnailFormatWebP, "image/webp"},
+		{services.ThumbnailFormatAVIF, "image/avif"},
+	} {
+		if q, ok := quality[candidate.mediaType]; ok && q > 0 && q >= bestQuality {
+			best, bestQuality = candidate.format, q
+		}
+	}
+	return best
+}
+
 // Get media original file from storage by ID
 func (h *MediaHandler) GetMediaFile(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
@@ -84,6 +304,9 @@ func (h *MediaHandler) GetMediaFile(c *gin.Context) {
 	if acceptHdr := resp.Header.Get("Accept-Ranges"); acceptHdr != "" {
 		c.Header("Accept-Ranges", acceptHdr)
 	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		c.Header("ETag", etag)
+	}
 
 	// Set Cache-Control and Last-Modified for better caching behaviour
 	c.Header("Cache-Control", "public, max-age=31536000, immutable")
@@ -96,7 +319,114 @@ func (h *MediaHandler) GetMediaFile(c *gin.Context) {
 	io.Copy(c.Writer, resp.Body)
 }
 
-// Upload one or multiple media files
+// Get the HLS master playlist of a video. While the video is being transcoded,
+// the client is redirected to the original file.
+func (h *MediaHandler) GetMediaStream(c *gin.Context) {
+	h.serveTranscoded(c, func(id uint) (string, error) {
+		return h.mediaService.GetStreamFile(id, "")
+	})
+}
+
+// Get a variant playlist or segment of the HLS stream of a video
+func (h *MediaHandler) GetMediaStreamFile(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		response.JSONError(c, http.StatusBadRequest, "Invalid media ID", err.Error())
+		return
+	}
+
+	filePath, err := h.mediaService.GetStreamFile(uint(id), c.Param("file"))
+	if err != nil {
+		response.JSONError(c, http.StatusNotFound, "File not found", err.Error())
+		return
+	}
+
+	serveStreamFile(c, filePath)
+}
+
+// Get the H.264/AAC MP4 of a video. While the video is being transcoded,
+// the client is redirected to the original file.
+func (h *MediaHandler) GetMediaWebVideo(c *gin.Context) {
+	h.serveTranscoded(c, h.mediaService.GetWebVideo)
+}
+
+func (h *MediaHandler) serveTranscoded(c *gin.Context, getFile func(id uint) (string, error)) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		response.JSONError(c, http.StatusBadRequest, "Invalid media ID", err.Error())
+		return
+	}
+
+	filePath, err := getFile(uint(id))
+	if errors.Is(err, services.ErrNotTranscoded) {
+		// Resolved against the request path to /media/:id/file
+		c.Header("Cache-Control", "no-store")
+		c.Redirect(http.StatusTemporaryRedirect, "file")
+		return
+	}
+	if err != nil {
+		response.JSONError(c, http.StatusNotFound, "File not found", err.Error())
+		return
+	}
+
+	serveStreamFile(c, filePath)
+}
+
+// serveStreamFile sends a transcoded file with Range support. The files are replaced if the video is transcoded again.
+func serveStreamFile(c *gin.Context, filePath string) {
+	switch filepath.Ext(filePath) {
+	case ".m3u8":
+		c.Header("Content-Type", "application/vnd.apple.mpegurl")
+	case ".ts":
+		c.Header("Content-Type", "video/mp2t")
+	case ".mp4":
+		c.Header("Content-Type", "video/mp4")
+	}
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.File(filePath)
+}
+
+// Get the animated WebP preview of a video, e.g. for hovering it in the grid
+func (h *MediaHandler) GetMediaPreview(c *gin.Context) {
+	h.servePreviewFile(c, services.PreviewFileAnimation, "image/webp")
+}
+
+// Get the WebVTT file of the seek bar sprites of a video
+func (h *MediaHandler) GetMediaSpriteVtt(c *gin.Context) {
+	h.servePreviewFile(c, services.PreviewFileSpriteVtt, "text/vtt; charset=utf-8")
+}
+
+// Get the sprite sheet referenced by sprites.vtt
+func (h *MediaHandler) GetMediaSprites(c *gin.Context) {
+	h.servePreviewFile(c, services.PreviewFileSprites, "image/jpeg")
+}
+
+// Get the peak waveform of an audio file as JSON
+func (h *MediaHandler) GetMediaWaveform(c *gin.Context) {
+	h.servePreviewFile(c, services.PreviewFileWaveform, "application/json; charset=utf-8")
+}
+
+func (h *MediaHandler) servePreviewFile(c *gin.Context, name, contentType string) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		response.JSONError(c, http.StatusBadRequest, "Invalid media ID", err.Error())
+		return
+	}
+
+	filePath, err := h.mediaService.GetPreviewFile(uint(id), name)
+	if err != nil {
+		response.JSONError(c, http.StatusNotFound, "File not found", err.Error())
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	// Regenerated by embox-backfill or embox-fsck, so not immutable
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.File(filePath)
+}
+
+// Upload one or multiple media files, responding with the result of each file.
+// 207 if only some files failed; if none was stored the request fails with the status of the file.
 func (h *MediaHandler) UploadMedia(c *gin.Context) {
 	form, err := c.MultipartForm()
 	if err != nil {
@@ -110,14 +440,6 @@ func (h *MediaHandler) UploadMedia(c *gin.Context) {
 		return
 	}
 
-	const maxFileSize = 500 << 20 // 500 MB
-	for _, f := range files {
-		if f.Size > maxFileSize {
-			response.JSONError(c, http.StatusRequestEntityTooLarge, "File too large", "maximum file size is 500 MB")
-			return
-		}
-	}
-
 	metaJson := c.PostForm("meta")
 	var metaList []dto.MediaUploadRequestDto
 	if err := json.Unmarshal([]byte(metaJson), &metaList); err != nil {
@@ -141,7 +463,57 @@ func (h *MediaHandler) UploadMedia(c *gin.Context) {
 		return
 	}
 
-	response.JSONSuccess(c, uploaded)
+	// 200 if all files were stored, 207 with the status of each file if some were
+	results := make([]dto.MediaUploadResultDto, len(uploaded))
+	var failed []dto.MediaUploadResultDto
+	for i, result := range uploaded {
+		results[i] = dto.MediaUploadResultDto{
+			Index:            i,
+			FileName:         files[i].Filename,
+			Status:           http.StatusOK,
+			MediaResponseDto: result.Media,
+		}
+		if result.Err != nil {
+			results[i].Status, results[i].Error = uploadError(result.Err)
+			failed = append(failed, results[i])
+		}
+	}
+
+	switch {
+	case len(failed) == 0:
+		response.JSONSuccess(c, results)
+	case len(failed) < len(results):
+		response.JSONMultiStatus(c, results)
+	default:
+		// Nothing was stored, fail like a single file would; the highest status wins if they differ
+		status := 0
+		details := make([]string, len(failed))
+		for i, result := range failed {
+			status = max(status, result.Status)
+			details[i] = result.Error.Message
+			if len(failed) > 1 {
+				details[i] = result.FileName + ": " + details[i]
+			}
+		}
+		response.JSONError(c, status, "Failed to upload media", strings.Join(details, "; "))
+	}
+}
+
+// uploadError maps an error of a single uploaded file to its HTTP status and error code.
+func uploadError(err error) (int, *dto.MediaUploadErrorDto) {
+	var duplicateErr *services.DuplicateError
+	switch {
+	case errors.As(err, &duplicateErr):
+		return http.StatusConflict, &dto.MediaUploadErrorDto{Code: "duplicate", Message: err.Error(), ExistingId: duplicateErr.ExistingID}
+	case errors.Is(err, services.ErrUploadTooLarge):
+		return http.StatusRequestEntityTooLarge, &dto.MediaUploadErrorDto{Code: "too_large", Message: "maximum file size is 500 MB"}
+	case errors.Is(err, services.ErrTypeMismatch):
+		return http.StatusUnsupportedMediaType, &dto.MediaUploadErrorDto{Code: "type_mismatch", Message: err.Error()}
+	case errors.Is(err, services.ErrInvalidDate):
+		return http.StatusBadRequest, &dto.MediaUploadErrorDto{Code: "invalid_date", Message: err.Error()}
+	default:
+		return http.StatusInternalServerError, &dto.MediaUploadErrorDto{Code: "internal", Message: err.Error()}
+	}
 }
 
 func (h *MediaHandler) assertOwnerOfAll(c *gin.Context, ids []uint) bool {
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"embox/internal/api/dto"
+	"embox/internal/api/response"
+	"embox/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TrashHandler struct {
+	trashService *services.TrashService
+}
+
+func NewTrashHandler(trashService *services.TrashService) *TrashHandler {
+	return &TrashHandler{trashService}
+}
+
+// GetTrash lists the deleted media and albums of the user that have not been purged yet
+func (h *TrashHandler) GetTrash(c *gin.Context) {
+	userEmail, ok := GetContextUserEmail(c)
+	if !ok {
+		response.JSONError(c, http.StatusUnauthorized, "Unauthorized", "")
+		return
+	}
+
+	trash, err := h.trashService.GetTrash(userEmail)
+	if err != nil {
+		response.JSONError(c, http.StatusInternalServerError, "Failed to retrieve trash", err.Error())
+		return
+	}
+
+	response.JSONSuccess(c, trash)
+}
+
+// Restore moves media and albums out of the trash
+func (h *TrashHandler) Restore(c *gin.Context) {
+	userEmail, ok := GetContextUserEmail(c)
+	if !ok {
+		response.JSONError(c, http.StatusUnauthorized, "Unauthorized", "")
+		return
+	}
+
+	var payload dto.TrashRestoreRequestDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		response.JSONError(c, http.StatusBadRequest, "Invalid payload", err.Error())
+		return
+	}
+	if len(payload.MediaIDs) == 0 && len(payload.AlbumIDs) == 0 {
+		response.JSONError(c, http.StatusBadRequest, "No IDs provided", "")
+		return
+	}
+
+	owned, err := h.trashService.IsOwnerOfAll(userEmail, payload)
+	if err != nil {
+		response.JSONError(c, http.StatusInternalServerError, "Failed to verify ownership", err.Error())
+		return
+	}
+	if !owned {
+		response.JSONError(c, http.StatusForbidden, "Forbidden", "")
+		return
+	}
+
+	if err := h.trashService.Restore(payload); err != nil {
+		response.JSONError(c, http.StatusInternalServerError, "Failed to restore", err.Error())
+		return
+	}
+
+	response.JSONSuccess(c, gin.H{"message": "Restored successfully"})
+}
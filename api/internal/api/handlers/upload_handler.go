@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"embox/internal/api/dto"
+	"embox/internal/api/response"
+	"embox/internal/services"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const tusVersion = "1.0.0"
+
+// UploadHandler implements the tus 1.0 resumable upload protocol (core + creation, expiration, termination).
+// See https://tus.io/protocols/resumable-upload
+type UploadHandler struct {
+	uploadService *services.UploadService
+}
+
+func NewUploadHandler(uploadService *services.UploadService) *UploadHandler {
+	return &UploadHandler{uploadService}
+}
+
+// Options announces the supported tus version and extensions
+func (h *UploadHandler) Options(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", "creation,expiration,termination")
+	c.Header("Tus-Max-Size", strconv.Itoa(services.MaxFileSize))
+	c.Status(http.StatusNoContent)
+}
+
+// Create a new upload. The media metadata is passed in the Upload-Metadata header.
+func (h *UploadHandler) Create(c *gin.Context) {
+	if !h.assertTusResumable(c) {
+		return
+	}
+
+	userEmail, ok := GetContextUserEmail(c)
+	if !ok {
+		response.JSONError(c, http.StatusUnauthorized, "Unauthorized", "")
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		response.JSONError(c, http.StatusBadRequest, "Invalid Upload-Length", "")
+		return
+	}
+
+	meta, err := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		response.JSONError(c, http.StatusBadRequest, "Invalid Upload-Metadata", err.Error())
+		return
+	}
+
+	info, err := h.uploadService.Create(length, meta, userEmail)
+	if err != nil {
+		if errors.Is(err, services.ErrUploadTooLarge) {
+			response.JSONError(c, http.StatusRequestEntityTooLarge, "File too large", "maximum file size is 500 MB")
+			return
+		}
+		response.JSONError(c, http.StatusInternalServerError, "Failed to create upload", err.Error())
+		return
+	}
+
+	// Relative to the creation URL, so it also works behind the /api proxy prefix
+	c.Header("Location", info.ID)
+	c.Header("Upload-Expires", info.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusCreated)
+}
+
+// Status returns the current offset of an upload
+func (h *UploadHandler) Status(c *gin.Context) {
+	if !h.assertTusResumable(c) {
+		return
+	}
+
+	userEmail, ok := GetContextUserEmail(c)
+	if !ok {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	info, err := h.uploadService.Get(c.Param("uploadId"), userEmail)
+	if err != nil {
+		// HEAD responses carry no body
+		if errors.Is(err, services.ErrUploadNotFound) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(info.Length, 10))
+	c.Header("Upload-Expires", info.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusOK)
+}
+
+// Patch appends a chunk to an upload. The completed upload is passed to the media pipeline.
+func (h *UploadHandler) Patch(c *gin.Context) {
+	if !h.assertTusResumable(c) {
+		return
+	}
+
+	userEmail, ok := GetContextUserEmail(c)
+	if !ok {
+		response.JSONError(c, http.StatusUnauthorized, "Unauthorized", "")
+		return
+	}
+
+	if c.ContentType() != "application/offset+octet-stream" {
+		response.JSONError(c, http.StatusUnsupportedMediaType, "Invalid Content-Type", "expected application/offset+octet-stream")
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		response.JSONError(c, http.StatusBadRequest, "Invalid Upload-Offset", "")
+		return
+	}
+
+	info, media, err := h.uploadService.WriteChunk(c.Param("uploadId"), userEmail, offset, c.Request.Body)
+	if info != nil {
+		c.Header("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+		c.Header("Upload-Expires", info.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUploadNotFound):
+			response.JSONError(c, http.StatusNotFound, "Upload not found", "")
+		case errors.Is(err, services.ErrUploadOffsetMismatch):
+			response.JSONError(c, http.StatusConflict, "Upload offset mismatch", fmt.Sprintf("expected offset %d", info.Offset))
+		case errors.Is(err, services.ErrUploadLocked):
+			response.JSONError(c, http.StatusLocked, "Upload is locked", "another request is writing to this upload")
+		default:
+			// The completed upload failed in the media pipeline, e.g. as a duplicate
+			status, uploadErr := uploadError(err)
+			if uploadErr.ExistingId != 0 {
+				c.Header("X-Duplicate-Of", strconv.FormatUint(uint64(uploadErr.ExistingId), 10))
+			}
+			if status == http.StatusInternalServerError {
+				slog.Error("failed to write upload chunk", "upload", c.Param("uploadId"), "err", err)
+			}
+			response.JSONError(c, status, "Failed to upload media", uploadErr.Message)
+		}
+		return
+	}
+
+	if media != nil {
+		c.Header("X-Media-Id", strconv.FormatUint(uint64(media.ID), 10))
+		if media.DuplicateOf != 0 {
+			c.Header("X-Duplicate-Of", strconv.FormatUint(uint64(media.DuplicateOf), 10))
+		}
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Terminate cancels an upload and removes its data
+func (h *UploadHandler) Terminate(c *gin.Context) {
+	if !h.assertTusResumable(c) {
+		return
+	}
+
+	userEmail, ok := GetContextUserEmail(c)
+	if !ok {
+		response.JSONError(c, http.StatusUnauthorized, "Unauthorized", "")
+		return
+	}
+
+	if err := h.uploadService.Terminate(c.Param("uploadId"), userEmail); err != nil {
+		switch {
+		case errors.Is(err, services.ErrUploadNotFound):
+			response.JSONError(c, http.StatusNotFound, "Upload not found", "")
+		case errors.Is(err, services.ErrUploadLocked):
+			response.JSONError(c, http.StatusLocked, "Upload is locked", "another request is writing to this upload")
+		default:
+			response.JSONError(c, http.StatusInternalServerError, "Failed to terminate upload", err.Error())
+		}
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// assertTusResumable sets the Tus-Resumable response header and rejects requests for other protocol versions.
+func (h *UploadHandler) assertTusResumable(c *gin.Context) bool {
+	c.Header("Tus-Resumable", tusVersion)
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.AbortWithStatus(http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// parseUploadMetadata decodes the tus Upload-Metadata header ("key base64value,key base64value")
+// into the same DTO as used by the multipart upload. The common tus client keys
+// "filename" and "filetype" are accepted as aliases for "fileName" and "type".
+func parseUploadMetadata(header string) (dto.MediaUploadRequestDto, error) {
+	var meta dto.MediaUploadRequestDto
+
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return meta, fmt.Errorf("invalid base64 value for key %q", key)
+		}
+
+		switch key {
+		case "fileName", "filename":
+			meta.FileName = string(value)
+		case "type", "filetype":
+			meta.Type = string(value)
+		case "date":
+			meta.Date = string(value)
+		case "caption":
+			meta.Caption = string(value)
+		}
+	}
+
+	if meta.FileName == "" || meta.Type == "" {
+		return meta, fmt.Errorf("fileName and type are required")
+	}
+
+	return meta, nil
+}
@@ -10,10 +10,15 @@ import (
 // CORSMiddleware sets up CORS headers for the Gin router.
 func CORSMiddleware(cfg *config.ServerConfig) gin.HandlerFunc {
 	return cors.New(cors.Config{
-		AllowOrigins:     cfg.CorsOrigins,
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "x-xsrf-token", "X-XSRF-TOKEN"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowOrigins: cfg.CorsOrigins,
+		AllowMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders: []string{"Origin", "Content-Type", "Accept", "Authorization", "x-xsrf-token", "X-XSRF-TOKEN",
+			// tus resumable uploads
+			"Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata"},
+		ExposeHeaders: []string{"Content-Length",
+			// tus resumable uploads
+			"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size",
+			"Upload-Offset", "Upload-Length", "Upload-Expires", "X-Media-Id", "X-Duplicate-Of"},
 		AllowCredentials: true,
 		// MaxAge specifies how long (in seconds) the results of a preflight request (OPTIONS)
 		// can be cached by the browser. Here, 12 * time.Hour means the browser will cache
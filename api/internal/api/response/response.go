@@ -34,6 +34,15 @@ func JSONCreated(c *gin.Context, data any) {
 	})
 }
 
+// JSONMultiStatus responds with 207 Multi-Status for requests whose items succeeded or failed individually;
+// each item of data carries its own status.
+func JSONMultiStatus(c *gin.Context, data any) {
+	c.JSON(http.StatusMultiStatus, SuccessResponse{
+		Status: "multi-status",
+		Data:   data,
+	})
+}
+
 func JSONError(c *gin.Context, code int, message string, details string) {
 	c.JSON(code, ErrorResponse{
 		Status:  "error",
@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"embox/internal/api/handlers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Binds all admin maintenance routes to the router
+func RegisterAdminRoutes(group *gin.RouterGroup, fsckHandler *handlers.FsckHandler) {
+	group.GET("/fsck", fsckHandler.Check)
+	group.POST("/fsck", fsckHandler.Repair)
+}
@@ -0,0 +1,11 @@
+package routes
+
+import (
+	"embox/internal/api/handlers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterJobRoutes(group *gin.RouterGroup, jobHandler *handlers.JobHandler) {
+	group.GET("/:id", jobHandler.GetJob)
+}
@@ -8,8 +8,19 @@ import (
 
 func RegisterMediaRoutes(group *gin.RouterGroup, mediaHandler *handlers.MediaHandler) {
 	group.GET("/", mediaHandler.GetMediaList)
+	group.GET("/geo", mediaHandler.GetMediaGeo)
+	group.GET("/duplicates", mediaHandler.GetMediaDuplicates)
+	group.POST("/duplicates/resolve", mediaHandler.ResolveMediaDuplicates)
+	group.GET("/:id", mediaHandler.GetMedia)
 	group.GET("/:id/thumbnail", mediaHandler.GetMediaThumbnail)
 	group.GET("/:id/file", mediaHandler.GetMediaFile)
+	group.GET("/:id/preview", mediaHandler.GetMediaPreview)
+	group.GET("/:id/sprites.vtt", mediaHandler.GetMediaSpriteVtt)
+	group.GET("/:id/sprites.jpg", mediaHandler.GetMediaSprites)
+	group.GET("/:id/waveform", mediaHandler.GetMediaWaveform)
+	group.GET("/:id/video.mp4", mediaHandler.GetMediaWebVideo)
+	group.GET("/:id/stream.m3u8", mediaHandler.GetMediaStream)
+	group.GET("/:id/stream/:file", mediaHandler.GetMediaStreamFile)
 	group.POST("/", mediaHandler.UploadMedia)
 	group.PUT("/", mediaHandler.UpdateMedia)
 	group.DELETE("/", mediaHandler.DeleteMedia)
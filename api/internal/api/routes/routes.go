@@ -13,14 +13,16 @@ import (
 
 // SetupRouter initializes the Gin router with all routes and middleware.
 // It sets up the necessary handlers and applies middleware for logging, CORS, CSRF protection and authentication.
-func Init(db *gorm.DB, apiConfig *config.ApiConfig) *gin.Engine {
+// The services are returned as well so that the caller can start their background jobs.
+func Init(db *gorm.DB, apiConfig *config.ApiConfig) (*gin.Engine, *services.Services) {
 	repos := repositories.Init(db)
 	services := services.Init(apiConfig, repos)
 	handlers := handlers.Init(apiConfig, services)
 
 	gin.SetMode(apiConfig.Router.ReleaseMode)
 	router := gin.New()
-	router.MaxMultipartMemory = 500 << 20 // 500 MB
+	// Multipart files beyond this size are spooled to disk by Go instead of being held in memory.
+	router.MaxMultipartMemory = 8 << 20 // 8 MB
 
 	// Recover middleware recovers from any panics and writes a 500 if there was one.
 	router.Use(gin.Recovery())
@@ -48,6 +50,10 @@ func Init(db *gorm.DB, apiConfig *config.ApiConfig) *gin.Engine {
 	mediaGroup.Use(middleware.RequireAuthMiddleware())
 	RegisterMediaRoutes(mediaGroup, handlers.Media)
 
+	uploadGroup := router.Group("/media/uploads")
+	uploadGroup.Use(middleware.RequireAuthMiddleware())
+	RegisterUploadRoutes(uploadGroup, handlers.Upload)
+
 	favouriteGroup := router.Group("/favourite")
 	favouriteGroup.Use(middleware.RequireAuthMiddleware())
 	RegisterFavouriteRoutes(favouriteGroup, handlers.Favourite)
@@ -56,5 +62,17 @@ func Init(db *gorm.DB, apiConfig *config.ApiConfig) *gin.Engine {
 	albumGroup.Use(middleware.RequireAuthMiddleware())
 	RegisterAlbumRoutes(albumGroup, handlers.Album)
 
-	return router
+	trashGroup := router.Group("/trash")
+	trashGroup.Use(middleware.RequireAuthMiddleware())
+	RegisterTrashRoutes(trashGroup, handlers.Trash)
+
+	jobGroup := router.Group("/jobs")
+	jobGroup.Use(middleware.RequireAuthMiddleware())
+	RegisterJobRoutes(jobGroup, handlers.Job)
+
+	adminGroup := router.Group("/admin")
+	adminGroup.Use(middleware.RequireAuthMiddleware())
+	RegisterAdminRoutes(adminGroup, handlers.Fsck)
+
+	return router, services
 }
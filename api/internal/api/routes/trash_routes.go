@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"embox/internal/api/handlers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterTrashRoutes(group *gin.RouterGroup, trashHandler *handlers.TrashHandler) {
+	group.GET("/", trashHandler.GetTrash)
+	group.POST("/restore", trashHandler.Restore)
+}
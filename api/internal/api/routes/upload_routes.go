@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"embox/internal/api/handlers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Binds the resumable (tus) upload routes to the router
+func RegisterUploadRoutes(group *gin.RouterGroup, uploadHandler *handlers.UploadHandler) {
+	group.OPTIONS("/", uploadHandler.Options)
+	group.POST("/", uploadHandler.Create)
+	group.HEAD("/:uploadId", uploadHandler.Status)
+	group.PATCH("/:uploadId", uploadHandler.Patch)
+	group.DELETE("/:uploadId", uploadHandler.Terminate)
+}
@@ -7,6 +7,9 @@ type ApiConfig struct {
 	Auth    *AuthConfig
 	Storage *StorageConfig
 	Email   *EmailConfig
+	Upload  *UploadConfig
+	Media   *MediaConfig
+	Trash   *TrashConfig
 }
 
 func LoadApiConfig() *ApiConfig {
@@ -18,5 +21,8 @@ func LoadApiConfig() *ApiConfig {
 		Auth:    LoadAuthConfig(server.Domain, server.IsSecure),
 		Storage: LoadStorageConfig(),
 		Email:   LoadEmailConfig(),
+		Upload:  LoadUploadConfig(),
+		Media:   LoadMediaConfig(),
+		Trash:   LoadTrashConfig(),
 	}
 }
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"embox/pkg/env"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type MediaConfig struct {
+	ThumbnailSizes []int // Max widths in pixels of the WebP renditions generated for images and video posters
+
+	Transcode        bool  // Transcode videos to H.264/AAC (MP4 and HLS) in the background
+	TranscodeWorkers int   // Number of videos transcoded in parallel
+	HlsHeights       []int // Heights in pixels of the HLS renditions, the largest one is also used for the MP4
+
+	GeoNamesFile string // GeoNames cities export (.txt or .zip) used to name the places of geotagged media, empty disables it
+
+	JobWorkers     int // Number of uploads processed in parallel in the background, 0 processes them within the request
+	JobMaxAttempts int // Runs of a background job before it is given up
+	JobRetryDelay  int // Seconds before a failed job is retried, doubled with every further attempt
+}
+
+func LoadMediaConfig() *MediaConfig {
+	return &MediaConfig{
+		ThumbnailSizes: getEnvAsSizes("MEDIA_THUMBNAIL_SIZES", []string{"256", "512", "1600", "2560"}),
+
+		Transcode:        env.GetEnvAsBool("MEDIA_TRANSCODE", true),
+		TranscodeWorkers: env.GetEnvAsInt("MEDIA_TRANSCODE_WORKERS", 1),
+		HlsHeights:       getEnvAsSizes("MEDIA_HLS_HEIGHTS", []string{"360", "720", "1080"}),
+
+		GeoNamesFile: env.GetEnv("MEDIA_GEONAMES_FILE", ""),
+
+		JobWorkers:     env.GetEnvAsInt("MEDIA_JOB_WORKERS", 2),
+		JobMaxAttempts: env.GetEnvAsInt("MEDIA_JOB_MAX_ATTEMPTS", 5),
+		JobRetryDelay:  env.GetEnvAsInt("MEDIA_JOB_RETRY_DELAY", 30),
+	}
+}
+
+// getEnvAsSizes parses a comma separated list of pixel sizes, sorted ascending without duplicates.
+func getEnvAsSizes(envName string, defaultValue []string) []int {
+	var sizes []int
+	for _, value := range env.GetEnvSlice(envName, defaultValue) {
+		size, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || size <= 0 {
+			slog.Warn("ignoring invalid size", "env", envName, "value", value)
+			continue
+		}
+		sizes = append(sizes, size)
+	}
+	slices.Sort(sizes)
+	return slices.Compact(sizes)
+}
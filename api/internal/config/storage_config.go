@@ -5,21 +5,67 @@ import (
 )
 
 type StorageConfig struct {
-	Adapter  string // "luckycloud" | "local"
+	Adapter  string // "luckycloud" | "local" | "s3" | "webdav"
 	LocalDir string
 	Url      string
 	Username string
 	Password string
 	RepoID   string
+
+	// LuckyCloud API timeouts (seconds) and retries
+	Timeout         int // overall timeout of API calls
+	ConnectTimeout  int // dial and TLS handshake
+	ResponseTimeout int // time to the first response byte of file transfers
+	MaxRetries      int
+	RetryDelay      int // base delay of the exponential backoff in milliseconds
+
+	// S3-compatible object storage (AWS, MinIO, Garage, ...)
+	S3Endpoint  string // e.g. "http://localhost:9000"
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+	S3PathStyle bool // true: endpoint/bucket/key, false: bucket.endpoint/key
+
+	// WebDAV (LuckyCloud, Nextcloud, NAS), authenticated with Username and Password
+	WebdavUrl string // e.g. "https://webdav.luckycloud.de/embox"
+
+	// Client-side encryption of originals, enabled if a key is set (32 bytes, hex or base64)
+	EncryptionKey     string
+	EncryptionKeyFile string
 }
 
 func LoadStorageConfig() *StorageConfig {
+	return LoadStorageConfigWithPrefix("STORAGE_")
+}
+
+// LoadStorageConfigWithPrefix loads a storage config from variables with another prefix than "STORAGE_",
+// e.g. "DEST_STORAGE_ADAPTER" for the destination of embox-migrate-storage.
+func LoadStorageConfigWithPrefix(prefix string) *StorageConfig {
 	return &StorageConfig{
-		Adapter:  env.GetEnv("STORAGE_ADAPTER", "luckycloud"),
-		LocalDir: env.GetEnv("STORAGE_LOCAL_DIR", "./dev-storage"),
-		Url:      env.GetEnv("STORAGE_URL", "https://sync.luckycloud.de/api2"),
-		Username: env.GetEnv("STORAGE_USERNAME", ""),
-		Password: env.GetEnv("STORAGE_PASSWORD", ""),
-		RepoID:   env.GetEnv("STORAGE_REPO_ID", ""),
+		Adapter:  env.GetEnv(prefix+"ADAPTER", "luckycloud"),
+		LocalDir: env.GetEnv(prefix+"LOCAL_DIR", "./dev-storage"),
+		Url:      env.GetEnv(prefix+"URL", "https://sync.luckycloud.de/api2"),
+		Username: env.GetEnv(prefix+"USERNAME", ""),
+		Password: env.GetEnv(prefix+"PASSWORD", ""),
+		RepoID:   env.GetEnv(prefix+"REPO_ID", ""),
+
+		Timeout:         env.GetEnvAsInt(prefix+"TIMEOUT", 30),
+		ConnectTimeout:  env.GetEnvAsInt(prefix+"CONNECT_TIMEOUT", 10),
+		ResponseTimeout: env.GetEnvAsInt(prefix+"RESPONSE_TIMEOUT", 5*60), // 5 minutes, the server may process large uploads
+		MaxRetries:      env.GetEnvAsInt(prefix+"MAX_RETRIES", 3),
+		RetryDelay:      env.GetEnvAsInt(prefix+"RETRY_DELAY", 500),
+
+		S3Endpoint:  env.GetEnv(prefix+"S3_ENDPOINT", "http://localhost:9000"),
+		S3Region:    env.GetEnv(prefix+"S3_REGION", "us-east-1"),
+		S3Bucket:    env.GetEnv(prefix+"S3_BUCKET", "embox"),
+		S3AccessKey: env.GetEnv(prefix+"S3_ACCESS_KEY", ""),
+		S3SecretKey: env.GetEnv(prefix+"S3_SECRET_KEY", ""),
+		S3PathStyle: env.GetEnvAsBool(prefix+"S3_PATH_STYLE", true),
+
+		WebdavUrl: env.GetEnv(prefix+"WEBDAV_URL", ""),
+
+		EncryptionKey:     env.GetEnv(prefix+"ENCRYPTION_KEY", ""),
+		EncryptionKeyFile: env.GetEnv(prefix+"ENCRYPTION_KEY_FILE", ""),
 	}
 }
@@ -0,0 +1,17 @@
+package config
+
+import (
+	"embox/pkg/env"
+)
+
+type TrashConfig struct {
+	Retention     int // Days until deleted media and albums are purged from the trash
+	PurgeInterval int // Seconds between runs of the purge job, 0 disables it
+}
+
+func LoadTrashConfig() *TrashConfig {
+	return &TrashConfig{
+		Retention:     env.GetEnvAsInt("TRASH_RETENTION_DAYS", 30),
+		PurgeInterval: env.GetEnvAsInt("TRASH_PURGE_INTERVAL", 60*60), // 1 hour
+	}
+}
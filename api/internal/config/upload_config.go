@@ -0,0 +1,42 @@
+package config
+
+import (
+	"embox/pkg/env"
+	"fmt"
+	"log"
+	"slices"
+)
+
+// DuplicatePolicies are the valid values of UPLOAD_DUPLICATE_POLICY.
+var DuplicatePolicies = []string{"reject", "existing", "allow"}
+
+type UploadConfig struct {
+	StagingDir string // Directory for unfinished resumable (tus) uploads
+	Expiration int    // Seconds until an unfinished resumable upload expires
+	// How uploads with the same content (SHA-256) as existing media are handled:
+	// "reject" fails the upload, "existing" returns the existing media instead, "allow" stores the copy
+	DuplicatePolicy string
+	Concurrency     int // Files of a multipart upload request stored in parallel
+}
+
+func LoadUploadConfig() *UploadConfig {
+	cfg := &UploadConfig{
+		StagingDir: env.GetEnv("UPLOAD_STAGING_DIR", "./uploads"),
+		Expiration: env.GetEnvAsInt("UPLOAD_EXPIRATION", 24*60*60), // 24 hours
+
+		DuplicatePolicy: env.GetEnv("UPLOAD_DUPLICATE_POLICY", "existing"),
+		Concurrency:     env.GetEnvAsInt("UPLOAD_CONCURRENCY", 4),
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("invalid upload config: %v", err)
+	}
+	return cfg
+}
+
+// Validate rejects settings that would otherwise silently fall back to another behaviour.
+func (c *UploadConfig) Validate() error {
+	if !slices.Contains(DuplicatePolicies, c.DuplicatePolicy) {
+		return fmt.Errorf("UPLOAD_DUPLICATE_POLICY must be one of %v, got %q", DuplicatePolicies, c.DuplicatePolicy)
+	}
+	return nil
+}
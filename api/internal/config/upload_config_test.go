@@ -0,0 +1,16 @@
+package config
+
+import "testing"
+
+func TestUploadConfigValidate(t *testing.T) {
+	for _, policy := range DuplicatePolicies {
+		if err := (&UploadConfig{DuplicatePolicy: policy}).Validate(); err != nil {
+			t.Errorf("policy %q: %v", policy, err)
+		}
+	}
+	for _, policy := range []string{"", "Reject", "skip"} {
+		if err := (&UploadConfig{DuplicatePolicy: policy}).Validate(); err == nil {
+			t.Errorf("expected policy %q to be rejected", policy)
+		}
+	}
+}
@@ -36,7 +36,7 @@ func InitDatabase(config *config.DbConfig) (*gorm.DB, error) {
 		return nil, err
 	}
 
-	err = db.AutoMigrate(&models.User{}, &models.Media{}, &models.Favourite{}, &models.Album{}, &models.AlbumMedia{})
+	err = db.AutoMigrate(&models.User{}, &models.Media{}, &models.Favourite{}, &models.Album{}, &models.AlbumMedia{}, &models.MediaMetadata{}, &models.Job{})
 	if err != nil {
 		return nil, err
 	}
@@ -10,18 +10,13 @@ import (
 	"syscall"
 	"time"
 
-	"embox/internal/api/routes"
 	"embox/internal/config"
-
-	"gorm.io/gorm"
 )
 
-// InitServer initializes the HTTP server with the provided database connection.
-// It sets up the router and handles graceful shutdown on interrupt signals.
+// InitServer initializes the HTTP server with the provided router.
+// It handles graceful shutdown on interrupt signals.
 // The server runs in a goroutine and listens for incoming requests.
-func InitServer(db *gorm.DB, apiConfig *config.ApiConfig) {
-	router := routes.Init(db, apiConfig)
-
+func InitServer(router http.Handler, apiConfig *config.ApiConfig) {
 	server := &http.Server{
 		Addr:    fmt.Sprintf("%s:%s", apiConfig.Server.Host, apiConfig.Server.Port),
 		Handler: router,
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type Album struct {
@@ -15,6 +16,7 @@ type Album struct {
 	UpdatedByID *uuid.UUID `gorm:"type:char(36);null"`
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
+	DeletedAt   gorm.DeletedAt `gorm:"index"` // set while the album is in the trash
 
 	// Many-to-Many Relation with Media
 	Media      []Media      `gorm:"many2many:album_media;constraint:OnDelete:CASCADE;"`
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// States of a background job, stored in Job.Status
+const (
+	JobPending = "pending" // waiting for a worker, also between retries
+	JobRunning = "running"
+	JobDone    = "done"
+	JobDead    = "dead" // failed MaxAttempts times, kept for inspection
+)
+
+// Job is a unit of background work, such as processing an uploaded file, run by the worker pool of the JobService.
+// Failed jobs are retried at RunAt until MaxAttempts is reached.
+type Job struct {
+	ID          uint       `gorm:"type:int;primaryKey"`
+	Type        string     `gorm:"type:varchar(32);not null"`
+	MediaID     uint       `gorm:"type:int;index"`
+	UserID      *uuid.UUID `gorm:"type:char(36);null"` // user who caused the job, may see its progress
+	Payload     string     `gorm:"type:text"`          // JSON arguments, depending on Type
+	Status      string     `gorm:"type:varchar(16);not null;index"`
+	Progress    int        // percent
+	Attempts    int
+	MaxAttempts int
+	LastError   string    `gorm:"type:text"`
+	RunAt       time.Time `gorm:"type:datetime;index"` // pending jobs are not run before
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (Job) TableName() string {
+	return "jobs"
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// MediaMetadata holds the camera and location data read from the EXIF data of an image on upload.
+// Fields missing from the file are left empty (nil for numbers).
+type MediaMetadata struct {
+	MediaID      uint     `gorm:"type:int;primaryKey"`
+	Media        Media    `gorm:"foreignKey:MediaID;constraint:OnDelete:CASCADE" json:"-"`
+	CameraMake   string   `gorm:"type:varchar(64)"`
+	CameraModel  string   `gorm:"type:varchar(64)"`
+	LensModel    string   `gorm:"type:varchar(128)"`
+	FocalLength  *float64 // mm
+	FNumber      *float64
+	ExposureTime string `gorm:"type:varchar(16)"` // seconds as in EXIF, e.g. "1/250"
+	ISO          *int
+	Width        int // pixels, as displayed (EXIF orientation applied)
+	Height       int
+	Latitude     *float64 `gorm:"index"`
+	Longitude    *float64
+	Altitude     *float64 // meters above sea level
+	OffsetTime   string   `gorm:"type:varchar(6)"` // time zone of the capture date, e.g. "+02:00"
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (MediaMetadata) TableName() string {
+	return "media_metadata"
+}
@@ -6,24 +6,44 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type Media struct {
-	ID          uint       `gorm:"type:int;primaryKey"`
-	Date        time.Time  `gorm:"type:datetime;not null"`
-	UserID      *uuid.UUID `gorm:"type:char(36);null"`                             // Foreign Key, nullable
-	User        User       `gorm:"foreignKey:UserID;constraint:OnDelete:SET NULL"` // Relation
-	UpdatedByID *uuid.UUID `gorm:"type:char(36);null"`
-	FileExt     string     `gorm:"type:varchar(8);not null"`
-	Type        string     `gorm:"type:varchar(8);not null"`
-	Caption     string     `gorm:"type:varchar(255);null"`
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	ID               uint       `gorm:"type:int;primaryKey"`
+	Date             time.Time  `gorm:"type:datetime;not null"`
+	UserID           *uuid.UUID `gorm:"type:char(36);null"`                             // Foreign Key, nullable
+	User             User       `gorm:"foreignKey:UserID;constraint:OnDelete:SET NULL"` // Relation
+	UpdatedByID      *uuid.UUID `gorm:"type:char(36);null"`
+	FileExt          string     `gorm:"type:varchar(8);not null"`
+	Type             string     `gorm:"type:varchar(8);not null"`
+	Caption          string     `gorm:"type:varchar(255);null"`
+	IsBroken         bool       `gorm:"default:false"`                       // set by embox-fsck when the original file is missing
+	ContentHash      string     `gorm:"type:char(64);index"`                 // hex SHA-256 of the original file
+	PerceptualHash   string     `gorm:"type:char(16)"`                       // hex 64-bit dHash of the image or video poster, for near-duplicates
+	TranscodeStatus  string     `gorm:"type:varchar(16);index"`              // web-friendly video versions: "pending", "done" or "failed"
+	TranscodeTries   int        `gorm:"not null;default:0"`                  // transcodings started, failed ones are retried by embox-backfill up to a limit
+	ProcessingStatus string     `gorm:"type:varchar(16);default:done;index"` // background processing of the upload: "pending", "done" or "failed"
+	Duration         float64    // audio: length in seconds
+	Bitrate          int        // audio: bit/s
+	AudioTitle       string     `gorm:"type:varchar(255)"`       // audio: ID3/Vorbis title tag
+	AudioArtist      string     `gorm:"type:varchar(255)"`       // audio: ID3/Vorbis artist tag
+	Place            string     `gorm:"type:varchar(200);index"` // nearest city to the GPS position, e.g. "Hamburg"
+	Country          string     `gorm:"type:varchar(100);index"` // country of Place, e.g. "Germany"
+	Width            int        // pixels as displayed, 0 if unknown
+	Height           int
+	DominantColor    string `gorm:"type:char(7)"`     // most frequent colour of the thumbnail, e.g. "#a0b1c2"
+	BlurHash         string `gorm:"type:varchar(32)"` // placeholder shown while the thumbnail loads, see blurha.sh
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	DeletedAt        gorm.DeletedAt `gorm:"index"` // set while the media is in the trash
 
 	// Computed fields, ignored by GORM for DB operations
 	IsFavourite       bool   `gorm:"-" json:"isFavourite"`
 	FavouriteUserID   string `gorm:"-" json:"favourite_user_id"`
 	FavouriteUserName string `gorm:"-" json:"favourite_user_name"`
+	DuplicateOf       uint   `gorm:"-" json:"duplicateOf"` // ID of existing media with the same content, set on upload
+	JobID             uint   `gorm:"-" json:"jobId"`       // job processing the upload, set on upload
 
 	// Many-to-Many Relation with Album
 	Albums []Album `gorm:"many2many:album_media;constraint:OnDelete:CASCADE;"`
@@ -33,21 +53,64 @@ func (Media) TableName() string {
 	return "media"
 }
 
+// AspectRatio returns width / height, 0 if the dimensions are unknown.
+func (m *Media) AspectRatio() float64 {
+	if m.Width <= 0 || m.Height <= 0 {
+		return 0
+	}
+	return float64(m.Width) / float64(m.Height)
+}
+
+// BasePath returns the date based path without extension: yyyy/mm/dd_Id
+// All local and remote files of the media item start with it.
+func (m *Media) BasePath() string {
+	dateStr := m.Date.Format("2006/01/02") // yyyy/mm/dd
+	return fmt.Sprintf("%s_%d", dateStr, m.ID)
+}
+
 // Path returns the local path of the media item.
 // For images: yyyy/mm/dd_Id.webp
 // For audio/video: yyyy/mm/dd_Id.FileExt
 func (m *Media) Path() string {
-	dateStr := m.Date.Format("2006/01/02") // yyyy/mm/dd
 	ext := m.FileExt
 	mediaType := strings.ToLower(m.Type)
 	if mediaType == "image" || mediaType == "video" {
 		ext = "webp"
 	}
-	return fmt.Sprintf("%s_%d.%s", dateStr, m.ID, ext)
+	return fmt.Sprintf("%s.%s", m.BasePath(), ext)
+}
+
+// HlsDir returns the local directory of the HLS playlists and segments: yyyy/mm/dd_Id_hls
+func (m *Media) HlsDir() string {
+	return m.BasePath() + "_hls"
+}
+
+// WebVideoPath returns the local path of the transcoded H.264/AAC video: yyyy/mm/dd_Id_web.mp4
+func (m *Media) WebVideoPath() string {
+	return m.BasePath() + "_web.mp4"
+}
+
+// PreviewPath returns the local path of the animated preview of a video: yyyy/mm/dd_Id_preview.webp
+func (m *Media) PreviewPath() string {
+	return m.BasePath() + "_preview.webp"
+}
+
+// SpritesPath returns the local path of the seek bar sprite sheet of a video: yyyy/mm/dd_Id_sprites.jpg
+func (m *Media) SpritesPath() string {
+	return m.BasePath() + "_sprites.jpg"
+}
+
+// SpritesVttPath returns the local path of the WebVTT file locating the sprites: yyyy/mm/dd_Id_sprites.vtt
+func (m *Media) SpritesVttPath() string {
+	return m.BasePath() + "_sprites.vtt"
+}
+
+// WaveformPath returns the local path of the peak waveform of an audio file: yyyy/mm/dd_Id_waveform.json
+func (m *Media) WaveformPath() string {
+	return m.BasePath() + "_waveform.json"
 }
 
 // RemotePath always returns: yyyy/mm/dd_Id.FileExt
 func (m *Media) RemotePath() string {
-	dateStr := m.Date.Format("2006/01/02") // yyyy/mm/dd
-	return fmt.Sprintf("%s_%d.%s", dateStr, m.ID, m.FileExt)
+	return fmt.Sprintf("%s.%s", m.BasePath(), m.FileExt)
 }
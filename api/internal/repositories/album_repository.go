@@ -2,7 +2,9 @@ package repositories
 
 import (
 	"embox/internal/models"
+	"time"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
@@ -25,17 +27,7 @@ func (r *albumRepository) Update(album *models.Album) error {
 func (r *albumRepository) Get() ([]*AlbumListItem, error) {
 	var albums []*AlbumListItem
 
-	err := r.db.
-		Preload("AlbumMedia.Media").
-		Select(`
-        albums.*,
-        (
-            SELECT COUNT(*)
-            FROM album_media
-            WHERE album_media.album_id = albums.id
-        ) as media_count
-    `).
-		Find(&albums).Error
+	err := r.withMediaCount(r.db).Find(&albums).Error
 
 	if err != nil {
 		return nil, err
@@ -48,13 +40,61 @@ func (r *albumRepository) Get() ([]*AlbumListItem, error) {
 	return albums, nil
 }
 
+// GetTrashed returns the albums in the trash that were deleted before the given time, latest first.
+// If userId is not nil, only the albums of that user are returned.
+func (r *albumRepository) GetTrashed(before time.Time, userId *uuid.UUID) ([]*AlbumListItem, error) {
+	var albums []*AlbumListItem
+
+	query := r.withMediaCount(r.db.Unscoped()).
+		Where("albums.deleted_at IS NOT NULL AND albums.deleted_at < ?", before).
+		Order("albums.deleted_at DESC")
+	if userId != nil {
+		query = query.Where("albums.user_id = ?", *userId)
+	}
+	err := query.Find(&albums).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return albums, nil
+}
+
+// GetTrashedByIDs returns the albums in the trash with the given IDs.
+func (r *albumRepository) GetTrashedByIDs(ids []uint) ([]*models.Album, error) {
+	var albums []*models.Album
+	if err := r.db.Unscoped().Where("id IN ? AND deleted_at IS NOT NULL", ids).Find(&albums).Error; err != nil {
+		return nil, err
+	}
+	return albums, nil
+}
+
+// withMediaCount selects the albums with the number of their media and preloads their media,
+// both without media in the trash.
+func (r *albumRepository) withMediaCount(db *gorm.DB) *gorm.DB {
+	return db.
+		Preload("AlbumMedia", func(db *gorm.DB) *gorm.DB {
+			return db.Joins("JOIN media ON media.id = album_media.media_id AND media.deleted_at IS NULL")
+		}).
+		Preload("AlbumMedia.Media").
+		Select(`
+        albums.*,
+        (
+            SELECT COUNT(*)
+            FROM album_media
+            JOIN media ON media.id = album_media.media_id AND media.deleted_at IS NULL
+            WHERE album_media.album_id = albums.id
+        ) as media_count
+    `)
+}
+
 func (r *albumRepository) GetById(id uint) (*models.Album, error) {
 	{
 		var album models.Album
 
 		err := r.db.
 			Preload("AlbumMedia", func(db *gorm.DB) *gorm.DB {
-				return db.Joins("JOIN media ON media.id = album_media.media_id").
+				return db.Joins("JOIN media ON media.id = album_media.media_id AND media.deleted_at IS NULL").
 					Order("media.date DESC")
 			}).
 			Preload("AlbumMedia.Media").
@@ -83,12 +123,30 @@ func (r *albumRepository) GetMediaIdsByAlbumId(albumId uint) ([]uint, error) {
 	return mediaIds, nil
 }
 
+// Delete moves the album to the trash. Its media entries are kept until it is purged.
 func (r *albumRepository) Delete(id uint) error {
+	return r.db.Delete(&models.Album{}, id).Error
+}
+
+// Restore moves albums out of the trash. Their media entries were kept while they were in the trash,
+// entries of media purged in the meantime are dropped.
+func (r *albumRepository) Restore(ids []uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		existing := tx.Unscoped().Model(&models.Media{}).Select("id")
+		if err := tx.Where("album_id IN ? AND media_id NOT IN (?)", ids, existing).Delete(&models.AlbumMedia{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Model(&models.Album{}).Where("id IN ?", ids).UpdateColumn("deleted_at", nil).Error
+	})
+}
+
+// Purge permanently deletes albums and their media entries. The media items themselves are kept.
+func (r *albumRepository) Purge(ids []uint) error {
 	return r.db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Where("album_id = ?", id).Delete(&models.AlbumMedia{}).Error; err != nil {
+		if err := tx.Where("album_id IN ?", ids).Delete(&models.AlbumMedia{}).Error; err != nil {
 			return err
 		}
-		return tx.Delete(&models.Album{}, id).Error
+		return tx.Unscoped().Where("id IN ?", ids).Delete(&models.Album{}).Error
 	})
 }
 
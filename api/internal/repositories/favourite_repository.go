@@ -39,11 +39,12 @@ func (r *favouriteRepository) Remove(userId uuid.UUID, mediaIds []uint) error {
 func (r *favouriteRepository) GetUsersWithLatestFavourite() ([]UserWithLatestFavourite, error) {
 	var results []UserWithLatestFavourite
 
-	// Subquery: Get latest favourite per user firstly
+	// Subquery: Get latest favourite per user firstly, media in the trash are left out
 	subQuery := r.db.
 		Table("favourites").
-		Select("user_id, MAX(created_at) as latest_created_at").
-		Group("user_id")
+		Select("favourites.user_id, MAX(favourites.created_at) as latest_created_at").
+		Joins("JOIN media ON media.id = favourites.media_id AND media.deleted_at IS NULL").
+		Group("favourites.user_id")
 
 	// Main query: Join with users and media to get the required details
 	query := r.db.
@@ -56,12 +57,13 @@ func (r *favouriteRepository) GetUsersWithLatestFavourite() ([]UserWithLatestFav
       media.type as media_type,
       (
           SELECT COUNT(*)
-          FROM favourites
-          WHERE favourites.user_id = users.id
+          FROM favourites AS f
+          JOIN media AS m ON m.id = f.media_id AND m.deleted_at IS NULL
+          WHERE f.user_id = users.id
       ) as media_count
     `).
 		Joins("JOIN users ON users.id = favourites.user_id").
-		Joins("JOIN media ON media.id = favourites.media_id").
+		Joins("JOIN media ON media.id = favourites.media_id AND media.deleted_at IS NULL").
 		Joins("JOIN (?) as latest_favourites ON favourites.user_id = latest_favourites.user_id AND favourites.created_at = latest_favourites.latest_created_at", subQuery)
 
 	query = query.
@@ -87,7 +89,7 @@ func (r *favouriteRepository) GetFavouritesByUserID(userId uuid.UUID, favUserID
             u.name as favourite_user_name,
             CASE WHEN fav.user_id IS NOT NULL THEN true ELSE false END AS is_favourite
         `).
-		Joins("JOIN media AS m ON m.id = f.media_id").
+		Joins("JOIN media AS m ON m.id = f.media_id AND m.deleted_at IS NULL").
 		Joins("JOIN users AS u ON u.id = f.user_id").
 		Joins("LEFT JOIN favourites AS fav ON fav.media_id = m.id AND fav.user_id = ?", userId).
 		Where("f.user_id = ?", favUserID).
@@ -0,0 +1,97 @@
+package repositories
+
+import (
+	"embox/internal/models"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type jobRepository struct {
+	db *gorm.DB
+}
+
+func NewJobRepository(db *gorm.DB) JobRepository {
+	return &jobRepository{db}
+}
+
+func (r *jobRepository) Create(job *models.Job) error {
+	return r.db.Create(job).Error
+}
+
+func (r *jobRepository) GetById(id uint) (*models.Job, error) {
+	var job models.Job
+	err := r.db.First(&job, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetLatest returns the last job of the given type for the media item, or nil if there is none.
+func (r *jobRepository) GetLatest(mediaId uint, jobType string) (*models.Job, error) {
+	var job models.Job
+	err := r.db.Where("media_id = ? AND type = ?", mediaId, jobType).Order("id DESC").First(&job).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Claim marks the pending job that is due first as running and counts the attempt.
+// It returns nil if no job is due. The status is checked again on update,
+// so a job is never claimed by two workers.
+func (r *jobRepository) Claim() (*models.Job, error) {
+	for {
+		var job models.Job
+		err := r.db.Where("status = ? AND run_at <= ?", models.JobPending, time.Now()).
+			Order("run_at, id").
+			First(&job).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		result := r.db.Model(&models.Job{}).
+			Where("id = ? AND status = ?", job.ID, models.JobPending).
+			Updates(map[string]any{
+				"status":   models.JobRunning,
+				"attempts": gorm.Expr("attempts + 1"),
+			})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 1 {
+			job.Status = models.JobRunning
+			job.Attempts++
+			return &job, nil
+		}
+		// Claimed by another worker in the meantime, try the next one
+	}
+}
+
+// Finish saves the outcome of a run: status, progress, error, next run time and attempts.
+func (r *jobRepository) Finish(job *models.Job) error {
+	return r.db.Model(job).Select("status", "progress", "attempts", "last_error", "run_at").Updates(job).Error
+}
+
+func (r *jobRepository) SetProgress(id uint, progress int) error {
+	return r.db.Model(&models.Job{}).Where("id = ?", id).Update("progress", progress).Error
+}
+
+// ResetRunning returns the jobs interrupted by a restart to the queue and returns their number.
+func (r *jobRepository) ResetRunning() (int64, error) {
+	result := r.db.Model(&models.Job{}).
+		Where("status = ?", models.JobRunning).
+		Update("status", models.JobPending)
+	return result.RowsAffected, result.Error
+}
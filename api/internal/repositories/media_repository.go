@@ -2,9 +2,11 @@ package repositories
 
 import (
 	"embox/internal/models"
+	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type mediaRepository struct {
@@ -19,15 +21,70 @@ func (r *mediaRepository) Create(media *models.Media) error {
 	return r.db.Create(media).Error
 }
 
-func (r *mediaRepository) Update(media *models.Media) error {
-	return r.db.Save(media).Error
+// editableColumns are the columns of a media item its users can edit. The other columns are
+// set by the background processing and transcoding, which may run at the same time.
+var editableColumns = []string{"caption", "date", "place", "country", "updated_by_id", "updated_at"}
+
+// Update saves the columns of the media a user can edit and, if not nil, its metadata in one transaction.
+func (r *mediaRepository) Update(media *models.Media, metadata *models.MediaMetadata) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return saveEditable(tx, media, metadata)
+	})
+}
+
+// UpdateWith saves the columns of the media a user can edit and, if not nil, its metadata and runs do
+// within the same transaction. If do returns an error, the update is rolled back.
+// If the commit fails after do succeeded, undo is called.
+func (r *mediaRepository) UpdateWith(media *models.Media, metadata *models.MediaMetadata, do func() error, undo func()) error {
+	done := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := saveEditable(tx, media, metadata); err != nil {
+			return err
+		}
+		if err := do(); err != nil {
+			return err
+		}
+		done = true
+		return nil
+	})
+	if err != nil && done {
+		undo()
+	}
+	return err
 }
 
+func saveEditable(tx *gorm.DB, media *models.Media, metadata *models.MediaMetadata) error {
+	if err := tx.Model(media).Select(editableColumns).Updates(media).Error; err != nil {
+		return err
+	}
+	if metadata != nil {
+		return tx.Save(metadata).Error
+	}
+	return nil
+}
+
+// Delete moves media items to the trash.
 func (r *mediaRepository) Delete(ids []uint) error {
 	return r.db.Where("id IN ?", ids).Delete(&models.Media{}).Error
 }
 
-func (r *mediaRepository) Get(userId uuid.UUID) ([]*MediaListItem, error) {
+// Restore moves media items out of the trash.
+func (r *mediaRepository) Restore(ids []uint) error {
+	return r.db.Unscoped().Model(&models.Media{}).Where("id IN ?", ids).UpdateColumn("deleted_at", nil).Error
+}
+
+// Purge permanently deletes media items and their album entries, also those in albums in the trash.
+// Their favourites and metadata are removed by the foreign keys.
+func (r *mediaRepository) Purge(ids []uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("media_id IN ?", ids).Delete(&models.AlbumMedia{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("id IN ?", ids).Delete(&models.Media{}).Error
+	})
+}
+
+func (r *mediaRepository) Get(userId uuid.UUID, filter MediaFilter) ([]*MediaListItem, error) {
 	var media []*MediaListItem
 
 	query := r.db.
@@ -38,12 +95,40 @@ func (r *mediaRepository) Get(userId uuid.UUID) ([]*MediaListItem, error) {
         `).
 		Joins("LEFT JOIN favourites AS fav ON fav.media_id = media.id AND fav.user_id = ?", userId).
 		Order("media.date DESC")
+	if filter.Place != "" {
+		query = query.Where("media.place = ?", filter.Place)
+	}
+	if filter.Country != "" {
+		query = query.Where("media.country = ?", filter.Country)
+	}
 
 	err := query.Find(&media).Error
 
 	return media, err
 }
 
+// GetByIdForUser returns the media item with the favourite flag of the user, or nil if it does not exist.
+func (r *mediaRepository) GetByIdForUser(id uint, userId uuid.UUID) (*MediaListItem, error) {
+	var media MediaListItem
+
+	err := r.db.
+		Model(&models.Media{}).
+		Select(`
+            media.*,
+            CASE WHEN fav.user_id IS NOT NULL THEN true ELSE false END AS is_favourite
+        `).
+		Joins("LEFT JOIN favourites AS fav ON fav.media_id = media.id AND fav.user_id = ?", userId).
+		Where("media.id = ?", id).
+		First(&media).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &media, nil
+}
+
 func (r *mediaRepository) GetById(id uint) (*models.Media, error) {
 	var media models.Media
 	if err := r.db.First(&media, id).Error; err != nil {
@@ -55,6 +140,18 @@ func (r *mediaRepository) GetById(id uint) (*models.Media, error) {
 	return &media, nil
 }
 
+// GetByIdWithTrashed returns the media item even if it is in the trash, or nil if it does not exist.
+func (r *mediaRepository) GetByIdWithTrashed(id uint) (*models.Media, error) {
+	var media models.Media
+	if err := r.db.Unscoped().First(&media, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &media, nil
+}
+
 func (r *mediaRepository) GetByIDs(ids []uint) ([]*models.Media, error) {
 	var media []*models.Media
 	if err := r.db.Where("id IN ?", ids).Find(&media).Error; err != nil {
@@ -62,3 +159,219 @@ func (r *mediaRepository) GetByIDs(ids []uint) ([]*models.Media, error) {
 	}
 	return media, nil
 }
+
+// GetByContentHash returns the oldest media with the given content hash in one of the processing states,
+// or nil if there is none.
+func (r *mediaRepository) GetByContentHash(hash string, processingStatuses []string) (*models.Media, error) {
+	var media models.Media
+	err := r.db.Where("content_hash = ? AND processing_status IN ?", hash, processingStatuses).Order("id").First(&media).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &media, nil
+}
+
+// GetAll returns all media items including those in the trash, whose files still exist.
+func (r *mediaRepository) GetAll() ([]*models.Media, error) {
+	var media []*models.Media
+	if err := r.db.Unscoped().Order("id").Find(&media).Error; err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+// GetTrashed returns the media items in the trash that were deleted before the given time, latest first.
+// If userId is not nil, only the media items of that user are returned.
+func (r *mediaRepository) GetTrashed(before time.Time, userId *uuid.UUID) ([]*models.Media, error) {
+	var media []*models.Media
+	query := r.db.Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", before).
+		Order("deleted_at DESC")
+	if userId != nil {
+		query = query.Where("user_id = ?", *userId)
+	}
+	err := query.Find(&media).Error
+	if err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+// GetTrashedByIDs returns the media items in the trash with the given IDs.
+func (r *mediaRepository) GetTrashedByIDs(ids []uint) ([]*models.Media, error) {
+	var media []*models.Media
+	if err := r.db.Unscoped().Where("id IN ? AND deleted_at IS NOT NULL", ids).Find(&media).Error; err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+// SetTranscodeStatus updates the transcoding state of a video without touching updated_at.
+func (r *mediaRepository) SetTranscodeStatus(id uint, status string) error {
+	return r.db.Model(&models.Media{}).Where("id = ?", id).UpdateColumn("transcode_status", status).Error
+}
+
+// AddTranscodeTry counts a started transcoding of a video without touching updated_at.
+func (r *mediaRepository) AddTranscodeTry(id uint) error {
+	return r.db.Model(&models.Media{}).Where("id = ?", id).UpdateColumn("transcode_tries", gorm.Expr("transcode_tries + 1")).Error
+}
+
+// GetByTranscodeStatus returns the media items with the given transcoding state.
+func (r *mediaRepository) GetByTranscodeStatus(status string) ([]*models.Media, error) {
+	var media []*models.Media
+	if err := r.db.Where("transcode_status = ?", status).Order("id").Find(&media).Error; err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+// SetAudioInfo saves the duration, bitrate and tags of an audio file without touching updated_at.
+func (r *mediaRepository) SetAudioInfo(media *models.Media) error {
+	return r.db.Model(&models.Media{}).Where("id = ?", media.ID).UpdateColumns(map[string]any{
+		"duration":     media.Duration,
+		"bitrate":      media.Bitrate,
+		"audio_title":  media.AudioTitle,
+		"audio_artist": media.AudioArtist,
+	}).Error
+}
+
+// SaveMetadata creates or replaces the metadata of a media item.
+func (r *mediaRepository) SaveMetadata(metadata *models.MediaMetadata) error {
+	return r.db.Save(metadata).Error
+}
+
+// GetMetadata returns the metadata of a media item, or nil if none was read from its file.
+func (r *mediaRepository) GetMetadata(mediaId uint) (*models.MediaMetadata, error) {
+	var metadata models.MediaMetadata
+	if err := r.db.Where("media_id = ?", mediaId).First(&metadata).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// GetLocations returns the positions of all media within the bounds, excluding media in the trash.
+func (r *mediaRepository) GetLocations(bounds GeoBounds) ([]*MediaLocation, error) {
+	query := r.db.
+		Table("media_metadata").
+		Select("media.id, media.date, media_metadata.latitude, media_metadata.longitude").
+		Joins("JOIN media ON media.id = media_metadata.media_id AND media.deleted_at IS NULL").
+		Where("media_metadata.latitude BETWEEN ? AND ?", bounds.MinLat, bounds.MaxLat).
+		Where("media_metadata.longitude IS NOT NULL")
+	if bounds.MinLon <= bounds.MaxLon {
+		query = query.Where("media_metadata.longitude BETWEEN ? AND ?", bounds.MinLon, bounds.MaxLon)
+	} else {
+		query = query.Where("media_metadata.longitude >= ? OR media_metadata.longitude <= ?", bounds.MinLon, bounds.MaxLon)
+	}
+
+	var locations []*MediaLocation
+	if err := query.Order("media.id").Scan(&locations).Error; err != nil {
+		return nil, err
+	}
+	return locations, nil
+}
+
+// GetLocationsWithoutPlace returns the positions of the media whose place is not known, excluding media in the trash.
+func (r *mediaRepository) GetLocationsWithoutPlace() ([]*MediaLocation, error) {
+	var locations []*MediaLocation
+	err := r.db.
+		Table("media_metadata").
+		Select("media.id, media.date, media_metadata.latitude, media_metadata.longitude").
+		Joins("JOIN media ON media.id = media_metadata.media_id AND media.deleted_at IS NULL").
+		Where("media_metadata.latitude IS NOT NULL AND media_metadata.longitude IS NOT NULL").
+		Where("media.place IS NULL OR media.place = ''").
+		Order("media.id").
+		Scan(&locations).Error
+	if err != nil {
+		return nil, err
+	}
+	return locations, nil
+}
+
+// SetPlace saves the place name and country of a media item without touching updated_at.
+func (r *mediaRepository) SetPlace(id uint, place, country string) error {
+	return r.db.Model(&models.Media{}).Where("id = ?", id).UpdateColumns(map[string]any{
+		"place":   place,
+		"country": country,
+	}).Error
+}
+
+// SetProcessed saves the date, place and processing state set by the background processing of an upload
+// without touching updated_at, also for media in the trash.
+func (r *mediaRepository) SetProcessed(media *models.Media) error {
+	return r.db.Unscoped().Model(&models.Media{}).Where("id = ?", media.ID).UpdateColumns(map[string]any{
+		"date":              media.Date,
+		"place":             media.Place,
+		"country":           media.Country,
+		"processing_status": media.ProcessingStatus,
+	}).Error
+}
+
+// SetPlaceholder saves the dimensions, dominant colour and BlurHash of a media item without touching updated_at.
+func (r *mediaRepository) SetPlaceholder(media *models.Media) error {
+	return r.db.Unscoped().Model(&models.Media{}).Where("id = ?", media.ID).UpdateColumns(map[string]any{
+		"width":          media.Width,
+		"height":         media.Height,
+		"dominant_color": media.DominantColor,
+		"blur_hash":      media.BlurHash,
+	}).Error
+}
+
+// SetContentHash saves the content hash of a media item without touching updated_at, also for media in the trash.
+func (r *mediaRepository) SetContentHash(id uint, hash string) error {
+	return r.db.Unscoped().Model(&models.Media{}).Where("id = ?", id).UpdateColumn("content_hash", hash).Error
+}
+
+// SetPerceptualHash saves the perceptual hash of a media item without touching updated_at, also for media in the trash.
+func (r *mediaRepository) SetPerceptualHash(id uint, hash string) error {
+	return r.db.Unscoped().Model(&models.Media{}).Where("id = ?", id).UpdateColumn("perceptual_hash", hash).Error
+}
+
+// MergeInto adds the media item keepId to the albums of the media items ids and makes it a favourite
+// of the users who favourited them, e.g. before these duplicates are deleted. Albums whose cover
+// is one of ids get keepId as cover.
+func (r *mediaRepository) MergeInto(keepId uint, ids []uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var memberships []models.AlbumMedia
+		if err := tx.Where("media_id IN ?", ids).Find(&memberships).Error; err != nil {
+			return err
+		}
+		for _, membership := range memberships {
+			entry := models.AlbumMedia{AlbumID: membership.AlbumID, MediaID: keepId}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&entry).Error; err != nil {
+				return err
+			}
+			if !membership.IsCover {
+				continue
+			}
+			if err := tx.Model(&models.AlbumMedia{}).Where("album_id = ?", membership.AlbumID).Update("is_cover", false).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&models.AlbumMedia{}).Where("album_id = ? AND media_id = ?", membership.AlbumID, keepId).Update("is_cover", true).Error; err != nil {
+				return err
+			}
+		}
+
+		var userIds []uuid.UUID
+		if err := tx.Model(&models.Favourite{}).Where("media_id IN ?", ids).Distinct().Pluck("user_id", &userIds).Error; err != nil {
+			return err
+		}
+		for _, userId := range userIds {
+			entry := models.Favourite{UserID: userId, MediaID: keepId, CreatedAt: time.Now()}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&entry).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetBroken flags media items whose original file is missing, without touching updated_at.
+func (r *mediaRepository) SetBroken(ids []uint, broken bool) error {
+	return r.db.Model(&models.Media{}).Where("id IN ?", ids).UpdateColumn("is_broken", broken).Error
+}
@@ -21,11 +21,35 @@ type UserRepository interface {
 
 type MediaRepository interface {
 	Create(media *models.Media) error
-	Update(media *models.Media) error
+	Update(media *models.Media, metadata *models.MediaMetadata) error
+	UpdateWith(media *models.Media, metadata *models.MediaMetadata, do func() error, undo func()) error
 	Delete(ids []uint) error
-	Get(userId uuid.UUID) ([]*MediaListItem, error)
+	Restore(ids []uint) error
+	Purge(ids []uint) error
+	Get(userId uuid.UUID, filter MediaFilter) ([]*MediaListItem, error)
 	GetById(id uint) (*models.Media, error)
+	GetByIdForUser(id uint, userId uuid.UUID) (*MediaListItem, error)
+	GetByIdWithTrashed(id uint) (*models.Media, error)
 	GetByIDs(ids []uint) ([]*models.Media, error)
+	GetAll() ([]*models.Media, error)
+	GetTrashed(before time.Time, userId *uuid.UUID) ([]*models.Media, error)
+	GetTrashedByIDs(ids []uint) ([]*models.Media, error)
+	GetByContentHash(hash string, processingStatuses []string) (*models.Media, error)
+	SetBroken(ids []uint, broken bool) error
+	SetTranscodeStatus(id uint, status string) error
+	AddTranscodeTry(id uint) error
+	GetByTranscodeStatus(status string) ([]*models.Media, error)
+	SetAudioInfo(media *models.Media) error
+	SaveMetadata(metadata *models.MediaMetadata) error
+	GetMetadata(mediaId uint) (*models.MediaMetadata, error)
+	GetLocations(bounds GeoBounds) ([]*MediaLocation, error)
+	GetLocationsWithoutPlace() ([]*MediaLocation, error)
+	SetPlace(id uint, place, country string) error
+	SetProcessed(media *models.Media) error
+	SetContentHash(id uint, hash string) error
+	SetPerceptualHash(id uint, hash string) error
+	SetPlaceholder(media *models.Media) error
+	MergeInto(keepId uint, ids []uint) error
 }
 
 type FavouriteRepository interface {
@@ -39,19 +63,34 @@ type AlbumRepository interface {
 	Create(album *models.Album) error
 	Update(album *models.Album) error
 	Delete(id uint) error
+	Restore(ids []uint) error
+	Purge(ids []uint) error
 	Get() ([]*AlbumListItem, error)
 	GetById(id uint) (*models.Album, error)
+	GetTrashed(before time.Time, userId *uuid.UUID) ([]*AlbumListItem, error)
+	GetTrashedByIDs(ids []uint) ([]*models.Album, error)
 	GetMediaIdsByAlbumId(albumId uint) ([]uint, error)
 	AddMediaToAlbum(albumId uint, mediaIds []uint, isCover bool) error
 	RemoveMediaFromAlbum(albumId uint, mediaIds []uint) error
 	SetCover(albumId uint, mediaId uint) error
 }
 
+type JobRepository interface {
+	Create(job *models.Job) error
+	GetById(id uint) (*models.Job, error)
+	GetLatest(mediaId uint, jobType string) (*models.Job, error)
+	Claim() (*models.Job, error)
+	Finish(job *models.Job) error
+	SetProgress(id uint, progress int) error
+	ResetRunning() (int64, error)
+}
+
 type Repositories struct {
 	User      UserRepository
 	Media     MediaRepository
 	Favourite FavouriteRepository
 	Album     AlbumRepository
+	Job       JobRepository
 }
 
 // Repository responses
@@ -87,6 +126,26 @@ func (MediaListItem) TableName() string {
 	return "media"
 }
 
+// MediaFilter restricts MediaRepository.Get to media of a place and/or country. Empty fields match all media.
+type MediaFilter struct {
+	Place   string
+	Country string
+}
+
+// GeoBounds is a bounding box in degrees. MinLon > MaxLon spans the antimeridian.
+type GeoBounds struct {
+	MinLon, MinLat float64
+	MaxLon, MaxLat float64
+}
+
+// MediaLocation is the GPS position of a media item, as stored in its metadata.
+type MediaLocation struct {
+	ID        uint
+	Date      time.Time
+	Latitude  float64
+	Longitude float64
+}
+
 // Init initializes the repositories with the provided database connection.
 // It returns a Repositories struct containing all the repositories.
 func Init(db *gorm.DB) *Repositories {
@@ -95,5 +154,6 @@ func Init(db *gorm.DB) *Repositories {
 		Media:     NewMediaRepository(db),
 		Favourite: NewFavouriteRepository(db),
 		Album:     NewAlbumRepository(db),
+		Job:       NewJobRepository(db),
 	}
 }
@@ -112,6 +112,8 @@ func (s *AlbumService) GetAlbumList(userEmail string) ([]dto.AlbumResponseDto, e
 				Type:        cover.Media.Type,
 				IsCover:     cover.IsCover,
 				CreatedAt:   cover.Media.CreatedAt,
+
+				MediaLayoutDto: mediaLayout(&cover.Media),
 			}}
 		}
 
@@ -145,6 +147,8 @@ func (s *AlbumService) GetAlbumByID(id uint) (*dto.AlbumResponseDto, error) {
 			Type:        albumMedia.Media.Type,
 			IsCover:     albumMedia.IsCover,
 			CreatedAt:   albumMedia.Media.CreatedAt,
+
+			MediaLayoutDto: mediaLayout(&albumMedia.Media),
 		}
 		mediaDtos = append(mediaDtos, mediaDto)
 	}
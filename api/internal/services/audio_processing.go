@@ -0,0 +1,255 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"embox/internal/api/dto"
+	"embox/internal/models"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	waveformSampleRate = 8000 // audio is decoded to mono at this rate for the waveform
+	waveformPoints     = 1000 // peaks in the waveform JSON, shorter files get fewer
+	waveformSlice      = 80   // samples per peak before downsampling (10ms)
+)
+
+type audioInfo struct {
+	Duration float64 // seconds
+	Bitrate  int     // bit/s
+	Title    string
+	Artist   string
+	HasCover bool // an attached picture stream is present
+}
+
+// MissingAudioData reports whether the waveform of an audio file is missing,
+// e.g. because it was uploaded before audio files were processed.
+func (s *MediaService) MissingAudioData(media *models.Media) bool {
+	if media.Type != "audio" {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(MediaDir, media.WaveformPath()))
+	return err != nil
+}
+
+// RegenerateAudioData downloads the original audio file and recreates its info, waveform and cover art thumbnails.
+func (s *MediaService) RegenerateAudioData(media *models.Media) error {
+	resp, err := s.storage.DownloadStream(media.RemotePath(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to download original file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	tmpFile, _, err := spoolToTempFile(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Close()
+
+	return s.processAudio(media, tmpFile.Name())
+}
+
+// processAudio saves the duration, bitrate and tags of an audio file, creates its waveform
+// and, if the file has embedded cover art, the thumbnail renditions from it.
+func (s *MediaService) processAudio(media *models.Media, audioPath string) error {
+	info, err := probeAudio(audioPath)
+	if err != nil {
+		return err
+	}
+
+	media.Duration = info.Duration
+	media.Bitrate = info.Bitrate
+	media.AudioTitle = info.Title
+	media.AudioArtist = info.Artist
+	if err := s.mediaRepo.SetAudioInfo(media); err != nil {
+		return fmt.Errorf("failed to save audio info: %w", err)
+	}
+
+	if err := createWaveform(audioPath, info.Duration, media.WaveformPath()); err != nil {
+		return err
+	}
+
+	if !info.HasCover {
+		return nil
+	}
+	img, err := extractCoverArt(audioPath)
+	if err != nil {
+		return err
+	}
+	return s.saveRenditions(media, img)
+}
+
+// probeAudio reads the duration, bitrate and title/artist tags of an audio file.
+// ID3 tags are found on the container, Vorbis comments (Ogg, Opus) on the audio stream.
+func probeAudio(path string) (*audioInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-show_entries", "format=duration,bit_rate:format_tags:stream=codec_type:stream_tags:stream_disposition=attached_pic",
+		"-of", "json",
+		path,
+	).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe audio: %w", err)
+	}
+
+	var probe struct {
+		Streams []struct {
+			CodecType   string            `json:"codec_type"`
+			Tags        map[string]string `json:"tags"`
+			Disposition struct {
+				AttachedPic int `json:"attached_pic"`
+			} `json:"disposition"`
+		} `json:"streams"`
+		Format struct {
+			Duration string            `json:"duration"`
+			BitRate  string            `json:"bit_rate"`
+			Tags     map[string]string `json:"tags"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return nil, fmt.Errorf("failed to probe audio: %w", err)
+	}
+
+	info := &audioInfo{}
+	info.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	info.Bitrate, _ = strconv.Atoi(probe.Format.BitRate)
+
+	tags := []map[string]string{probe.Format.Tags}
+	hasAudio := false
+	for _, stream := range probe.Streams {
+		switch stream.CodecType {
+		case "audio":
+			hasAudio = true
+			tags = append(tags, stream.Tags)
+		case "video":
+			info.HasCover = info.HasCover || stream.Disposition.AttachedPic == 1
+		}
+	}
+	if !hasAudio {
+		return nil, fmt.Errorf("failed to probe audio: no audio stream found")
+	}
+	info.Title = findTag(tags, "title")
+	info.Artist = findTag(tags, "artist")
+
+	return info, nil
+}
+
+// findTag returns the first non-empty value of the tag, ignoring the case of its key, truncated to 255 characters.
+func findTag(tags []map[string]string, key string) string {
+	for _, t := range tags {
+		for k, v := range t {
+			if v = strings.TrimSpace(v); strings.EqualFold(k, key) && v != "" {
+				if runes := []rune(v); len(runes) > 255 {
+					return string(runes[:255])
+				}
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// extractCoverArt decodes the attached picture of an audio file.
+func extractCoverArt(audioPath string) (image.Image, error) {
+	tmpCover := audioPath + "_cover.png"
+	defer os.Remove(tmpCover)
+	if err := runFFmpeg(30*time.Second, "-i", audioPath, "-map", "0:v:0", "-frames:v", "1", "-f", "image2", "-c:v", "png", tmpCover); err != nil {
+		return nil, fmt.Errorf("failed to extract cover art: %w", err)
+	}
+
+	coverFile, err := os.Open(tmpCover)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cover art: %w", err)
+	}
+	defer coverFile.Close()
+
+	return decodeImage(coverFile)
+}
+
+// createWaveform decodes the audio to mono PCM and saves the peaks of evenly sized time slices
+// as MediaWaveformDto JSON at path below MediaDir.
+func createWaveform(audioPath string, duration float64, path string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-v", "error",
+		"-i", audioPath,
+		"-map", "0:a:0",
+		"-ac", "1", "-ar", strconv.Itoa(waveformSampleRate),
+		"-f", "s16le", "-",
+	)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to decode audio: %w", err)
+	}
+
+	// Peaks of 10ms slices, downsampled once the number of slices is known
+	var peaks []float64
+	reader := bufio.NewReader(stdout)
+	buf := make([]byte, 2*waveformSlice)
+	for {
+		n, err := io.ReadFull(reader, buf)
+		if n >= 2 {
+			peak := 0
+			for i := 0; i+1 < n; i += 2 {
+				sample := int(int16(binary.LittleEndian.Uint16(buf[i:])))
+				peak = max(peak, sample, -sample)
+			}
+			peaks = append(peaks, min(float64(peak)/32768, 1))
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return fmt.Errorf("failed to decode audio: %w", err)
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("failed to decode audio: %w", err)
+	}
+
+	if duration <= 0 {
+		duration = float64(len(peaks)*waveformSlice) / waveformSampleRate
+	}
+	waveform := dto.MediaWaveformDto{Duration: duration, Peaks: downsamplePeaks(peaks, waveformPoints)}
+	data, err := json.Marshal(waveform)
+	if err != nil {
+		return err
+	}
+	return saveMediaFile(path, data)
+}
+
+// downsamplePeaks reduces the peaks to at most n by taking the maximum of each group, rounded to 3 decimals.
+func downsamplePeaks(peaks []float64, n int) []float64 {
+	points := min(len(peaks), n)
+	result := make([]float64, points)
+	for i := range points {
+		from, to := i*len(peaks)/points, (i+1)*len(peaks)/points
+		peak := 0.0
+		for _, p := range peaks[from:to] {
+			peak = max(peak, p)
+		}
+		result[i] = math.Round(peak*1000) / 1000
+	}
+	return result
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"cmp"
+	"embox/internal/api/dto"
+	"embox/internal/models"
+	"embox/internal/repositories"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+// BackfillService creates derived files that are missing for existing media,
+// e.g. thumbnail renditions added to the configuration after the media was uploaded
+// or the previews and web versions of videos and the waveforms of audio files uploaded before they were introduced.
+// It also names the places of geotagged media, e.g. uploaded before a GeoNames dataset was configured,
+// and computes missing content hashes for duplicate detection, perceptual hashes for near-duplicate detection
+// and the dimensions and placeholders of the media grid. Uploads whose processing failed are processed again.
+type BackfillService struct {
+	mediaService *MediaService
+	mediaRepo    repositories.MediaRepository
+}
+
+func NewBackfillService(mediaService *MediaService, mediaRepo repositories.MediaRepository) *BackfillService {
+	return &BackfillService{mediaService: mediaService, mediaRepo: mediaRepo}
+}
+
+// Run checks all media and creates what is missing, processing up to concurrency media at a time.
+func (s *BackfillService) Run(concurrency int) (*dto.BackfillReportDto, error) {
+	mediaList, err := s.mediaRepo.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load media: %w", err)
+	}
+
+	report := &dto.BackfillReportDto{
+		CheckedMedia: len(mediaList),
+		Thumbnails:   []uint{},
+		Previews:     []uint{},
+		Audio:        []uint{},
+		Transcoded:   []uint{},
+		Geocoded:     []uint{},
+		Hashed:       []uint{},
+		Checksummed:  []uint{},
+		Placeholders: []uint{},
+		Reprocessed:  []uint{},
+		Failed:       []dto.BackfillFailureDto{},
+	}
+
+	// record adds the media to the list of a step, or to the failures if the step failed
+	var mu sync.Mutex
+	record := func(list *[]uint, id uint, msg string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			slog.Error(msg, "media", id, "err", err)
+			report.Failed = append(report.Failed, dto.BackfillFailureDto{Id: id, Error: err.Error()})
+			return
+		}
+		*list = append(*list, id)
+	}
+
+	// Places are looked up in memory, so they are named right away rather than by the workers
+	if s.mediaService.CanGeocode() {
+		locations, err := s.mediaRepo.GetLocationsWithoutPlace()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load media locations: %w", err)
+		}
+		for _, location := range locations {
+			if named, err := s.mediaService.namePlace(location); named || err != nil {
+				record(&report.Geocoded, location.ID, "failed to name place", err)
+			}
+		}
+	}
+
+	jobs := make(chan *models.Media)
+	var wg sync.WaitGroup
+	for range max(concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for media := range jobs {
+				if media.ProcessingStatus == ProcessingFailed {
+					// The upload is processed again, which creates everything
+					record(&report.Reprocessed, media.ID, "failed to process upload", s.mediaService.RetryProcessing(media))
+					continue
+				}
+				if len(s.mediaService.MissingThumbnailSizes(media)) > 0 {
+					record(&report.Thumbnails, media.ID, "failed to create thumbnail renditions", s.mediaService.RegenerateThumbnail(media))
+				}
+				if s.mediaService.MissingContentHash(media) {
+					record(&report.Checksummed, media.ID, "failed to compute content hash", s.mediaService.UpdateContentHash(media))
+				}
+				if s.mediaService.MissingPerceptualHash(media) {
+					record(&report.Hashed, media.ID, "failed to compute perceptual hash", s.mediaService.UpdatePerceptualHash(media))
+				}
+				if s.mediaService.MissingPlaceholder(media) {
+					record(&report.Placeholders, media.ID, "failed to compute placeholder", s.mediaService.UpdatePlaceholder(media))
+				}
+				if s.mediaService.MissingVideoPreviews(media) {
+					record(&report.Previews, media.ID, "failed to create video previews", s.mediaService.RegenerateVideoPreviews(media))
+				}
+				if s.mediaService.MissingAudioData(media) {
+					record(&report.Audio, media.ID, "failed to process audio file", s.mediaService.RegenerateAudioData(media))
+				}
+				if s.mediaService.CanTranscode(media) {
+					record(&report.Transcoded, media.ID, "failed to transcode video", s.mediaService.TranscodeVideo(media))
+				}
+			}
+		}()
+	}
+
+	for _, media := range mediaList {
+		if media.ProcessingStatus == ProcessingPending {
+			// The upload is still processed by a job, which creates everything
+			continue
+		}
+		jobs <- media
+	}
+	close(jobs)
+	wg.Wait()
+
+	slices.Sort(report.Thumbnails)
+	slices.Sort(report.Previews)
+	slices.Sort(report.Audio)
+	slices.Sort(report.Transcoded)
+	slices.Sort(report.Hashed)
+	slices.Sort(report.Checksummed)
+	slices.Sort(report.Placeholders)
+	slices.Sort(report.Reprocessed)
+	slices.SortFunc(report.Failed, func(a, b dto.BackfillFailureDto) int { return cmp.Compare(a.Id, b.Id) })
+	return report, nil
+}
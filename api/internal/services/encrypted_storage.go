@@ -0,0 +1,584 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"embox/internal/config"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Encrypted file format (version 1):
+//
+//	header: "EMBX" | version (1 byte) | reserved (3 bytes) | chunk size (uint32 BE) | salt (20 bytes)
+//	chunks: AES-256-GCM sealed chunks of chunk size plaintext bytes, the last one may be shorter (or empty)
+//
+// Every file gets its own key, derived from the master key and the random salt with HKDF-SHA256.
+// The nonce of a chunk is its index (uint64 BE) followed by a flag that marks the last chunk,
+// so chunks cannot be reordered or the file truncated unnoticed. The header is authenticated as AAD.
+const (
+	encMagic           = "EMBX"
+	encVersion         = 1
+	encHeaderSize      = 32
+	encSaltSize        = 20
+	encDefaultChunk    = 64 << 10 // 64 KiB
+	encMaxChunk        = 16 << 20
+	encKeyDerivationID = "embox storage encryption v1"
+)
+
+var ErrInvalidEncryptionKey = errors.New("encryption key must be 32 bytes (raw, hex or base64)")
+
+// EncryptedStorage encrypts originals before they are handed to the wrapped storage,
+// so the storage provider only ever sees ciphertext. Files without the header
+// (uploaded before encryption was enabled) are returned unchanged.
+type EncryptedStorage struct {
+	storage   Storage
+	key       []byte
+	chunkSize int
+}
+
+func NewEncryptedStorage(storage Storage, key []byte) *EncryptedStorage {
+	return &EncryptedStorage{storage: storage, key: key, chunkSize: encDefaultChunk}
+}
+
+// LoadEncryptionKey reads the master key from STORAGE_ENCRYPTION_KEY or the file in STORAGE_ENCRYPTION_KEY_FILE.
+// It returns nil if neither is set.
+func LoadEncryptionKey(cfg *config.StorageConfig) ([]byte, error) {
+	value := cfg.EncryptionKey
+	if cfg.EncryptionKeyFile != "" {
+		data, err := os.ReadFile(cfg.EncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+		}
+		if len(data) == 32 {
+			return data, nil // raw key
+		}
+		value = strings.TrimSpace(string(data))
+	}
+	if value == "" {
+		return nil, nil
+	}
+
+	if key, err := hex.DecodeString(value); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(value); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, ErrInvalidEncryptionKey
+}
+
+func (s *EncryptedStorage) Upload(r io.Reader, size int64, filePath string) error {
+	header := make([]byte, encHeaderSize)
+	copy(header, encMagic)
+	header[4] = encVersion
+	binary.BigEndian.PutUint32(header[8:12], uint32(s.chunkSize))
+	if _, err := rand.Read(header[encHeaderSize-encSaltSize:]); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	aead, err := s.fileCipher(header)
+	if err != nil {
+		return err
+	}
+
+	encSize := int64(-1)
+	if size >= 0 {
+		encSize = encryptedSize(size, int64(s.chunkSize))
+	}
+
+	enc := &encryptingReader{
+		aead:   aead,
+		header: header,
+		src:    bufio.NewReaderSize(r, s.chunkSize+1),
+		buf:    make([]byte, s.chunkSize),
+		sealed: make([]byte, 0, s.chunkSize+aead.Overhead()),
+		out:    header,
+	}
+	return s.storage.Upload(enc, encSize, filePath)
+}
+
+func (s *EncryptedStorage) Download(path string) ([]byte, string, error) {
+	resp, err := s.DownloadStream(path, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read stream data: %w", err)
+	}
+
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+// DownloadStream returns the decrypted file. A single byte range is mapped to the chunks containing it,
+// only these chunks are fetched from the wrapped storage and the response is a 206 with plaintext offsets.
+func (s *EncryptedStorage) DownloadStream(path string, headers http.Header) (*http.Response, error) {
+	start, end, ok := parseSingleRange(headers.Get("Range"))
+	if !ok {
+		// No or unsupported range (e.g. multiple ranges): the whole file is returned
+		resp, err := s.storage.DownloadStream(path, conditionalHeaders(headers))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			return resp, err
+		}
+		return s.decryptFull(path, resp)
+	}
+
+	// Fetch the header first, it holds the salt and tells the total size via Content-Range
+	headerReq := conditionalHeaders(headers)
+	headerReq.Set("Range", fmt.Sprintf("bytes=0-%d", encHeaderSize-1))
+	if ifRange := headers.Get("If-Range"); ifRange != "" {
+		headerReq.Set("If-Range", ifRange)
+	}
+	resp, err := s.storage.DownloadStream(path, headerReq)
+	if err != nil {
+		return nil, err
+	}
+
+	// rest holds the remaining file after the header, if the storage returned the whole file
+	var rest io.ReadCloser
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+	case resp.StatusCode == http.StatusOK && headers.Get("If-Range") == "":
+		// The storage does not support ranges: the range is cut out of the whole file
+		rest = resp.Body
+	case resp.StatusCode == http.StatusOK:
+		// If-Range did not match: serve the whole file
+		return s.decryptFull(path, resp)
+	default:
+		return resp, nil
+	}
+
+	header := make([]byte, encHeaderSize)
+	_, err = io.ReadFull(resp.Body, header)
+	if rest == nil {
+		resp.Body.Close()
+	}
+	if err != nil || !isEncryptedHeader(header) {
+		// Not encrypted (or shorter than a header): pass the original request through
+		resp.Body.Close()
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return nil, fmt.Errorf("failed to read encryption header: %w", err)
+		}
+		return s.storage.DownloadStream(path, headers)
+	}
+
+	var encTotal int64
+	if rest == nil {
+		encTotal, err = contentRangeTotal(resp.Header.Get("Content-Range"))
+	} else {
+		encTotal, err = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	}
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to determine encrypted file size: %w", err)
+	}
+
+	fail := func(err error) (*http.Response, error) {
+		if rest != nil {
+			rest.Close()
+		}
+		return nil, err
+	}
+
+	aead, err := s.fileCipher(header)
+	if err != nil {
+		return fail(err)
+	}
+	chunkSize := int64(binary.BigEndian.Uint32(header[8:12]))
+	total, err := plaintextSize(encTotal, chunkSize)
+	if err != nil {
+		return fail(err)
+	}
+
+	// Resolve open-ended and suffix ranges against the plaintext size
+	if start < 0 {
+		start, end = max(total-end, 0), total-1
+	} else if end < 0 || end >= total {
+		end = total - 1
+	}
+	if start >= total {
+		fail(nil)
+		return &http.Response{
+			StatusCode: http.StatusRequestedRangeNotSatisfiable,
+			Header:     http.Header{"Content-Range": {fmt.Sprintf("bytes */%d", total)}},
+			Body:       http.NoBody,
+		}, nil
+	}
+
+	firstChunk, lastChunk := start/chunkSize, end/chunkSize
+	encStart := encHeaderSize + firstChunk*(chunkSize+int64(aead.Overhead()))
+	encEnd := min(encHeaderSize+(lastChunk+1)*(chunkSize+int64(aead.Overhead()))-1, encTotal-1)
+
+	var body io.ReadCloser
+	if rest != nil {
+		if _, err := io.CopyN(io.Discard, rest, encStart-encHeaderSize); err != nil {
+			return fail(fmt.Errorf("failed to skip to range start: %w", err))
+		}
+		body = struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(rest, encEnd-encStart+1), rest}
+	} else if body, err = s.fetchRange(path, encStart, encEnd); err != nil {
+		return nil, err
+	}
+
+	dec := &decryptingReader{
+		aead:      aead,
+		header:    header,
+		src:       bufio.NewReader(body),
+		closer:    body,
+		buf:       make([]byte, chunkSize+int64(aead.Overhead())),
+		index:     uint64(firstChunk),
+		lastIndex: chunkCount(total, chunkSize) - 1,
+	}
+	if _, err := io.CopyN(io.Discard, dec, start-firstChunk*chunkSize); err != nil {
+		dec.Close()
+		return nil, fmt.Errorf("failed to decrypt range: %w", err)
+	}
+
+	length := end - start + 1
+	out := &http.Response{
+		StatusCode: http.StatusPartialContent,
+		Header:     make(http.Header),
+		Body: struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(dec, length), dec},
+		ContentLength: length,
+	}
+	out.Header.Set("Content-Type", plaintextContentType(path))
+	out.Header.Set("Content-Length", strconv.FormatInt(length, 10))
+	out.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, total))
+	out.Header.Set("Accept-Ranges", "bytes")
+	copyValidators(out.Header, resp.Header)
+	return out, nil
+}
+
+func (s *EncryptedStorage) Delete(filePath string) error {
+	return s.storage.Delete(filePath)
+}
+
+func (s *EncryptedStorage) Move(srcPath, dstPath string) error {
+	return s.storage.Move(srcPath, dstPath)
+}
+
+func (s *EncryptedStorage) List() ([]string, error) {
+	return s.storage.List()
+}
+
+// decryptFull wraps a complete (200) response of the wrapped storage into a decrypting response.
+func (s *EncryptedStorage) decryptFull(path string, resp *http.Response) (*http.Response, error) {
+	src := bufio.NewReader(resp.Body)
+	header, err := src.Peek(encHeaderSize)
+	if err != nil || !isEncryptedHeader(header) {
+		// Not encrypted: return the file as it is
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{src, resp.Body}
+		return resp, nil
+	}
+	header = append([]byte(nil), header...)
+	src.Discard(encHeaderSize)
+
+	aead, err := s.fileCipher(header)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	chunkSize := int64(binary.BigEndian.Uint32(header[8:12]))
+
+	dec := &decryptingReader{
+		aead:      aead,
+		header:    header,
+		src:       src,
+		closer:    resp.Body,
+		buf:       make([]byte, chunkSize+int64(aead.Overhead())),
+		lastIndex: -1,
+	}
+
+	out := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        make(http.Header),
+		Body:          dec,
+		ContentLength: -1,
+	}
+	if resp.ContentLength >= 0 {
+		if size, err := plaintextSize(resp.ContentLength, chunkSize); err == nil {
+			out.ContentLength = size
+			out.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+			dec.lastIndex = chunkCount(size, chunkSize) - 1
+		}
+	}
+	out.Header.Set("Content-Type", plaintextContentType(path))
+	out.Header.Set("Accept-Ranges", "bytes")
+	copyValidators(out.Header, resp.Header)
+	return out, nil
+}
+
+// fetchRange returns the bytes start to end (inclusive) of the stored file,
+// also if the wrapped storage ignores the Range header.
+func (s *EncryptedStorage) fetchRange(path string, start, end int64) (io.ReadCloser, error) {
+	resp, err := s.storage.DownloadStream(path, http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", start, end)}})
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		if _, err := io.CopyN(io.Discard, resp.Body, start); err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to skip to range start: %w", err)
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(resp.Body, end-start+1), resp.Body}, nil
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("range download failed with status %d", resp.StatusCode)
+	}
+}
+
+// fileCipher derives the key of a file from the master key and the salt in its header.
+func (s *EncryptedStorage) fileCipher(header []byte) (cipher.AEAD, error) {
+	if header[4] != encVersion {
+		return nil, fmt.Errorf("unsupported encryption version %d", header[4])
+	}
+	if chunkSize := binary.BigEndian.Uint32(header[8:12]); chunkSize == 0 || chunkSize > encMaxChunk {
+		return nil, fmt.Errorf("invalid encryption chunk size %d", chunkSize)
+	}
+
+	key, err := hkdf.Key(sha256.New, s.key, header[encHeaderSize-encSaltSize:], encKeyDerivationID, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive file key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptingReader produces the header followed by the sealed chunks of src.
+type encryptingReader struct {
+	aead   cipher.AEAD
+	header []byte
+	src    *bufio.Reader
+	buf    []byte
+	sealed []byte
+	out    []byte // pending output, starts with the header
+	index  uint64
+	done   bool
+}
+
+func (e *encryptingReader) Read(p []byte) (int, error) {
+	for len(e.out) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(e.src, e.buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return 0, err
+		}
+		// The chunk is the last one if the source has no more bytes
+		last := err != nil
+		if !last {
+			if _, peekErr := e.src.Peek(1); peekErr == io.EOF {
+				last = true
+			} else if peekErr != nil {
+				return 0, peekErr
+			}
+		}
+		e.out = e.aead.Seal(e.sealed[:0], chunkNonce(e.index, last), e.buf[:n], e.header)
+		e.index++
+		e.done = last
+	}
+
+	n := copy(p, e.out)
+	e.out = e.out[n:]
+	return n, nil
+}
+
+// decryptingReader opens the sealed chunks read from src, starting at chunk index.
+// If lastIndex is negative, the last chunk is detected by the end of src.
+type decryptingReader struct {
+	aead      cipher.AEAD
+	header    []byte
+	src       *bufio.Reader
+	closer    io.Closer
+	buf       []byte
+	out       []byte
+	index     uint64
+	lastIndex int64
+	done      bool
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(d.src, d.buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF // a stream always ends with a (possibly empty) last chunk
+			}
+			return 0, fmt.Errorf("failed to read encrypted chunk: %w", err)
+		}
+
+		last := int64(d.index) == d.lastIndex
+		if d.lastIndex < 0 {
+			last = err != nil
+			if !last {
+				if _, peekErr := d.src.Peek(1); peekErr == io.EOF {
+					last = true
+				}
+			}
+		}
+
+		plain, openErr := d.aead.Open(d.buf[:0], chunkNonce(d.index, last), d.buf[:n], d.header)
+		if openErr != nil {
+			return 0, fmt.Errorf("failed to decrypt chunk %d: %w", d.index, openErr)
+		}
+		d.out = plain
+		d.index++
+		d.done = last
+	}
+
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+func (d *decryptingReader) Close() error {
+	return d.closer.Close()
+}
+
+func chunkNonce(index uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, index)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func isEncryptedHeader(header []byte) bool {
+	return len(header) >= encHeaderSize && bytes.Equal(header[:4], []byte(encMagic))
+}
+
+// chunkCount returns the number of chunks of a file with the given plaintext size.
+// An empty file still has one (empty) chunk.
+func chunkCount(size, chunkSize int64) int64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + chunkSize - 1) / chunkSize
+}
+
+func encryptedSize(size, chunkSize int64) int64 {
+	return encHeaderSize + size + chunkCount(size, chunkSize)*16
+}
+
+func plaintextSize(encSize, chunkSize int64) (int64, error) {
+	body := encSize - encHeaderSize
+	fullChunks := body / (chunkSize + 16)
+	rest := body % (chunkSize + 16)
+	if body < 16 || (rest > 0 && rest < 16) {
+		return 0, fmt.Errorf("invalid encrypted file size %d", encSize)
+	}
+	if rest == 0 {
+		return fullChunks * chunkSize, nil
+	}
+	return fullChunks*chunkSize + rest - 16, nil
+}
+
+// parseSingleRange parses "bytes=start-end", "bytes=start-" and "bytes=-suffix".
+// For suffix ranges start is -1 and end holds the suffix length; open ranges have end -1.
+func parseSingleRange(value string) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(value, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, false
+	}
+
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix <= 0 {
+			return 0, 0, false
+		}
+		return -1, suffix, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	if last == "" {
+		return start, -1, true
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// contentRangeTotal returns the complete length from a "bytes start-end/total" header.
+func contentRangeTotal(value string) (int64, error) {
+	_, total, ok := strings.Cut(value, "/")
+	if !ok || total == "*" {
+		return 0, fmt.Errorf("missing total size in Content-Range %q", value)
+	}
+	return strconv.ParseInt(total, 10, 64)
+}
+
+// conditionalHeaders returns a copy of the cache validators of the client request.
+func conditionalHeaders(headers http.Header) http.Header {
+	out := make(http.Header)
+	for _, key := range []string{"If-None-Match", "If-Modified-Since"} {
+		if value := headers.Get(key); value != "" {
+			out.Set(key, value)
+		}
+	}
+	return out
+}
+
+func copyValidators(dst, src http.Header) {
+	for _, key := range []string{"ETag", "Last-Modified"} {
+		if value := src.Get(key); value != "" {
+			dst.Set(key, value)
+		}
+	}
+}
+
+// plaintextContentType guesses the type from the extension, as the stored bytes are ciphertext.
+func plaintextContentType(path string) string {
+	if contentType := mime.TypeByExtension(filepath.Ext(path)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
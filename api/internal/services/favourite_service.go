@@ -102,12 +102,15 @@ func (s *FavouriteService) GetFavouritesByUserID(favUserID, userEmail string) (d
 	mediaDtos := make([]dto.MediaResponseDto, len(results))
 	for i, fav := range results {
 		mediaDtos[i] = dto.MediaResponseDto{
-			Id:          fav.ID,
-			IsFavourite: fav.IsFavourite,
-			Caption:     fav.Caption,
-			Date:        fav.Date.Format("2006-01-02"),
-			Type:        fav.Type,
-			CreatedAt:   fav.CreatedAt,
+			Id:             fav.ID,
+			IsFavourite:    fav.IsFavourite,
+			Caption:        fav.Caption,
+			Date:           fav.Date.Format("2006-01-02"),
+			Type:           fav.Type,
+			CreatedAt:      fav.CreatedAt,
+			Place:          fav.Place,
+			Country:        fav.Country,
+			MediaLayoutDto: mediaLayout(&fav.Media),
 		}
 	}
 
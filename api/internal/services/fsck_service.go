@@ -0,0 +1,221 @@
+package services
+
+import (
+	"embox/internal/api/dto"
+	"embox/internal/models"
+	"embox/internal/repositories"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var ErrFsckRunning = errors.New("consistency check is already running")
+
+// localBasePathPattern matches the BasePath prefix (yyyy/mm/dd_Id) of a file below MediaDir.
+var localBasePathPattern = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2}_\d+)[._]`)
+
+// FsckService checks that the media table, the storage backend and MediaDir agree with each other.
+// It reports media rows without original or thumbnail and files that no media row points to.
+type FsckService struct {
+	storage      Storage
+	mediaService *MediaService
+	mediaRepo    repositories.MediaRepository
+
+	running sync.Mutex
+}
+
+func NewFsckService(storage Storage, mediaService *MediaService, mediaRepo repositories.MediaRepository) *FsckService {
+	return &FsckService{storage: storage, mediaService: mediaService, mediaRepo: mediaRepo}
+}
+
+// Run performs the check and the repairs selected in opts.
+// Only one check runs at a time; a concurrent call returns ErrFsckRunning.
+func (s *FsckService) Run(opts dto.FsckRequestDto) (*dto.FsckReportDto, error) {
+	if !s.running.TryLock() {
+		return nil, ErrFsckRunning
+	}
+	defer s.running.Unlock()
+
+	// The files are listed before the rows are loaded: the row of an upload is created before its files
+	// are stored, so files stored while the check runs belong to a loaded row and are not taken for orphans
+	remoteFiles, err := s.storage.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list storage: %w", err)
+	}
+	// Uploads still being written by the local storage
+	remoteFiles = slices.DeleteFunc(remoteFiles, func(file string) bool {
+		return strings.HasSuffix(file, ".part")
+	})
+	slices.Sort(remoteFiles)
+	localFiles, err := listMediaDir()
+	if err != nil {
+		return nil, err
+	}
+	mediaList, err := s.mediaRepo.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load media: %w", err)
+	}
+
+	report := &dto.FsckReportDto{
+		CheckedMedia:      len(mediaList),
+		MissingOriginals:  []dto.FsckMediaDto{},
+		MissingThumbnails: []dto.FsckMediaDto{},
+		OrphanFiles:       []dto.FsckFileDto{},
+	}
+
+	remoteSet := make(map[string]bool, len(remoteFiles))
+	for _, file := range remoteFiles {
+		remoteSet[file] = true
+	}
+	localSet := make(map[string]bool, len(localFiles))
+	for _, file := range localFiles {
+		localSet[file] = true
+	}
+
+	knownRemote := make(map[string]bool, len(mediaList))
+	knownBasePaths := make(map[string]bool, len(mediaList))
+	var broken, healed []uint
+
+	for _, media := range mediaList {
+		knownRemote[media.RemotePath()] = true
+		knownBasePaths[media.BasePath()] = true
+		if media.ProcessingStatus == ProcessingPending {
+			// The original is still in the staging dir and the thumbnails are not created yet
+			continue
+		}
+
+		hasOriginal := remoteSet[media.RemotePath()]
+		if !hasOriginal {
+			report.MissingOriginals = append(report.MissingOriginals, fsckMedia(media, media.RemotePath()))
+			if !media.IsBroken {
+				broken = append(broken, media.ID)
+			}
+		} else if media.IsBroken {
+			healed = append(healed, media.ID)
+		}
+
+		if hasThumbnail(media) && !localSet[media.Path()] {
+			report.MissingThumbnails = append(report.MissingThumbnails, fsckMedia(media, media.Path()))
+			if opts.RegenerateThumbnails {
+				s.regenerateThumbnail(report, media, hasOriginal)
+			}
+		}
+	}
+
+	for _, file := range remoteFiles {
+		if !knownRemote[file] {
+			report.OrphanFiles = append(report.OrphanFiles, dto.FsckFileDto{Location: "storage", Path: file})
+		}
+	}
+	for _, file := range localFiles {
+		match := localBasePathPattern.FindStringSubmatch(file)
+		if match == nil || !knownBasePaths[match[1]] {
+			report.OrphanFiles = append(report.OrphanFiles, dto.FsckFileDto{Location: "local", Path: file})
+		}
+	}
+
+	if opts.DeleteOrphans {
+		s.deleteOrphans(report)
+	}
+	if opts.MarkBroken {
+		s.markBroken(report, broken, healed)
+	}
+
+	return report, nil
+}
+
+func (s *FsckService) regenerateThumbnail(report *dto.FsckReportDto, media *models.Media, hasOriginal bool) {
+	if !hasOriginal {
+		report.Errors = append(report.Errors, fmt.Sprintf("media %d: cannot regenerate thumbnail without original", media.ID))
+		return
+	}
+	if err := s.mediaService.RegenerateThumbnail(media); err != nil {
+		slog.Error("failed to regenerate thumbnail", "media", media.ID, "err", err)
+		report.Errors = append(report.Errors, fmt.Sprintf("media %d: %v", media.ID, err))
+		return
+	}
+	report.RegeneratedThumbnails = append(report.RegeneratedThumbnails, media.ID)
+}
+
+func (s *FsckService) deleteOrphans(report *dto.FsckReportDto) {
+	for _, orphan := range report.OrphanFiles {
+		var err error
+		if orphan.Location == "storage" {
+			err = s.storage.Delete(orphan.Path)
+		} else {
+			err = os.Remove(filepath.Join(MediaDir, filepath.FromSlash(orphan.Path)))
+		}
+		if err != nil {
+			slog.Error("failed to delete orphan file", "location", orphan.Location, "path", orphan.Path, "err", err)
+			report.Errors = append(report.Errors, fmt.Sprintf("%s file %s: %v", orphan.Location, orphan.Path, err))
+			continue
+		}
+		report.DeletedOrphans = append(report.DeletedOrphans, orphan)
+	}
+}
+
+// markBroken flags media rows whose original is missing and clears the flag of rows whose original is back.
+func (s *FsckService) markBroken(report *dto.FsckReportDto, broken, healed []uint) {
+	if len(broken) > 0 {
+		if err := s.mediaRepo.SetBroken(broken, true); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("failed to mark media as broken: %v", err))
+		} else {
+			report.MarkedBroken = broken
+		}
+	}
+	if len(healed) > 0 {
+		if err := s.mediaRepo.SetBroken(healed, false); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("failed to unmark media as broken: %v", err))
+		}
+	}
+}
+
+// hasThumbnail reports whether a local thumbnail is generated for the media type.
+func hasThumbnail(media *models.Media) bool {
+	return media.Type == "image" || media.Type == "video"
+}
+
+func fsckMedia(media *models.Media, path string) dto.FsckMediaDto {
+	return dto.FsckMediaDto{Id: media.ID, Type: media.Type, Path: path}
+}
+
+// listMediaDir returns all files below MediaDir as slash separated relative paths.
+// Temp files and dirs (*.tmp), which are still being written, e.g. by the transcoding, are skipped.
+func listMediaDir() ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(MediaDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == MediaDir && errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if strings.HasSuffix(d.Name(), ".tmp") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(MediaDir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list local media files: %w", err)
+	}
+	slices.Sort(files)
+	return files, nil
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"embox/internal/repositories"
+	"embox/pkg/geocoder"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// geocodeMaxDistance is the distance in km up to which the nearest city names the place of a position.
+// Positions farther from any city of the dataset, e.g. at sea, get no place.
+const geocodeMaxDistance = 50
+
+// loadGeocoder reads the GeoNames cities export, or returns nil if none is configured or it cannot be read.
+func loadGeocoder(path string) *geocoder.Geocoder {
+	if path == "" {
+		return nil
+	}
+	start := time.Now()
+	g, err := geocoder.Load(path)
+	if err != nil {
+		slog.Warn("reverse geocoding disabled, failed to load GeoNames file", "file", path, "err", err)
+		return nil
+	}
+	slog.Info("loaded GeoNames places", "file", path, "places", g.Len(), "duration", time.Since(start))
+	return g
+}
+
+// CanGeocode reports whether a GeoNames dataset is loaded to name the places of geotagged media.
+func (s *MediaService) CanGeocode() bool {
+	return s.geocoder != nil
+}
+
+// lookupPlace returns the name and country of the city nearest to the position,
+// or empty strings if the position is unknown, no city is near or no dataset is loaded.
+func (s *MediaService) lookupPlace(latitude, longitude *float64) (string, string) {
+	if s.geocoder == nil || latitude == nil || longitude == nil {
+		return "", ""
+	}
+	place := s.geocoder.Nearest(*latitude, *longitude, geocodeMaxDistance)
+	if place == nil {
+		return "", ""
+	}
+	return place.Name, place.Country()
+}
+
+// namePlace saves the place of a media item at the location. It reports false if no city is near.
+func (s *MediaService) namePlace(location *repositories.MediaLocation) (bool, error) {
+	place, country := s.lookupPlace(&location.Latitude, &location.Longitude)
+	if place == "" {
+		return false, nil
+	}
+	if err := s.mediaRepo.SetPlace(location.ID, place, country); err != nil {
+		return false, fmt.Errorf("failed to save place: %w", err)
+	}
+	return true, nil
+}
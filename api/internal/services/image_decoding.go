@@ -0,0 +1,260 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// imageFileExts are the extensions of image formats that browsers often upload without a MIME type,
+// mostly HEIC photos of iPhones and camera RAW files.
+var imageFileExts = []string{"heic", "heif", "avif", "dng", "cr2", "cr3", "nef", "nrw", "arw", "orf", "rw2", "raf", "pef", "srw"}
+
+// isImageFileExt reports whether the file extension is one of imageFileExts.
+func isImageFileExt(ext string) bool {
+	return slices.Contains(imageFileExts, strings.ToLower(ext))
+}
+
+// decodeImageFile decodes an uploaded image and rotates it according to its orientation.
+// TIFF based camera RAW files (DNG, CR2, NEF, ARW, …) are read from their largest embedded JPEG preview,
+// formats the standard library cannot decode otherwise are decoded by ffmpeg (HEIC/HEIF, AVIF, other RAW files).
+func (s *MediaService) decodeImageFile(file *os.File) (image.Image, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind temp file: %w", err)
+	}
+	img, err := decodeImage(file)
+	if err == nil && img.Bounds().Empty() {
+		err = errors.New("failed to decode image: no pixels")
+	}
+
+	// RAW files also decode as TIFF, but only to the small thumbnail or nothing at all in IFD0
+	if preview, previewErr := decodeRawPreview(file); previewErr == nil && (err != nil || pixels(preview) > pixels(img)) {
+		return preview, nil
+	}
+	if err == nil {
+		return img, nil
+	}
+
+	img, ffmpegErr := s.decodeWithFFmpeg(file.Name())
+	if ffmpegErr != nil {
+		return nil, fmt.Errorf("%w, %w", err, ffmpegErr)
+	}
+	return img, nil
+}
+
+func pixels(img image.Image) int {
+	return img.Bounds().Dx() * img.Bounds().Dy()
+}
+
+// decodeWithFFmpeg converts the image to PNG with ffmpeg, as wide as the largest rendition at most.
+// ffmpeg applies the rotation and mirroring of HEIF/AVIF images (irot/imir) itself,
+// their EXIF orientation only repeats it and must not be applied again.
+// Tiled HEIC images, as taken by iPhones, require ffmpeg 7.1 or later.
+func (s *MediaService) decodeWithFFmpeg(imagePath string) (image.Image, error) {
+	tmpImage := imagePath + "_decoded.png"
+	defer os.Remove(tmpImage)
+
+	sizes := s.ThumbnailSizes()
+	err := runFFmpeg(time.Minute,
+		"-i", imagePath,
+		"-vf", fmt.Sprintf("scale='min(%d,iw)':-2", sizes[len(sizes)-1]),
+		"-frames:v", "1",
+		"-f", "image2", "-c:v", "png", tmpImage,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image with ffmpeg: %w", err)
+	}
+
+	f, err := os.Open(tmpImage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read decoded image: %w", err)
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, nil
+}
+
+// TIFF tags locating the embedded previews of RAW files
+const (
+	tiffTagCompression    = 0x0103
+	tiffTagStripOffsets   = 0x0111
+	tiffTagOrientation    = 0x0112
+	tiffTagStripByteCount = 0x0117
+	tiffTagSubIFDs        = 0x014A
+	tiffTagJPEGOffset     = 0x0201 // JPEGInterchangeFormat
+	tiffTagJPEGLength     = 0x0202 // JPEGInterchangeFormatLength
+
+	maxRawIFDs = 32 // IFDs visited at most, against loops in broken files
+)
+
+// rawPreview is the location of a JPEG embedded in a TIFF based RAW file.
+type rawPreview struct {
+	offset, length int64
+	pixels         int
+}
+
+// decodeRawPreview decodes the largest JPEG preview embedded in a TIFF based RAW file
+// and rotates it according to the orientation of the file.
+// Previews are found in the IFD chain and its SubIFDs, either as JPEGInterchangeFormat
+// or as a single JPEG compressed strip (old-style or baseline JPEG, lossless raw data is skipped).
+func decodeRawPreview(file *os.File) (image.Image, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r := io.NewSectionReader(file, 0, info.Size())
+
+	var header [8]byte
+	if _, err := r.ReadAt(header[:], 0); err != nil {
+		return nil, fmt.Errorf("failed to read raw header: %w", err)
+	}
+	var order binary.ByteOrder
+	switch string(header[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("not a TIFF based raw file")
+	}
+
+	var best *rawPreview
+	orientation := 1
+	visited := map[int64]bool{}
+	queue := []int64{int64(order.Uint32(header[4:]))}
+	for len(queue) > 0 && len(visited) < maxRawIFDs {
+		offset := queue[0]
+		queue = queue[1:]
+		if offset <= 0 || offset >= info.Size() || visited[offset] {
+			continue
+		}
+		visited[offset] = true
+
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			continue
+		}
+		dir, next, err := tiff.DecodeDir(r, order)
+		if err != nil {
+			continue
+		}
+		isIFD0 := len(visited) == 1
+		queue = append(queue, int64(uint32(next)))
+
+		tags := map[uint16]*tiff.Tag{}
+		for _, tag := range dir.Tags {
+			tags[tag.Id] = tag
+		}
+		if tag, ok := tags[tiffTagSubIFDs]; ok {
+			for i := range int(tag.Count) {
+				if sub, err := tag.Int64(i); err == nil {
+					queue = append(queue, sub)
+				}
+			}
+		}
+		if tag, ok := tags[tiffTagOrientation]; ok && isIFD0 {
+			if value, err := tag.Int(0); err == nil {
+				orientation = value
+			}
+		}
+
+		preview := jpegPreviewLocation(tags)
+		if preview == nil || preview.offset+preview.length > info.Size() {
+			continue
+		}
+		cfg, err := jpeg.DecodeConfig(io.NewSectionReader(r, preview.offset, preview.length))
+		if err != nil {
+			continue
+		}
+		preview.pixels = cfg.Width * cfg.Height
+		if best == nil || preview.pixels > best.pixels {
+			best = preview
+		}
+	}
+	if best == nil {
+		return nil, errors.New("no JPEG preview found")
+	}
+
+	img, err := jpeg.Decode(io.NewSectionReader(r, best.offset, best.length))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode raw preview: %w", err)
+	}
+	if orientation > 1 {
+		img = applyOrientation(img, orientation)
+	}
+	return img, nil
+}
+
+// jpegPreviewLocation returns the location of the JPEG stored by the IFD, or nil if it stores none.
+func jpegPreviewLocation(tags map[uint16]*tiff.Tag) *rawPreview {
+	offsetTag, lengthTag := tags[tiffTagJPEGOffset], tags[tiffTagJPEGLength]
+	if offsetTag == nil || lengthTag == nil {
+		compression, ok := tags[tiffTagCompression]
+		if !ok {
+			return nil
+		}
+		if value, err := compression.Int(0); err != nil || (value != 6 && value != 7) {
+			return nil
+		}
+		offsetTag, lengthTag = tags[tiffTagStripOffsets], tags[tiffTagStripByteCount]
+		if offsetTag == nil || lengthTag == nil || offsetTag.Count != 1 {
+			return nil
+		}
+	}
+
+	offset, err := offsetTag.Int64(0)
+	if err != nil {
+		return nil
+	}
+	length, err := lengthTag.Int64(0)
+	if err != nil || offset <= 0 || length <= 0 {
+		return nil
+	}
+	return &rawPreview{offset: offset, length: length}
+}
+
+// maxExifScan is how far into a HEIF file its EXIF item is searched for.
+const maxExifScan = 16 << 20
+
+// decodeExif reads the EXIF data of JPEG and TIFF based files (including most RAW formats) and of HEIF/AVIF files,
+// whose EXIF item starts with the "Exif\0\0" header. The ISO base media box structure is not parsed,
+// the header is searched for in the first maxExifScan bytes instead.
+func decodeExif(r io.ReadSeeker) (*exif.Exif, error) {
+	x, err := exif.Decode(r)
+	if err == nil {
+		return x, nil
+	}
+
+	if _, seekErr := r.Seek(0, io.SeekStart); seekErr != nil {
+		return nil, err
+	}
+	var header [12]byte
+	if _, readErr := io.ReadFull(r, header[:]); readErr != nil || string(header[4:8]) != "ftyp" {
+		return nil, err
+	}
+	if _, seekErr := r.Seek(0, io.SeekStart); seekErr != nil {
+		return nil, err
+	}
+	data, readErr := io.ReadAll(io.LimitReader(r, maxExifScan))
+	if readErr != nil {
+		return nil, err
+	}
+	start := bytes.Index(data, []byte("Exif\x00\x00"))
+	if start < 0 {
+		return nil, err
+	}
+	return exif.Decode(bytes.NewReader(data[start:]))
+}
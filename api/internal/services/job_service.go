@@ -0,0 +1,230 @@
+package services
+
+import (
+	"embox/internal/api/dto"
+	"embox/internal/config"
+	"embox/internal/models"
+	"embox/internal/repositories"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Interval in which idle workers look for jobs that became due, e.g. retries
+const jobPollInterval = 5 * time.Second
+
+// JobHandler runs a job of one type. It may report the progress in percent while it runs.
+// A returned error fails the attempt; the job is retried until its MaxAttempts are used up.
+type JobHandler func(job *models.Job, progress func(percent int)) error
+
+// JobService runs background jobs stored in the database, so they survive restarts.
+// A pool of MediaConfig.JobWorkers workers claims the due jobs, failed jobs are retried with
+// exponential backoff and kept as "dead" once they failed MaxAttempts times.
+type JobService struct {
+	config   *config.MediaConfig
+	jobRepo  repositories.JobRepository
+	userRepo repositories.UserRepository
+
+	handlers  map[string]JobHandler
+	wake      chan struct{}
+	startOnce sync.Once
+}
+
+func NewJobService(cfg *config.MediaConfig, jobRepo repositories.JobRepository, userRepo repositories.UserRepository) *JobService {
+	return &JobService{
+		config:   cfg,
+		jobRepo:  jobRepo,
+		userRepo: userRepo,
+		handlers: make(map[string]JobHandler),
+		wake:     make(chan struct{}, max(cfg.JobWorkers, 1)),
+	}
+}
+
+// Handle registers the handler running the jobs of the given type.
+func (s *JobService) Handle(jobType string, handler JobHandler) {
+	s.handlers[jobType] = handler
+}
+
+// Start requeues the jobs interrupted by a restart and starts the workers.
+// Command line tools do not call it, without workers jobs are run by Enqueue.
+func (s *JobService) Start() {
+	if s.config.JobWorkers <= 0 {
+		return
+	}
+	s.startOnce.Do(func() {
+		if n, err := s.jobRepo.ResetRunning(); err != nil {
+			slog.Error("failed to requeue interrupted jobs", "err", err)
+		} else if n > 0 {
+			slog.Info("requeued interrupted jobs", "count", n)
+		}
+		for range s.config.JobWorkers {
+			go s.work()
+		}
+	})
+}
+
+// Enqueue stores a new job and wakes a worker.
+// Without workers the job is run right away, once, and its error is returned.
+func (s *JobService) Enqueue(job *models.Job) error {
+	job.Status = models.JobPending
+	job.MaxAttempts = max(s.config.JobMaxAttempts, 1)
+	job.RunAt = time.Now()
+
+	if s.config.JobWorkers <= 0 {
+		job.Status = models.JobRunning
+		job.Attempts = 1
+		job.MaxAttempts = 1
+		if err := s.jobRepo.Create(job); err != nil {
+			return fmt.Errorf("failed to create job: %w", err)
+		}
+		return s.run(job)
+	}
+
+	if err := s.jobRepo.Create(job); err != nil {
+		return fmt.Errorf("failed to create job: %w", err)
+	}
+	s.notify()
+	return nil
+}
+
+// Retry enqueues the last job of the given type for the media item again, with the same arguments.
+func (s *JobService) Retry(mediaId uint, jobType string) (*models.Job, error) {
+	last, err := s.jobRepo.GetLatest(mediaId, jobType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find job: %w", err)
+	}
+	if last == nil {
+		return nil, fmt.Errorf("no %s job for media %d", jobType, mediaId)
+	}
+	job := &models.Job{
+		Type:    last.Type,
+		MediaID: last.MediaID,
+		UserID:  last.UserID,
+		Payload: last.Payload,
+	}
+	return job, s.Enqueue(job)
+}
+
+// notify wakes an idle worker. If all workers are busy, they look for more jobs when done.
+func (s *JobService) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// GetJob returns the job if the user caused it or is an admin, nil otherwise.
+func (s *JobService) GetJob(id uint, userEmail string) (*dto.JobResponseDto, error) {
+	user, err := s.userRepo.GetByEmail(userEmail)
+	if err != nil || user == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	job, err := s.jobRepo.GetById(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find job with id %d: %w", id, err)
+	}
+	if job == nil || (!user.IsAdmin && (job.UserID == nil || *job.UserID != user.ID)) {
+		return nil, nil
+	}
+
+	return &dto.JobResponseDto{
+		Id:          job.ID,
+		Type:        job.Type,
+		MediaId:     job.MediaID,
+		Status:      job.Status,
+		Progress:    job.Progress,
+		Attempts:    job.Attempts,
+		MaxAttempts: job.MaxAttempts,
+		Error:       job.LastError,
+		CreatedAt:   job.CreatedAt,
+		UpdatedAt:   job.UpdatedAt,
+	}, nil
+}
+
+func (s *JobService) work() {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			job, err := s.jobRepo.Claim()
+			if err != nil {
+				slog.Error("failed to claim job", "err", err)
+				break
+			}
+			if job == nil {
+				break
+			}
+			if err := s.run(job); err != nil {
+				slog.Error("job failed", "job", job.ID, "type", job.Type, "attempt", job.Attempts, "err", err)
+			}
+		}
+
+		select {
+		case <-s.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// run executes a claimed job and saves the outcome: done, pending for a retry or dead.
+func (s *JobService) run(job *models.Job) error {
+	var err error
+	handler, ok := s.handlers[job.Type]
+	if !ok {
+		err = fmt.Errorf("unknown job type %q", job.Type)
+		job.Attempts = job.MaxAttempts // retrying will not help
+	} else {
+		err = s.call(handler, job)
+	}
+
+	switch {
+	case err == nil:
+		job.Status = models.JobDone
+		job.Progress = 100
+		job.LastError = ""
+	case isLastAttempt(job):
+		job.Status = models.JobDead
+		job.LastError = err.Error()
+	default:
+		delay := s.retryDelay(job.Attempts)
+		job.Status = models.JobPending
+		job.LastError = err.Error()
+		job.RunAt = time.Now().Add(delay)
+		time.AfterFunc(delay, s.notify)
+	}
+
+	if finishErr := s.jobRepo.Finish(job); finishErr != nil {
+		slog.Error("failed to save job status", "job", job.ID, "err", finishErr)
+	}
+	return err
+}
+
+// call runs the handler, a panic fails the attempt instead of stopping the worker.
+func (s *JobService) call(handler JobHandler, job *models.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return handler(job, func(percent int) {
+		job.Progress = percent
+		if err := s.jobRepo.SetProgress(job.ID, percent); err != nil {
+			slog.Warn("failed to save job progress", "job", job.ID, "err", err)
+		}
+	})
+}
+
+// retryDelay returns the delay after the given failed attempt: JobRetryDelay, doubled with every further attempt.
+func (s *JobService) retryDelay(attempts int) time.Duration {
+	delay := time.Duration(max(s.config.JobRetryDelay, 1)) * time.Second
+	return delay << min(attempts-1, 10)
+}
+
+// isLastAttempt reports whether the job will not be retried if the running attempt fails.
+func isLastAttempt(job *models.Job) bool {
+	return job.Attempts >= job.MaxAttempts
+}
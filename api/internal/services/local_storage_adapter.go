@@ -1,13 +1,16 @@
 package services
 
 import (
-	"bytes"
 	"fmt"
 	"io"
+	"io/fs"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type LocalStorageAdapter struct {
@@ -19,12 +22,32 @@ func NewLocalStorageAdapter(dir string) *LocalStorageAdapter {
 	return &LocalStorageAdapter{dir: dir}
 }
 
-func (a *LocalStorageAdapter) Upload(data []byte, filePath string) error {
+func (a *LocalStorageAdapter) Upload(r io.Reader, size int64, filePath string) error {
 	dest := filepath.Join(a.dir, filePath)
 	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
 		return fmt.Errorf("failed to create directories: %w", err)
 	}
-	return os.WriteFile(dest, data, 0644)
+
+	// Write to a temporary file first, so a failed upload never leaves a truncated original behind
+	tmp := dest + ".part"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create local file: %w", err)
+	}
+	defer os.Remove(tmp)
+
+	written, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write local file: %w", err)
+	}
+	if size >= 0 && written != size {
+		return fmt.Errorf("size mismatch: expected %d bytes, wrote %d", size, written)
+	}
+
+	return os.Rename(tmp, dest)
 }
 
 func (a *LocalStorageAdapter) Download(path string) ([]byte, string, error) {
@@ -42,27 +65,137 @@ func (a *LocalStorageAdapter) Download(path string) ([]byte, string, error) {
 	return data, resp.Header.Get("Content-Type"), nil
 }
 
-func (a *LocalStorageAdapter) DownloadStream(path string, _ http.Header) (*http.Response, error) {
-	fullPath := filepath.Join(a.dir, path)
-
-	data, err := os.ReadFile(fullPath)
+// DownloadStream streams the file at path without loading it into memory.
+// Like S3 or WebDAV servers, it honours Range (single ranges), If-Range, If-None-Match and
+// If-Modified-Since, and answers with 206, 304 or 416 where appropriate.
+// The caller is responsible for closing the response body.
+func (a *LocalStorageAdapter) DownloadStream(path string, headers http.Header) (*http.Response, error) {
+	f, err := os.Open(filepath.Join(a.dir, path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open local file: %w", err)
+	}
+	stat, err := f.Stat()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read local file: %w", err)
+		f.Close()
+		return nil, fmt.Errorf("failed to stat local file: %w", err)
 	}
 
-	mimeType := http.DetectContentType(data)
+	size := stat.Size()
+	modTime := stat.ModTime().UTC().Truncate(time.Second)
+	etag := fmt.Sprintf(`"%x-%x"`, stat.ModTime().UnixNano(), size)
 
 	resp := &http.Response{
 		StatusCode: http.StatusOK,
 		Header:     make(http.Header),
-		Body:       io.NopCloser(bytes.NewReader(data)),
+		Body:       f,
+	}
+	resp.Header.Set("ETag", etag)
+	resp.Header.Set("Last-Modified", modTime.Format(http.TimeFormat))
+	resp.Header.Set("Accept-Ranges", "bytes")
+
+	if isNotModified(headers, etag, modTime) {
+		f.Close()
+		resp.StatusCode = http.StatusNotModified
+		resp.Body = http.NoBody
+		return resp, nil
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		sniff := make([]byte, 512)
+		n, _ := f.ReadAt(sniff, 0)
+		contentType = http.DetectContentType(sniff[:n])
+	}
+	resp.Header.Set("Content-Type", contentType)
+
+	start, end, ok := parseSingleRange(headers.Get("Range"))
+	if ok && rangeStillValid(headers.Get("If-Range"), etag, modTime) {
+		if start < 0 {
+			start, end = max(size-end, 0), size-1
+		} else if end < 0 || end >= size {
+			end = size - 1
+		}
+		if start >= size {
+			f.Close()
+			resp.StatusCode = http.StatusRequestedRangeNotSatisfiable
+			resp.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			resp.Body = http.NoBody
+			return resp, nil
+		}
+
+		length := end - start + 1
+		resp.StatusCode = http.StatusPartialContent
+		resp.ContentLength = length
+		resp.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		resp.Header.Set("Content-Length", strconv.FormatInt(length, 10))
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.NewSectionReader(f, start, length), f}
+		return resp, nil
 	}
-	resp.Header.Set("Content-Type", mimeType)
-	resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
 
+	resp.ContentLength = size
+	resp.Header.Set("Content-Length", strconv.FormatInt(size, 10))
 	return resp, nil
 }
 
 func (a *LocalStorageAdapter) Delete(filePath string) error {
 	return os.Remove(filepath.Join(a.dir, filePath))
 }
+
+func (a *LocalStorageAdapter) Move(srcPath, dstPath string) error {
+	dest := filepath.Join(a.dir, dstPath)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create directories: %w", err)
+	}
+	return os.Rename(filepath.Join(a.dir, srcPath), dest)
+}
+
+func (a *LocalStorageAdapter) List() ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(a.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(a.dir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list local files: %w", err)
+	}
+	return files, nil
+}
+
+// isNotModified evaluates If-None-Match, or If-Modified-Since if no If-None-Match is sent (RFC 9110 13.2.2).
+func isNotModified(headers http.Header, etag string, modTime time.Time) bool {
+	if inm := headers.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims, err := http.ParseTime(headers.Get("If-Modified-Since")); err == nil {
+		return !modTime.After(ims)
+	}
+	return false
+}
+
+// rangeStillValid evaluates If-Range: the range applies only if the validator matches the current file.
+func rangeStillValid(ifRange, etag string, modTime time.Time) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		return ifRange == etag
+	}
+	date, err := http.ParseTime(ifRange)
+	return err == nil && modTime.Equal(date)
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"cmp"
+	"embox/internal/api/dto"
+	"embox/internal/models"
+	"embox/internal/repositories"
+	"errors"
+	"fmt"
+	"image"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/chai2010/webp"
+	"github.com/disintegration/imaging"
+)
+
+// Hamming distances of perceptual hashes accepted by GetDuplicateGroups. Resized and re-compressed copies
+// of a photo usually differ by a few bits, unrelated photos by about half of the 64 bits.
+const (
+	DefaultDuplicateThreshold = 6
+	MaxDuplicateThreshold     = 16
+)
+
+// ErrNotDuplicate is returned by ResolveDuplicates for media that are not similar to the kept media item.
+var ErrNotDuplicate = errors.New("media is not a duplicate of the kept media")
+
+// perceptualHash returns the 64-bit difference hash (dHash) of the image as 16 hex digits: the image is
+// reduced to 9x8 gray pixels and each bit tells whether a pixel is brighter than its right neighbour.
+// Unlike the content hash it survives resizing, re-compression and small colour changes.
+func perceptualHash(img image.Image) string {
+	small := imaging.Resize(imaging.Grayscale(img), 9, 8, imaging.Box)
+	var hash uint64
+	for y := range 8 {
+		for x := range 8 {
+			left := small.Pix[small.PixOffset(x, y)]
+			right := small.Pix[small.PixOffset(x+1, y)]
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return fmt.Sprintf("%016x", hash)
+}
+
+// MissingPerceptualHash reports whether the perceptual hash of an image or video is missing,
+// e.g. because it was uploaded before near-duplicates were detected.
+func (s *MediaService) MissingPerceptualHash(media *models.Media) bool {
+	return (media.Type == "image" || media.Type == "video") && media.PerceptualHash == ""
+}
+
+// UpdatePerceptualHash computes the perceptual hash of an image or video from its default thumbnail,
+// so the original does not have to be downloaded.
+func (s *MediaService) UpdatePerceptualHash(media *models.Media) error {
+	f, err := os.Open(filepath.Join(MediaDir, media.Path()))
+	if err != nil {
+		return fmt.Errorf("failed to open thumbnail: %w", err)
+	}
+	defer f.Close()
+	img, err := webp.Decode(f)
+	if err != nil {
+		return fmt.Errorf("failed to decode thumbnail: %w", err)
+	}
+
+	media.PerceptualHash = perceptualHash(img)
+	return s.mediaRepo.SetPerceptualHash(media.ID, media.PerceptualHash)
+}
+
+// GetDuplicateGroups groups the media visible to the user whose perceptual hashes differ by at most
+// threshold bits. Similarity is transitive within a group, so its items may differ by more than threshold
+// from each other. Groups are ordered by their latest media, newest first.
+func (s *MediaService) GetDuplicateGroups(userEmail string, threshold int) ([]dto.DuplicateGroupDto, error) {
+	user, err := s.userRepo.GetByEmail(userEmail)
+	if err != nil || user == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	mediaList, err := s.mediaRepo.Get(user.ID, repositories.MediaFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	var hashed []*repositories.MediaListItem
+	var hashes []uint64
+	for _, media := range mediaList {
+		if hash, err := strconv.ParseUint(media.PerceptualHash, 16, 64); err == nil {
+			hashed = append(hashed, media)
+			hashes = append(hashes, hash)
+		}
+	}
+
+	// Union-find over the pairs within threshold, found with a BK-tree instead of comparing all pairs
+	parent := make([]int, len(hashed))
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	var tree *bkNode
+	for i, hash := range hashes {
+		parent[i] = i
+		if tree == nil {
+			tree = &bkNode{hash: hash, index: i}
+			continue
+		}
+		tree.search(hash, threshold, func(j int) {
+			parent[find(j)] = find(i)
+		})
+		tree.add(hash, i)
+	}
+
+	components := make(map[int][]int)
+	for i := range hashed {
+		root := find(i)
+		components[root] = append(components[root], i)
+	}
+
+	type group struct {
+		members []int
+		latest  time.Time
+	}
+	var groups []group
+	for _, members := range components {
+		if len(members) < 2 {
+			continue
+		}
+		slices.SortFunc(members, func(a, b int) int {
+			return cmp.Or(hashed[a].Date.Compare(hashed[b].Date), cmp.Compare(hashed[a].ID, hashed[b].ID))
+		})
+		groups = append(groups, group{members: members, latest: hashed[members[len(members)-1]].Date})
+	}
+	slices.SortFunc(groups, func(a, b group) int {
+		return cmp.Or(b.latest.Compare(a.latest), cmp.Compare(hashed[a.members[0]].ID, hashed[b.members[0]].ID))
+	})
+
+	result := []dto.DuplicateGroupDto{}
+	for _, g := range groups {
+		groupDto := dto.DuplicateGroupDto{}
+		for _, i := range g.members {
+			media := hashed[i]
+			groupDto.Distance = max(groupDto.Distance, bits.OnesCount64(hashes[i]^hashes[g.members[0]]))
+			groupDto.Media = append(groupDto.Media, dto.MediaResponseDto{
+				Id:          media.ID,
+				IsFavourite: media.IsFavourite,
+				Caption:     media.Caption,
+				Date:        media.Date.Format(time.RFC3339),
+				Type:        media.Type,
+				CreatedAt:   media.CreatedAt,
+				IsBroken:    media.IsBroken,
+				Place:       media.Place,
+				Country:     media.Country,
+			})
+		}
+		result = append(result, groupDto)
+	}
+	return result, nil
+}
+
+// ResolveDuplicates keeps the media item keepId and moves the media items ids to the trash,
+// or deletes them permanently. Their album memberships, album covers and favourites are taken over by keepId.
+// Each of the media items must have a perceptual hash within threshold bits of the hash of keepId.
+func (s *MediaService) ResolveDuplicates(keepId uint, ids []uint, threshold int, permanent bool) error {
+	if slices.Contains(ids, keepId) {
+		return fmt.Errorf("media %d cannot be kept and removed", keepId)
+	}
+	keep, err := s.mediaRepo.GetById(keepId)
+	if err != nil {
+		return fmt.Errorf("failed to find media with id %d: %w", keepId, err)
+	}
+	if keep == nil {
+		return fmt.Errorf("media with id %d not found", keepId)
+	}
+	duplicates, err := s.mediaRepo.GetByIDs(ids)
+	if err != nil {
+		return fmt.Errorf("failed to fetch media: %w", err)
+	}
+	if len(duplicates) != len(ids) {
+		return fmt.Errorf("some media were not found")
+	}
+	keepHash, err := strconv.ParseUint(keep.PerceptualHash, 16, 64)
+	if err != nil {
+		return fmt.Errorf("%w: media %d has no perceptual hash", ErrNotDuplicate, keepId)
+	}
+	for _, media := range duplicates {
+		hash, err := strconv.ParseUint(media.PerceptualHash, 16, 64)
+		if err != nil || bits.OnesCount64(hash^keepHash) > threshold {
+			return fmt.Errorf("%w: media %d", ErrNotDuplicate, media.ID)
+		}
+	}
+
+	if err := s.mediaRepo.MergeInto(keepId, ids); err != nil {
+		return fmt.Errorf("failed to merge albums and favourites: %w", err)
+	}
+	if permanent {
+		return s.PurgeMedia(duplicates)
+	}
+	return s.DeleteMedia(ids)
+}
+
+// bkNode is a node of a BK-tree of 64-bit hashes under the Hamming distance. The children of a node
+// are keyed by their distance to it, so by the triangle inequality a search within threshold only
+// descends into children whose key differs from the node's distance by at most threshold.
+type bkNode struct {
+	hash     uint64
+	index    int
+	children map[int]*bkNode
+}
+
+func (n *bkNode) add(hash uint64, index int) {
+	for {
+		d := bits.OnesCount64(n.hash ^ hash)
+		child, ok := n.children[d]
+		if !ok {
+			if n.children == nil {
+				n.children = make(map[int]*bkNode)
+			}
+			n.children[d] = &bkNode{hash: hash, index: index}
+			return
+		}
+		n = child
+	}
+}
+
+func (n *bkNode) search(hash uint64, threshold int, found func(index int)) {
+	d := bits.OnesCount64(n.hash ^ hash)
+	if d <= threshold {
+		found(n.index)
+	}
+	for key, child := range n.children {
+		if key >= d-threshold && key <= d+threshold {
+			child.search(hash, threshold, found)
+		}
+	}
+}
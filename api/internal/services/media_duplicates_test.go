@@ -0,0 +1,94 @@
+package services
+
+import (
+	"image"
+	"image/color"
+	"math/bits"
+	"math/rand"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestPerceptualHash(t *testing.T) {
+	gradient := func(width, height int, falling bool) image.Image {
+		img := image.NewNRGBA(image.Rect(0, 0, width, height))
+		for y := range height {
+			for x := range width {
+				v := uint8(x * 255 / (width - 1))
+				if falling {
+					v = 255 - v
+				}
+				img.Set(x, y, color.NRGBA{v, v, v, 255})
+			}
+		}
+		return img
+	}
+
+	// Each bit is set if a pixel is brighter than its right neighbour
+	if got := perceptualHash(gradient(90, 80, false)); got != "0000000000000000" {
+		t.Errorf("rising gradient: got %s", got)
+	}
+	if got := perceptualHash(gradient(90, 80, true)); got != "ffffffffffffffff" {
+		t.Errorf("falling gradient: got %s", got)
+	}
+
+	// A resized copy keeps its hash, a mirrored image gets a distant one
+	photo := image.NewNRGBA(image.Rect(0, 0, 320, 240))
+	for y := range 240 {
+		for x := range 320 {
+			v := uint8(x * 200 / 320)
+			if x > 80 && x < 160 && y > 60 && y < 180 {
+				v = 255
+			}
+			photo.Set(x, y, color.NRGBA{v, v / 2, 255 - v, 255})
+		}
+	}
+	distance := func(a, b image.Image) int {
+		ha, _ := strconv.ParseUint(perceptualHash(a), 16, 64)
+		hb, _ := strconv.ParseUint(perceptualHash(b), 16, 64)
+		return bits.OnesCount64(ha ^ hb)
+	}
+	if d := distance(photo, imaging.Resize(photo, 160, 120, imaging.Lanczos)); d > DefaultDuplicateThreshold {
+		t.Errorf("expected resized copy within %d bits, got %d", DefaultDuplicateThreshold, d)
+	}
+	if d := distance(photo, imaging.FlipH(photo)); d <= MaxDuplicateThreshold {
+		t.Errorf("expected mirrored image further than %d bits, got %d", MaxDuplicateThreshold, d)
+	}
+}
+
+func TestBkTreeSearch(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var hashes []uint64
+	for range 300 {
+		hash := rng.Uint64()
+		hashes = append(hashes, hash)
+		// Near copies with a few flipped bits
+		for range rng.Intn(3) {
+			hashes = append(hashes, hash^(1<<rng.Intn(64))^(1<<rng.Intn(64)))
+		}
+	}
+
+	tree := &bkNode{hash: hashes[0], index: 0}
+	for i, hash := range hashes[1:] {
+		tree.add(hash, i+1)
+	}
+
+	for _, threshold := range []int{0, 2, DefaultDuplicateThreshold, 30} {
+		for _, query := range hashes[:50] {
+			var got, want []int
+			tree.search(query, threshold, func(i int) { got = append(got, i) })
+			for i, hash := range hashes {
+				if bits.OnesCount64(hash^query) <= threshold {
+					want = append(want, i)
+				}
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, want) {
+				t.Fatalf("search(%016x, %d) = %v, want %v", query, threshold, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"cmp"
+	"embox/internal/api/dto"
+	"embox/internal/models"
+	"embox/internal/repositories"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// MaxGeoZoom is the highest web map zoom level accepted by GetGeoClusters.
+const MaxGeoZoom = 22
+
+// geoClusterCells is the number of grid cells per 256px map tile side, i.e. clusters cover about 64px.
+const geoClusterCells = 4
+
+type geoCluster struct {
+	count  int
+	lonSum float64
+	latSum float64
+	latest *repositories.MediaLocation
+}
+
+// GetGeoClusters returns the media within the bounding box as GeoJSON points, clustered on a grid in web map
+// (Web Mercator) coordinates that gets finer with the zoom level. Each feature is placed at the centre
+// of its media and carries their count and the ID of the latest of them.
+// minLon may be greater than maxLon for boxes spanning the antimeridian.
+func (s *MediaService) GetGeoClusters(minLon, minLat, maxLon, maxLat float64, zoom int) (*dto.GeoFeatureCollectionDto, error) {
+	bounds := repositories.GeoBounds{MinLon: minLon, MinLat: minLat, MaxLon: maxLon, MaxLat: maxLat}
+	locations, err := s.mediaRepo.GetLocations(bounds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch media locations: %w", err)
+	}
+
+	cellsPerSide := math.Exp2(float64(zoom)) * geoClusterCells
+	clusters := make(map[[2]int]*geoCluster)
+	for _, location := range locations {
+		x, y := webMercator(location.Longitude, location.Latitude)
+		key := [2]int{int(x * cellsPerSide), int(y * cellsPerSide)}
+
+		cluster, ok := clusters[key]
+		if !ok {
+			cluster = &geoCluster{}
+			clusters[key] = cluster
+		}
+		cluster.count++
+		cluster.lonSum += location.Longitude
+		cluster.latSum += location.Latitude
+		if cluster.latest == nil || location.Date.After(cluster.latest.Date) {
+			cluster.latest = location
+		}
+	}
+
+	result := &dto.GeoFeatureCollectionDto{Type: "FeatureCollection", Features: []dto.GeoFeatureDto{}}
+	for _, cluster := range clusters {
+		n := float64(cluster.count)
+		result.Features = append(result.Features, dto.GeoFeatureDto{
+			Type: "Feature",
+			Geometry: dto.GeoPointDto{
+				Type:        "Point",
+				Coordinates: [2]float64{cluster.lonSum / n, cluster.latSum / n},
+			},
+			Properties: dto.GeoClusterPropertiesDto{Count: cluster.count, MediaId: cluster.latest.ID},
+		})
+	}
+	slices.SortFunc(result.Features, func(a, b dto.GeoFeatureDto) int {
+		return cmp.Or(cmp.Compare(b.Properties.Count, a.Properties.Count), cmp.Compare(a.Properties.MediaId, b.Properties.MediaId))
+	})
+
+	return result, nil
+}
+
+// locatedMetadata returns the metadata of the media placed at the given position, or without position
+// if latitude or longitude is nil, to be saved along with the media. The altitude read from the file
+// no longer applies and is removed.
+func (s *MediaService) locatedMetadata(mediaId uint, latitude, longitude *float64) (*models.MediaMetadata, error) {
+	metadata, err := s.mediaRepo.GetMetadata(mediaId)
+	if err != nil {
+		return nil, err
+	}
+	if metadata == nil {
+		metadata = &models.MediaMetadata{MediaID: mediaId}
+	}
+
+	metadata.Latitude, metadata.Longitude, metadata.Altitude = nil, nil, nil
+	if latitude != nil && longitude != nil {
+		metadata.Latitude, metadata.Longitude = latitude, longitude
+	}
+	return metadata, nil
+}
+
+// validLocation reports whether both coordinates are given and within range.
+func validLocation(latitude, longitude *float64) bool {
+	return latitude != nil && longitude != nil &&
+		*latitude >= -90 && *latitude <= 90 && *longitude >= -180 && *longitude <= 180
+}
+
+// webMercator projects a position to web map coordinates in [0, 1), with y growing southwards.
+func webMercator(lon, lat float64) (float64, float64) {
+	lat = max(-85.05112878, min(85.05112878, lat)) * math.Pi / 180
+	x := (lon + 180) / 360
+	y := (1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2
+	return min(x, math.Nextafter(1, 0)), min(y, math.Nextafter(1, 0))
+}
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"bytes"
+	"embox/internal/api/dto"
+	"embox/internal/models"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// offsetTimeOriginal is the time zone of DateTimeOriginal (EXIF 2.31). goexif does not know the tag,
+// offsetTimeParser loads it from the Exif sub-IFD.
+const offsetTimeOriginal exif.FieldName = "OffsetTimeOriginal"
+
+var offsetTimePattern = regexp.MustCompile(`^[+-]\d{2}:\d{2}$`)
+
+type offsetTimeParser struct{}
+
+func (offsetTimeParser) Parse(x *exif.Exif) error {
+	tag, err := x.Get(exif.ExifIFDPointer)
+	if err != nil {
+		return nil
+	}
+	offset, err := tag.Int64(0)
+	if err != nil {
+		return nil
+	}
+	r := bytes.NewReader(x.Raw)
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil
+	}
+	dir, _, err := tiff.DecodeDir(r, x.Tiff.Order)
+	if err != nil {
+		return nil
+	}
+	x.LoadTags(dir, map[uint16]exif.FieldName{0x9011: offsetTimeOriginal}, false)
+	return nil
+}
+
+func init() {
+	exif.RegisterParsers(offsetTimeParser{})
+}
+
+// GetMediaDetail returns a media item with its metadata, or nil if it does not exist.
+func (s *MediaService) GetMediaDetail(id uint, userEmail string) (*dto.MediaDetailResponseDto, error) {
+	user, err := s.userRepo.GetByEmail(userEmail)
+	if err != nil || user == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	media, err := s.mediaRepo.GetByIdForUser(id, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve media: %w", err)
+	}
+	if media == nil {
+		return nil, nil
+	}
+	metadata, err := s.mediaRepo.GetMetadata(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve metadata: %w", err)
+	}
+
+	result := &dto.MediaDetailResponseDto{
+		MediaResponseDto: dto.MediaResponseDto{
+			Id:          media.ID,
+			IsFavourite: media.IsFavourite,
+			Caption:     media.Caption,
+			Date:        media.Date.Format(time.RFC3339),
+			Type:        media.Type,
+			CreatedAt:   media.CreatedAt,
+			IsBroken:    media.IsBroken,
+
+			ProcessingStatus: media.ProcessingStatus,
+			TranscodeStatus:  media.TranscodeStatus,
+			Duration:         media.Duration,
+			Bitrate:          media.Bitrate,
+			Title:            media.AudioTitle,
+			Artist:           media.AudioArtist,
+			Place:            media.Place,
+			Country:          media.Country,
+			MediaLayoutDto:   mediaLayout(&media.Media),
+		},
+	}
+	if metadata != nil {
+		result.Metadata = &dto.MediaMetadataDto{
+			CameraMake:   metadata.CameraMake,
+			CameraModel:  metadata.CameraModel,
+			LensModel:    metadata.LensModel,
+			FocalLength:  metadata.FocalLength,
+			FNumber:      metadata.FNumber,
+			ExposureTime: metadata.ExposureTime,
+			ISO:          metadata.ISO,
+			Width:        metadata.Width,
+			Height:       metadata.Height,
+			Latitude:     metadata.Latitude,
+			Longitude:    metadata.Longitude,
+			Altitude:     metadata.Altitude,
+			OffsetTime:   metadata.OffsetTime,
+		}
+	}
+	return result, nil
+}
+
+// readMetadata reads the metadata of an image or the dimensions of a video from the file,
+// see readImageMetadata. Both are nil for other media types or if the file cannot be read.
+func readMetadata(mediaType string, file *os.File) (*models.MediaMetadata, *time.Time) {
+	switch mediaType {
+	case "image":
+		return readImageMetadata(file)
+	case "video":
+		if info, err := probeVideo(file.Name()); err == nil {
+			return &models.MediaMetadata{Width: info.Width, Height: info.Height}, nil
+		}
+	}
+	return nil, nil
+}
+
+// readImageMetadata reads the dimensions and EXIF data of an image and returns them together with
+// the capture date (DateTimeOriginal), which is nil if unknown. Images the standard library cannot decode,
+// such as HEIC and RAW files, get their dimensions from the EXIF data. The metadata is nil if neither
+// the image format nor its EXIF data is supported. Without time zone (OffsetTimeOriginal) the capture date
+// is taken as UTC, like dates without time zone sent by clients.
+func readImageMetadata(r io.ReadSeeker) (*models.MediaMetadata, *time.Time) {
+	cfg, format, configErr := image.DecodeConfig(r)
+	metadata := &models.MediaMetadata{Width: cfg.Width, Height: cfg.Height}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, nil
+	}
+	x, err := decodeExif(r)
+	if err != nil {
+		if configErr != nil {
+			return nil, nil
+		}
+		return metadata, nil
+	}
+
+	// RAW files decode as TIFF, but only to the thumbnail in IFD0
+	if configErr != nil || format == "tiff" {
+		if width, height := exifInt(x, exif.PixelXDimension), exifInt(x, exif.PixelYDimension); width > 0 && height > 0 {
+			metadata.Width, metadata.Height = width, height
+		}
+	}
+
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		if orient, err := tag.Int(0); err == nil && orient >= 5 && orient <= 8 {
+			// Rotated by 90°, as the thumbnails are
+			metadata.Width, metadata.Height = metadata.Height, metadata.Width
+		}
+	}
+
+	metadata.CameraMake = exifString(x, exif.Make, 64)
+	metadata.CameraModel = exifString(x, exif.Model, 64)
+	metadata.LensModel = exifString(x, exif.LensModel, 128)
+	metadata.FocalLength = exifFloat(x, exif.FocalLength)
+	metadata.FNumber = exifFloat(x, exif.FNumber)
+	if tag, err := x.Get(exif.ExposureTime); err == nil {
+		if num, den, err := tag.Rat2(0); err == nil && num > 0 && den > 0 {
+			metadata.ExposureTime = formatExposureTime(num, den)
+		}
+	}
+	if tag, err := x.Get(exif.ISOSpeedRatings); err == nil {
+		if iso, err := tag.Int(0); err == nil && iso > 0 {
+			metadata.ISO = &iso
+		}
+	}
+
+	if lat, lon, err := x.LatLong(); err == nil && (lat != 0 || lon != 0) {
+		metadata.Latitude, metadata.Longitude = &lat, &lon
+		if alt := exifFloat(x, exif.GPSAltitude); alt != nil {
+			if tag, err := x.Get(exif.GPSAltitudeRef); err == nil && len(tag.Val) > 0 && tag.Val[0] == 1 {
+				*alt = -*alt // below sea level
+			}
+			metadata.Altitude = alt
+		}
+	}
+
+	location := time.UTC
+	if offset := exifString(x, offsetTimeOriginal, 6); offsetTimePattern.MatchString(offset) {
+		hours, _ := strconv.Atoi(offset[1:3])
+		minutes, _ := strconv.Atoi(offset[4:6])
+		seconds := hours*3600 + minutes*60
+		if offset[0] == '-' {
+			seconds = -seconds
+		}
+		metadata.OffsetTime = offset
+		location = time.FixedZone(offset, seconds)
+	}
+
+	var captured *time.Time
+	if value := exifString(x, exif.DateTimeOriginal, 32); value != "" {
+		if date, err := time.ParseInLocation("2006:01:02 15:04:05", value, location); err == nil {
+			captured = &date
+		}
+	}
+
+	return metadata, captured
+}
+
+// exifString returns the trimmed string value of the tag, at most maxLen bytes, or "" if it is missing.
+func exifString(x *exif.Exif, name exif.FieldName, maxLen int) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	value, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	value = strings.TrimSpace(strings.TrimRight(value, "\x00"))
+	if len(value) > maxLen {
+		value = strings.ToValidUTF8(value[:maxLen], "")
+	}
+	return value
+}
+
+// exifInt returns the integer value of the tag, or 0 if it is missing or invalid.
+func exifInt(x *exif.Exif, name exif.FieldName) int {
+	tag, err := x.Get(name)
+	if err != nil {
+		return 0
+	}
+	value, err := tag.Int(0)
+	if err != nil || value < 0 {
+		return 0
+	}
+	return value
+}
+
+// exifFloat returns the rational value of the tag, or nil if it is missing or invalid.
+func exifFloat(x *exif.Exif, name exif.FieldName) *float64 {
+	tag, err := x.Get(name)
+	if err != nil {
+		return nil
+	}
+	num, den, err := tag.Rat2(0)
+	if err != nil || den == 0 {
+		return nil
+	}
+	value := float64(num) / float64(den)
+	return &value
+}
+
+// formatExposureTime formats an exposure time as photographers write it: "1/250" or "2.5".
+func formatExposureTime(num, den int64) string {
+	if num < den {
+		return fmt.Sprintf("1/%d", (den+num/2)/num)
+	}
+	return strconv.FormatFloat(float64(num)/float64(den), 'f', -1, 64)
+}
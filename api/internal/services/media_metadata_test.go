@@ -0,0 +1,218 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testExifEntry struct {
+	tag   uint16
+	typ   uint16 // 1 BYTE, 2 ASCII, 3 SHORT, 4 LONG, 5 RATIONAL
+	count uint32
+	data  []byte
+}
+
+func testExifASCII(tag uint16, s string) testExifEntry {
+	return testExifEntry{tag, 2, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func testExifShort(tag uint16, v uint16) testExifEntry {
+	return testExifEntry{tag, 3, 1, binary.BigEndian.AppendUint16(nil, v)}
+}
+
+func testExifLong(tag uint16, v uint32) testExifEntry {
+	return testExifEntry{tag, 4, 1, binary.BigEndian.AppendUint32(nil, v)}
+}
+
+func testExifRational(tag uint16, values ...uint32) testExifEntry {
+	var data []byte
+	for _, v := range values {
+		data = binary.BigEndian.AppendUint32(data, v)
+	}
+	return testExifEntry{tag, 5, uint32(len(values) / 2), data}
+}
+
+// testTiff returns big-endian TIFF data with IFD0 and, if not empty, the Exif and GPS sub-IFDs.
+func testTiff(ifd0, exifIFD, gpsIFD []testExifEntry) []byte {
+	size := func(entries []testExifEntry) uint32 {
+		size := uint32(2 + 12*len(entries) + 4)
+		for _, e := range entries {
+			if len(e.data) > 4 {
+				size += uint32(len(e.data)+1) &^ 1
+			}
+		}
+		return size
+	}
+	appendIFD := func(buf []byte, entries []testExifEntry) []byte {
+		dataOffset := uint32(len(buf)) + uint32(2+12*len(entries)+4)
+		var data []byte
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(entries)))
+		for _, e := range entries {
+			buf = binary.BigEndian.AppendUint16(buf, e.tag)
+			buf = binary.BigEndian.AppendUint16(buf, e.typ)
+			buf = binary.BigEndian.AppendUint32(buf, e.count)
+			if len(e.data) <= 4 {
+				buf = append(buf, e.data...)
+				buf = append(buf, make([]byte, 4-len(e.data))...)
+				continue
+			}
+			buf = binary.BigEndian.AppendUint32(buf, dataOffset+uint32(len(data)))
+			data = append(data, e.data...)
+			if len(e.data)%2 == 1 {
+				data = append(data, 0)
+			}
+		}
+		buf = binary.BigEndian.AppendUint32(buf, 0) // no next IFD
+		return append(buf, data...)
+	}
+
+	// The sub-IFD pointers are placed before their values are known and patched afterwards
+	ifd0 = append(ifd0[:len(ifd0):len(ifd0)], testExifLong(0x8769, 0), testExifLong(0x8825, 0))
+	exifOffset := 8 + size(ifd0)
+	gpsOffset := exifOffset + size(exifIFD)
+	ifd0[len(ifd0)-2] = testExifLong(0x8769, exifOffset)
+	ifd0[len(ifd0)-1] = testExifLong(0x8825, gpsOffset)
+
+	buf := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	buf = appendIFD(buf, ifd0)
+	buf = appendIFD(buf, exifIFD)
+	return appendIFD(buf, gpsIFD)
+}
+
+// testExifJPEG returns a JPEG of the given size with the TIFF data in an APP1 segment.
+func testExifJPEG(t *testing.T, width, height int, tiffData []byte) []byte {
+	t.Helper()
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+	app1 := append([]byte("Exif\x00\x00"), tiffData...)
+	var out bytes.Buffer
+	out.Write(img.Bytes()[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(app1)+2))
+	out.Write(app1)
+	out.Write(img.Bytes()[2:])
+	return out.Bytes()
+}
+
+func TestReadImageMetadata(t *testing.T) {
+	tiffData := testTiff(
+		[]testExifEntry{
+			testExifASCII(0x010F, "Embox  "),
+			testExifASCII(0x0110, strings.Repeat("M", 70)),
+			testExifShort(0x0112, 8), // Orientation: rotate 90° CCW
+		},
+		[]testExifEntry{
+			testExifRational(0x829A, 10, 2500), // ExposureTime
+			testExifRational(0x829D, 4, 1),     // FNumber
+			testExifShort(0x8827, 1600),        // ISOSpeedRatings
+			testExifASCII(0x9003, "2023:12:31 23:15:00"),
+			testExifASCII(0x9011, "-05:30"), // OffsetTimeOriginal
+			testExifRational(0x920A, 35, 1), // FocalLength
+		},
+		[]testExifEntry{
+			testExifASCII(0x1, "S"),
+			testExifRational(0x2, 33, 1, 54, 1, 0, 1),
+			testExifASCII(0x3, "W"),
+			testExifRational(0x4, 18, 1, 25, 1, 12, 1),
+			{0x5, 1, 1, []byte{1}}, // GPSAltitudeRef: below sea level
+			testExifRational(0x6, 25, 2),
+		},
+	)
+
+	metadata, captured := readImageMetadata(bytes.NewReader(testExifJPEG(t, 40, 20, tiffData)))
+	if metadata == nil {
+		t.Fatal("expected metadata")
+	}
+	if metadata.Width != 20 || metadata.Height != 40 {
+		t.Errorf("expected 20x40 after rotation, got %dx%d", metadata.Width, metadata.Height)
+	}
+	// Trailing spaces are trimmed and long values truncated to the column size
+	if metadata.CameraMake != "Embox" || metadata.CameraModel != strings.Repeat("M", 64) || metadata.LensModel != "" {
+		t.Errorf("unexpected camera: %q %q %q", metadata.CameraMake, metadata.CameraModel, metadata.LensModel)
+	}
+	if metadata.ExposureTime != "1/250" || metadata.FNumber == nil || *metadata.FNumber != 4 ||
+		metadata.FocalLength == nil || *metadata.FocalLength != 35 || metadata.ISO == nil || *metadata.ISO != 1600 {
+		t.Errorf("unexpected exposure: %+v", metadata)
+	}
+	if metadata.Latitude == nil || *metadata.Latitude != -33.9 || metadata.Longitude == nil ||
+		*metadata.Longitude != -(18+25.0/60+12.0/3600) || metadata.Altitude == nil || *metadata.Altitude != -12.5 {
+		t.Errorf("unexpected location: %+v", metadata)
+	}
+	if metadata.OffsetTime != "-05:30" {
+		t.Errorf("expected offset -05:30, got %q", metadata.OffsetTime)
+	}
+	if captured == nil || !captured.Equal(time.Date(2024, 1, 1, 4, 45, 0, 0, time.UTC)) {
+		t.Errorf("expected capture date 2023-12-31T23:15:00-05:30, got %v", captured)
+	}
+}
+
+func TestReadImageMetadata_WithoutOffset(t *testing.T) {
+	tiffData := testTiff(nil, []testExifEntry{
+		testExifASCII(0x9003, "2023:07:14 18:30:00"),
+		testExifASCII(0x9011, "CEST"), // not an offset
+	}, nil)
+
+	metadata, captured := readImageMetadata(bytes.NewReader(testExifJPEG(t, 40, 20, tiffData)))
+	if metadata == nil || metadata.Width != 40 || metadata.Height != 20 || metadata.OffsetTime != "" {
+		t.Fatalf("unexpected metadata %+v", metadata)
+	}
+	if metadata.Latitude != nil || metadata.ExposureTime != "" || metadata.ISO != nil {
+		t.Errorf("expected no location and exposure, got %+v", metadata)
+	}
+	// Taken as UTC
+	if captured == nil || !captured.Equal(time.Date(2023, 7, 14, 18, 30, 0, 0, time.UTC)) {
+		t.Errorf("expected capture date 2023-07-14T18:30:00Z, got %v", captured)
+	}
+}
+
+func TestReadImageMetadata_Undecodable(t *testing.T) {
+	tiffData := testTiff(nil, []testExifEntry{
+		testExifLong(0xA002, 4032), // PixelXDimension
+		testExifLong(0xA003, 3024), // PixelYDimension
+	}, nil)
+
+	// The EXIF data of HEIC files is found by scanning for its header
+	heic := append([]byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic"), "Exif\x00\x00"...)
+	heic = append(heic, tiffData...)
+	metadata, captured := readImageMetadata(bytes.NewReader(heic))
+	if metadata == nil || metadata.Width != 4032 || metadata.Height != 3024 || captured != nil {
+		t.Errorf("expected 4032x3024 from the EXIF data, got %+v, %v", metadata, captured)
+	}
+
+	if metadata, captured := readImageMetadata(bytes.NewReader([]byte("not an image"))); metadata != nil || captured != nil {
+		t.Errorf("expected no metadata, got %+v, %v", metadata, captured)
+	}
+
+	// Decodable images without EXIF data still have their dimensions
+	var img bytes.Buffer
+	jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, 30, 10)), nil)
+	if metadata, _ := readImageMetadata(bytes.NewReader(img.Bytes())); metadata == nil || metadata.Width != 30 || metadata.Height != 10 {
+		t.Errorf("expected 30x10, got %+v", metadata)
+	}
+}
+
+func TestFormatExposureTime(t *testing.T) {
+	for _, tc := range []struct {
+		num, den int64
+		want     string
+	}{
+		{1, 250, "1/250"},
+		{10, 2500, "1/250"},
+		// Rounded to the nearest whole denominator
+		{3, 1000, "1/333"},
+		{1, 1, "1"},
+		{5, 2, "2.5"},
+		{30, 1, "30"},
+	} {
+		if got := formatExposureTime(tc.num, tc.den); got != tc.want {
+			t.Errorf("formatExposureTime(%d, %d) = %q, want %q", tc.num, tc.den, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"embox/internal/api/dto"
+	"embox/internal/models"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chai2010/webp"
+	"github.com/disintegration/imaging"
+)
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// setPlaceholder computes the dominant colour and BlurHash of the image, which is the original or
+// the thumbnail source. Unknown dimensions are taken from the image, which may be scaled down,
+// e.g. a video poster, but has the aspect ratio of the media.
+func (s *MediaService) setPlaceholder(media *models.Media, img image.Image) error {
+	if media.Width == 0 || media.Height == 0 {
+		media.Width, media.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	}
+	// Both are computed from a few pixels, the blur and the histogram don't need more
+	small := imaging.Fit(img, 64, 64, imaging.Box)
+	media.DominantColor = dominantColor(small)
+	media.BlurHash = blurHash(small)
+	return s.mediaRepo.SetPlaceholder(media)
+}
+
+// MissingPlaceholder reports whether the dimensions, dominant colour or BlurHash of an image or video
+// are missing, e.g. because it was uploaded before they were computed.
+func (s *MediaService) MissingPlaceholder(media *models.Media) bool {
+	return (media.Type == "image" || media.Type == "video") && (media.BlurHash == "" || media.Width == 0)
+}
+
+// UpdatePlaceholder computes the placeholder of an image or video from its default thumbnail,
+// so the original does not have to be downloaded. The dimensions are taken from its metadata if known.
+func (s *MediaService) UpdatePlaceholder(media *models.Media) error {
+	f, err := os.Open(filepath.Join(MediaDir, media.Path()))
+	if err != nil {
+		return fmt.Errorf("failed to open thumbnail: %w", err)
+	}
+	defer f.Close()
+	img, err := webp.Decode(f)
+	if err != nil {
+		return fmt.Errorf("failed to decode thumbnail: %w", err)
+	}
+
+	if media.Width == 0 || media.Height == 0 {
+		metadata, err := s.mediaRepo.GetMetadata(media.ID)
+		if err != nil {
+			return err
+		}
+		if metadata != nil {
+			media.Width, media.Height = metadata.Width, metadata.Height
+		}
+	}
+	return s.setPlaceholder(media, img)
+}
+
+// mediaLayout returns the dimensions and placeholder of the media for the grid.
+func mediaLayout(media *models.Media) dto.MediaLayoutDto {
+	return dto.MediaLayoutDto{
+		Width:         media.Width,
+		Height:        media.Height,
+		AspectRatio:   math.Round(media.AspectRatio()*10000) / 10000,
+		DominantColor: media.DominantColor,
+		BlurHash:      media.BlurHash,
+	}
+}
+
+// dominantColor returns the most frequent colour of the image as "#rrggbb". Colours are counted
+// in 4096 buckets (4 bits per channel), the result is the average colour of the fullest bucket.
+// Transparent pixels are ignored.
+func dominantColor(img image.Image) string {
+	nrgba := imaging.Clone(img)
+	var counts [4096]int
+	var sums [4096][3]int
+	for i := 0; i < len(nrgba.Pix); i += 4 {
+		r, g, b, a := int(nrgba.Pix[i]), int(nrgba.Pix[i+1]), int(nrgba.Pix[i+2]), nrgba.Pix[i+3]
+		if a < 128 {
+			continue
+		}
+		bucket := r>>4<<8 | g>>4<<4 | b>>4
+		counts[bucket]++
+		sums[bucket][0] += r
+		sums[bucket][1] += g
+		sums[bucket][2] += b
+	}
+
+	best := 0
+	for bucket, count := range counts {
+		if count > counts[best] {
+			best = bucket
+		}
+	}
+	n := counts[best]
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x", sums[best][0]/n, sums[best][1]/n, sums[best][2]/n)
+}
+
+// blurHash encodes the image as BlurHash (https://blurha.sh) with 4x3 components, 3x4 for portrait images:
+// the average colour and the weights of the first cosine waves in each direction, in 28 characters.
+func blurHash(img image.Image) string {
+	nrgba := imaging.Clone(img)
+	width, height := nrgba.Bounds().Dx(), nrgba.Bounds().Dy()
+	xComponents, yComponents := 4, 3
+	if height > width {
+		xComponents, yComponents = 3, 4
+	}
+
+	// Pixels in linear RGB, the waves are summed up without the gamma of sRGB
+	linear := make([][3]float64, width*height)
+	for y := range height {
+		for x := range width {
+			offset := nrgba.PixOffset(x, y)
+			for c := range 3 {
+				linear[y*width+x][c] = sRGBToLinear(nrgba.Pix[offset+c])
+			}
+		}
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := range yComponents {
+		for i := range xComponents {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var factor [3]float64
+			for y := range height {
+				for x := range width {
+					basis := normalisation *
+						math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(height))
+					for c := range 3 {
+						factor[c] += basis * linear[y*width+x][c]
+					}
+				}
+			}
+			for c := range 3 {
+				factor[c] /= float64(width * height)
+			}
+			factors = append(factors, factor)
+		}
+	}
+
+	var hash strings.Builder
+	encodeBase83(&hash, (xComponents-1)+(yComponents-1)*9, 1)
+
+	dc, ac := factors[0], factors[1:]
+	maxValue := 1.0
+	if len(ac) > 0 {
+		actualMax := 0.0
+		for _, factor := range ac {
+			for _, v := range factor {
+				actualMax = max(actualMax, math.Abs(v))
+			}
+		}
+		quantisedMax := int(max(0, min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantisedMax+1) / 166
+		encodeBase83(&hash, quantisedMax, 1)
+	} else {
+		encodeBase83(&hash, 0, 1)
+	}
+
+	encodeBase83(&hash, linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4)
+	for _, factor := range ac {
+		var quantised [3]int
+		for c, v := range factor {
+			quantised[c] = int(max(0, min(18, math.Floor(signPow(v/maxValue, 0.5)*9+9.5))))
+		}
+		encodeBase83(&hash, quantised[0]*19*19+quantised[1]*19+quantised[2], 2)
+	}
+	return hash.String()
+}
+
+// encodeBase83 appends value as length digits in the base 83 alphabet of BlurHash.
+func encodeBase83(b *strings.Builder, value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		digit := value / int(math.Pow(83, float64(i))) % 83
+		b.WriteByte(base83Chars[digit])
+	}
+}
+
+func sRGBToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := max(0, min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"embox/internal/models"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// Expected hashes were computed with a port of the reference encoder (https://github.com/woltapp/blurhash)
+func TestBlurHash(t *testing.T) {
+	landscape := image.NewNRGBA(image.Rect(0, 0, 8, 6))
+	for y := range 6 {
+		for x := range 8 {
+			landscape.Set(x, y, color.NRGBA{uint8(x * 32), uint8(y * 40), 128, 255})
+		}
+	}
+	if got, want := blurHash(landscape), "LjF=ad3Ba|xuzONLfQnTeqf7fQf7"; got != want {
+		t.Errorf("landscape: got %q, want %q", got, want)
+	}
+
+	// Portrait images get 3x4 components
+	portrait := image.NewNRGBA(image.Rect(0, 0, 5, 9))
+	for y := range 9 {
+		for x := range 5 {
+			portrait.Set(x, y, color.NRGBA{200, uint8(x * 50), uint8(y * 25), 255})
+		}
+	}
+	if got, want := blurHash(portrait), "TbM}RE_veE=lrqe;fSfRfQ=yrwe;"; got != want {
+		t.Errorf("portrait: got %q, want %q", got, want)
+	}
+
+	// Even a plain image has small AC components, the sums of the discrete cosines are not 0
+	if got, want := blurHash(imaging.New(40, 20, color.NRGBA{255, 0, 0, 255})), "LGTI:j,YfQ,Y|cjtfQjtfQfQfQfQ"; got != want {
+		t.Errorf("plain red: got %q, want %q", got, want)
+	}
+}
+
+func TestEncodeBase83(t *testing.T) {
+	for _, tc := range []struct {
+		value, length int
+		want          string
+	}{
+		{0, 1, "0"},
+		{82, 1, "~"},
+		{83, 2, "10"},
+		{0xff0000, 4, "TI:j"},
+	} {
+		var b strings.Builder
+		encodeBase83(&b, tc.value, tc.length)
+		if b.String() != tc.want {
+			t.Errorf("encodeBase83(%d, %d) = %q, want %q", tc.value, tc.length, b.String(), tc.want)
+		}
+	}
+}
+
+func TestDominantColor(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	for y := range 10 {
+		for x := range 10 {
+			switch {
+			case y < 3:
+				img.Set(x, y, color.NRGBA{0, 0, 255, 255})
+			case y < 9:
+				// Close shades fall into the same bucket and are averaged
+				img.Set(x, y, color.NRGBA{uint8(200 + x%2*4), 100, 50, 255})
+			default:
+				// Transparent pixels are ignored
+				img.Set(x, y, color.NRGBA{0, 255, 0, 0})
+			}
+		}
+	}
+	if got := dominantColor(img); got != "#ca6432" {
+		t.Errorf("dominantColor = %q, want #ca6432", got)
+	}
+
+	if got := dominantColor(image.NewNRGBA(image.Rect(0, 0, 4, 4))); got != "" {
+		t.Errorf("dominantColor of a transparent image = %q, want empty", got)
+	}
+}
+
+func TestMediaLayout(t *testing.T) {
+	layout := mediaLayout(&models.Media{Width: 4032, Height: 3024, DominantColor: "#a0b1c2", BlurHash: "L00000fQfQfQfQfQfQfQfQfQfQfQ"})
+	if layout.Width != 4032 || layout.Height != 3024 || layout.AspectRatio != 1.3333 || layout.DominantColor != "#a0b1c2" {
+		t.Errorf("unexpected layout %+v", layout)
+	}
+	if layout := mediaLayout(&models.Media{}); layout.AspectRatio != 0 {
+		t.Errorf("expected no aspect ratio without dimensions, got %+v", layout)
+	}
+}
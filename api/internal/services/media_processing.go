@@ -0,0 +1,176 @@
+package services
+
+import (
+	"embox/internal/models"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Processing states of an upload, stored in Media.ProcessingStatus
+const (
+	ProcessingPending = "pending"
+	ProcessingDone    = "done"
+	ProcessingFailed  = "failed"
+)
+
+// JobProcessMedia reads the metadata of an upload, stores the original and creates the thumbnails.
+const JobProcessMedia = "process_media"
+
+// Subdirectory of the upload staging dir keeping the originals until they are processed
+const processingDir = "processing"
+
+type processMediaArgs struct {
+	DateFromFile bool `json:"dateFromFile"` // no date was sent, use the capture date of the file
+}
+
+// stagedPath returns the path the original of an upload is kept at until it is processed.
+func (s *MediaService) stagedPath(id uint) string {
+	return filepath.Join(s.config.StagingDir, processingDir, strconv.FormatUint(uint64(id), 10))
+}
+
+// enqueueProcessing moves the spooled upload to the staging dir and schedules its processing.
+func (s *MediaService) enqueueProcessing(media *models.Media, file *os.File, dateFromFile bool) error {
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := os.Rename(file.Name(), s.stagedPath(media.ID)); err != nil {
+		return fmt.Errorf("failed to stage upload: %w", err)
+	}
+
+	payload, err := json.Marshal(processMediaArgs{DateFromFile: dateFromFile})
+	if err != nil {
+		return err
+	}
+	job := &models.Job{
+		Type:    JobProcessMedia,
+		MediaID: media.ID,
+		UserID:  media.UserID,
+		Payload: string(payload),
+	}
+	err = s.jobs.Enqueue(job)
+	media.JobID = job.ID
+	return err
+}
+
+// discardUpload removes a new media item whose processing could not be scheduled or failed right away,
+// along with its staged original and the files the processing may have stored before it failed.
+func (s *MediaService) discardUpload(media *models.Media) {
+	// The processing may have changed the date and so the paths
+	if stored, err := s.mediaRepo.GetByIdWithTrashed(media.ID); err == nil && stored != nil {
+		media = stored
+	}
+	os.Remove(s.stagedPath(media.ID))
+	localFiles, _ := localMediaFiles(media)
+	for _, localFilePath := range localFiles {
+		os.RemoveAll(localFilePath)
+	}
+	// Fails if the original was not stored yet
+	s.storage.Delete(media.RemotePath())
+	if err := s.mediaRepo.Purge([]uint{media.ID}); err != nil {
+		slog.Error("failed to remove media of failed upload", "media", media.ID, "err", err)
+	}
+}
+
+// RetryProcessing processes an upload whose processing failed again from its staged original,
+// with the arguments of the failed job. Without job workers, as in the command line tools, it runs right away.
+func (s *MediaService) RetryProcessing(media *models.Media) error {
+	if _, err := os.Stat(s.stagedPath(media.ID)); err != nil {
+		return fmt.Errorf("staged upload not found: %w", err)
+	}
+	_, err := s.jobs.Retry(media.ID, JobProcessMedia)
+	return err
+}
+
+// processMedia runs a JobProcessMedia job. Once the last attempt failed, the media is marked as failed
+// and the staged original is kept, as it is the only copy. The backfill processes it again, see RetryProcessing.
+func (s *MediaService) processMedia(job *models.Job, progress func(percent int)) error {
+	media, err := s.mediaRepo.GetByIdWithTrashed(job.MediaID)
+	if err != nil {
+		return fmt.Errorf("failed to find media with id %d: %w", job.MediaID, err)
+	}
+	if media == nil {
+		// Purged before it was processed
+		os.Remove(s.stagedPath(job.MediaID))
+		return nil
+	}
+
+	err = s.process(media, job, progress)
+	if err != nil && isLastAttempt(job) {
+		media.ProcessingStatus = ProcessingFailed
+		if statusErr := s.mediaRepo.SetProcessed(media); statusErr != nil {
+			slog.Error("failed to save processing status", "media", media.ID, "err", statusErr)
+		}
+	}
+	return err
+}
+
+func (s *MediaService) process(media *models.Media, job *models.Job, progress func(percent int)) error {
+	var args processMediaArgs
+	if err := json.Unmarshal([]byte(job.Payload), &args); err != nil {
+		return fmt.Errorf("invalid job payload: %w", err)
+	}
+
+	file, err := os.Open(s.stagedPath(media.ID))
+	if err != nil {
+		return fmt.Errorf("failed to open staged upload: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// The date decides the storage path, so it is set before the original is stored
+	metadata, captured := readMetadata(media.Type, file)
+	if args.DateFromFile && captured != nil {
+		media.Date = *captured
+	}
+	if metadata != nil {
+		media.Width, media.Height = metadata.Width, metadata.Height
+		media.Place, media.Country = s.lookupPlace(metadata.Latitude, metadata.Longitude)
+		metadata.MediaID = media.ID
+		if err := s.mediaRepo.SaveMetadata(metadata); err != nil {
+			slog.Warn("failed to save media metadata", "media", media.ID, "err", err)
+		}
+	}
+	if err := s.mediaRepo.SetProcessed(media); err != nil {
+		return fmt.Errorf("failed to save media: %w", err)
+	}
+	progress(10)
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind staged upload: %w", err)
+	}
+	if err := s.storage.Upload(file, info.Size(), media.RemotePath()); err != nil {
+		return fmt.Errorf("failed to upload original file: %w", err)
+	}
+	progress(50)
+
+	if err := s.createThumbnail(media, file); err != nil {
+		return err
+	}
+	progress(90)
+
+	if s.CanTranscode(media) {
+		if err := s.mediaRepo.SetTranscodeStatus(media.ID, TranscodePending); err != nil {
+			return fmt.Errorf("failed to save transcode status: %w", err)
+		}
+		media.TranscodeStatus = TranscodePending
+		s.transcoder.Enqueue(media.ID)
+	}
+
+	media.ProcessingStatus = ProcessingDone
+	if err := s.mediaRepo.SetProcessed(media); err != nil {
+		return fmt.Errorf("failed to save media: %w", err)
+	}
+	file.Close()
+	if err := os.Remove(s.stagedPath(media.ID)); err != nil {
+		slog.Warn("failed to remove staged upload", "media", media.ID, "err", err)
+	}
+	return nil
+}
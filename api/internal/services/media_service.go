@@ -2,10 +2,16 @@ package services
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/sha256"
 	"embox/internal/api/dto"
+	"embox/internal/config"
 	"embox/internal/models"
 	"embox/internal/repositories"
+	"embox/pkg/geocoder"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"image"
 	"io"
@@ -16,7 +22,10 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/chai2010/webp"
@@ -25,84 +34,166 @@ import (
 )
 
 type MediaService struct {
-	storage   Storage
-	mediaRepo repositories.MediaRepository
-	userRepo  repositories.UserRepository
+	config      *config.UploadConfig
+	mediaConfig *config.MediaConfig
+	storage     Storage
+	mediaRepo   repositories.MediaRepository
+	userRepo    repositories.UserRepository
+	transcoder  *TranscodeService
+	jobs        *JobService
+	geocoder    *geocoder.Geocoder // nil if no GeoNames dataset is configured
+
+	variantFailures sync.Map // path → variantFailure of thumbnail format conversions that failed
+
+	// Uploads with the same content hash are looked up and created one at a time,
+	// so concurrent uploads of the same file are detected as duplicates
+	hashLocksMu sync.Mutex
+	hashLocks   map[string]*hashLock
+}
+
+// hashLock is the lock of one content hash, removed when no upload holds or waits for it.
+type hashLock struct {
+	sync.Mutex
+	refs int
+}
+
+// ErrNotTranscoded is returned for the web versions of a video whose transcoding is pending or failed.
+var ErrNotTranscoded = errors.New("video is not transcoded")
+
+// Errors of CreateFromRequest caused by the upload itself
+var (
+	ErrInvalidDate  = errors.New("invalid date format")
+	ErrTypeMismatch = errors.New("file type mismatch")
+)
+
+// UploadResult is the outcome of one file of UploadMedia: the created media or the error.
+type UploadResult struct {
+	Media *dto.MediaResponseDto
+	Err   error
+}
+
+// DuplicateError is returned by CreateFromRequest if the upload has the same content as existing media
+// and the duplicate policy is "reject".
+type DuplicateError struct {
+	ExistingID uint
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("duplicate of media %d", e.ExistingID)
 }
 
 var MediaDir = "./media"
-var imgMaxSize = 512
+
+// hlsFilePattern matches the variant playlists and segments below Media.HlsDir(), e.g. 720p.m3u8 and 720p_003.ts
+var hlsFilePattern = regexp.MustCompile(`^\d+p(\.m3u8|_\d+\.ts)$`)
+var imgMaxSize = 512 // width of the default thumbnail at Media.Path()
 var imgQuality float32 = 80
 
-func NewMediaService(storage Storage, mediaRepo repositories.MediaRepository, userRepo repositories.UserRepository) *MediaService {
+func NewMediaService(cfg *config.UploadConfig, mediaCfg *config.MediaConfig, storage Storage, mediaRepo repositories.MediaRepository, userRepo repositories.UserRepository, jobs *JobService) *MediaService {
 	if _, err := exec.LookPath("ffmpeg"); err != nil {
 		log.Fatal("ffmpeg not found in PATH")
 	}
-	return &MediaService{storage, mediaRepo, userRepo}
+	s := &MediaService{
+		config:      cfg,
+		mediaConfig: mediaCfg,
+		storage:     storage,
+		mediaRepo:   mediaRepo,
+		userRepo:    userRepo,
+		transcoder:  NewTranscodeService(mediaCfg, storage, mediaRepo),
+		jobs:        jobs,
+		geocoder:    loadGeocoder(mediaCfg.GeoNamesFile),
+	}
+	jobs.Handle(JobProcessMedia, s.processMedia)
+	return s
 }
 
 // === public functions ===
 
-// GetMediaList retrieves media items based on order and user permissions.
-func (s *MediaService) GetMediaList(userEmail string) ([]dto.MediaResponseDto, error) {
+// GetMediaList retrieves media items based on order and user permissions, optionally only those of a place or country.
+func (s *MediaService) GetMediaList(userEmail string, filter dto.MediaListFilterDto) ([]dto.MediaResponseDto, error) {
 	user, err := s.userRepo.GetByEmail(userEmail)
 	if err != nil || user == nil {
 		return nil, fmt.Errorf("user not found")
 	}
 
-	mediaList, err := s.mediaRepo.Get(user.ID)
+	mediaList, err := s.mediaRepo.Get(user.ID, repositories.MediaFilter{Place: filter.Place, Country: filter.Country})
 	if err != nil {
 		return nil, err
 	}
 
 	var results []dto.MediaResponseDto
 	for _, media := range mediaList {
-		results = append(results, dto.MediaResponseDto{
-			Id:          media.ID,
-			IsFavourite: media.IsFavourite,
-			Caption:     media.Caption,
-			Date:        media.Date.Format(time.RFC3339),
-			Type:        media.Type,
-			CreatedAt:   media.CreatedAt,
-		})
+		results = append(results, mediaResponse(&media.Media, media.IsFavourite))
 	}
 
 	return results, nil
 }
 
-func (s *MediaService) GetMediaByID(id uint, userEmail string) (*models.Media, error) {
-	user, err := s.userRepo.GetByEmail(userEmail)
-	if err != nil || user == nil {
-		return nil, fmt.Errorf("user not found")
-	}
-
-	media, err := s.mediaRepo.GetById(id)
+// GetThumbnail returns the local path of the thumbnail rendition whose width is nearest to size,
+// in the given format (ThumbnailFormatWebP, ThumbnailFormatAVIF or ThumbnailFormatJPEG).
+// Without size (0) the default thumbnail is returned. If the nearest rendition has not been generated yet,
+// the nearest existing one is used instead and exact is false. If the conversion to the format fails,
+// the WebP rendition is returned.
+// Thumbnails of media in the trash are available as well, so the trash can be shown.
+func (s *MediaService) GetThumbnail(id uint, size int, format string) (string, bool, error) {
+	media, err := s.mediaRepo.GetByIdWithTrashed(id)
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve media: %w", err)
+		return "", false, fmt.Errorf("failed to find media with id %d: %w", id, err)
 	}
 	if media == nil {
-		return nil, nil // Media not found
+		return "", false, fmt.Errorf("media with id %d not found", id)
 	}
 
-	return media, nil
-}
-
-// GetThumbnail returns the local file path and its MIME type by media ID.
-func (s *MediaService) GetThumbnail(id uint) (string, string, error) {
-	media, err := s.mediaRepo.GetById(id)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to find media with id %d: %w", id, err)
+	if size == 0 {
+		size = imgMaxSize
 	}
-	if media == nil {
-		return "", "", fmt.Errorf("media with id %d not found", id)
+	sizes := s.ThumbnailSizes()
+	slices.SortStableFunc(sizes, func(a, b int) int {
+		// nearest first, the larger one on a tie
+		if d := cmp.Compare(absDiff(a, size), absDiff(b, size)); d != 0 {
+			return d
+		}
+		return cmp.Compare(b, a)
+	})
+
+	for i, candidate := range sizes {
+		filePath, _, err := s.getMediaLocalPath(renditionPath(media, candidate))
+		if err != nil {
+			continue
+		}
+		if variantPath, err := s.thumbnailVariant(filePath, format); err == nil {
+			filePath = variantPath
+		} else {
+			slog.Warn("serving webp thumbnail instead", "media", id, "format", format, "err", err)
+		}
+		return filePath, i == 0, nil
 	}
 
-	filePath, mimeType, err := s.getMediaLocalPath(media.Path())
-	if err != nil {
-		return "", "", fmt.Errorf("failed to get media thumbnail path: %w", err)
+	return "", false, fmt.Errorf("failed to get media thumbnail path: no rendition of media %d found", id)
+}
+
+// ThumbnailSizes returns the widths of all thumbnail renditions in ascending order,
+// the configured ones and the default thumbnail.
+func (s *MediaService) ThumbnailSizes() []int {
+	sizes := append([]int{imgMaxSize}, s.mediaConfig.ThumbnailSizes...)
+	slices.Sort(sizes)
+	return slices.Compact(sizes)
+}
+
+// MissingThumbnailSizes returns the widths of the renditions that do not exist on disk.
+// Media without thumbnails (audio, other) never miss any.
+func (s *MediaService) MissingThumbnailSizes(media *models.Media) []int {
+	if media.Type != "image" && media.Type != "video" {
+		return nil
 	}
 
-	return filePath, mimeType, nil
+	var missing []int
+	for _, size := range s.ThumbnailSizes() {
+		if _, err := os.Stat(filepath.Join(MediaDir, renditionPath(media, size))); err != nil {
+			missing = append(missing, size)
+		}
+	}
+	return missing
 }
 
 // GetMediaFile retrieves the full media file as a response (containing the body stream) and its metadata.
@@ -123,37 +214,103 @@ func (s *MediaService) GetMediaFile(id uint, headers http.Header) (*http.Respons
 	return resp, media, nil
 }
 
+// GetStreamFile returns the local path of a file of the HLS stream of a video:
+// the master playlist for an empty name, otherwise a variant playlist or segment.
+// It returns ErrNotTranscoded while the video has not been transcoded.
+func (s *MediaService) GetStreamFile(id uint, name string) (string, error) {
+	media, err := s.getTranscodedVideo(id)
+	if err != nil {
+		return "", err
+	}
+
+	if name == "" {
+		name = hlsMasterPlaylist
+	} else if !hlsFilePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid stream file %q", name)
+	}
+
+	filePath := filepath.Join(MediaDir, media.HlsDir(), name)
+	if _, err := os.Stat(filePath); err != nil {
+		return "", err
+	}
+	return filePath, nil
+}
+
+// GetWebVideo returns the local path of the H.264/AAC MP4 of a video.
+// It returns ErrNotTranscoded while the video has not been transcoded.
+func (s *MediaService) GetWebVideo(id uint) (string, error) {
+	media, err := s.getTranscodedVideo(id)
+	if err != nil {
+		return "", err
+	}
+
+	filePath := filepath.Join(MediaDir, media.WebVideoPath())
+	if _, err := os.Stat(filePath); err != nil {
+		return "", err
+	}
+	return filePath, nil
+}
+
+// TranscodeVideo creates the web versions of a video synchronously.
+func (s *MediaService) TranscodeVideo(media *models.Media) error {
+	return s.transcoder.Transcode(media)
+}
+
+// ResumeTranscoding continues the transcoding of videos that were pending when the server stopped.
+func (s *MediaService) ResumeTranscoding() {
+	if s.mediaConfig.Transcode {
+		s.transcoder.ResumePending()
+	}
+}
+
+// CanTranscode reports whether the web versions of the media are missing and transcoding is enabled.
+// Videos whose transcoding failed are tried again until maxTranscodeTries were made.
+func (s *MediaService) CanTranscode(media *models.Media) bool {
+	if !s.mediaConfig.Transcode || media.Type != "video" {
+		return false
+	}
+	return media.TranscodeStatus == "" ||
+		(media.TranscodeStatus == TranscodeFailed && media.TranscodeTries < maxTranscodeTries)
+}
+
+func (s *MediaService) getTranscodedVideo(id uint) (*models.Media, error) {
+	media, err := s.mediaRepo.GetById(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find media with id %d: %w", id, err)
+	}
+	if media == nil {
+		return nil, fmt.Errorf("media with id %d not found", id)
+	}
+	if media.TranscodeStatus != TranscodeDone {
+		return nil, ErrNotTranscoded
+	}
+	return media, nil
+}
+
 // Creates a new media entry from the provided metadata and file data.
+// If media with the same content exists, the duplicate policy decides: the upload fails with a
+// *DuplicateError ("reject"), the existing media is returned ("existing") or a copy is stored ("allow").
+// In the latter two cases DuplicateOf of the returned media is set to the ID of the existing media.
 func (s *MediaService) CreateFromRequest(meta dto.MediaUploadRequestDto, file io.Reader, userEmail string) (*models.Media, error) {
 	user, err := s.userRepo.GetByEmail(userEmail)
 	if err != nil || user == nil {
 		return nil, fmt.Errorf("user not found")
 	}
 
-	// Parse the date string
+	// Parse the date string, if omitted the capture date is read from the file below
 	// First try RFC3339 ISO (with timezone, e.g. from EXIF or toISOString())
 	// Then try ISO without timezone (common from ion-datetime)
-	parsedDate, err := time.Parse(time.RFC3339, meta.Date)
-	if err != nil {
-		parsedDate, err = time.Parse("2006-01-02T15:04:05", meta.Date)
+	var parsedDate time.Time
+	if meta.Date != "" {
+		parsedDate, err = time.Parse(time.RFC3339, meta.Date)
 		if err != nil {
-			return nil, fmt.Errorf("invalid date format: %w", err)
+			parsedDate, err = time.Parse("2006-01-02T15:04:05", meta.Date)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidDate, err)
+			}
 		}
 	}
 
-	media := &models.Media{
-		UserID:    &user.ID,
-		Caption:   meta.Caption,
-		Type:      getMediaType(meta.Type),
-		FileExt:   getFileExt(meta.FileName), // Original-Endung behalten
-		Date:      parsedDate,
-		CreatedAt: time.Now(),
-	}
-
-	if err := s.mediaRepo.Create(media); err != nil {
-		return nil, err
-	}
-
 	// Detect MIME type from file content and cross-check against the declared type.
 	// If Go cannot identify the type (application/octet-stream), we skip the check.
 	sniff := make([]byte, 512)
@@ -164,43 +321,170 @@ func (s *MediaService) CreateFromRequest(meta dto.MediaUploadRequestDto, file io
 	sniff = sniff[:n]
 	detectedMime := http.DetectContentType(sniff)
 	if detectedMime != "application/octet-stream" && getMediaType(detectedMime) != getMediaType(meta.Type) {
-		return nil, fmt.Errorf("file type mismatch: declared %q but detected %q", meta.Type, detectedMime)
+		return nil, fmt.Errorf("%w: declared %q but detected %q", ErrTypeMismatch, meta.Type, detectedMime)
 	}
 
-	bytes, err := io.ReadAll(io.MultiReader(bytes.NewReader(sniff), file))
+	// Spool the upload into the staging dir, where it is kept until the original is stored
+	// and thumbnails/posters are generated in the background. The content hash is computed on the way.
+	stagingDir := filepath.Join(s.config.StagingDir, processingDir)
+	if err := os.MkdirAll(stagingDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create processing dir: %w", err)
+	}
+	hash := sha256.New()
+	tmpFile, _, err := spoolToDir(stagingDir, io.TeeReader(io.MultiReader(bytes.NewReader(sniff), file), hash))
 	if err != nil {
 		return nil, err
 	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
 
-	if err := s.storage.Upload(bytes, media.RemotePath()); err != nil {
-		return nil, fmt.Errorf("failed to upload original file: %w", err)
+	mediaType := getMediaType(meta.Type)
+	if mediaType == "other" && isImageFileExt(getFileExt(meta.FileName)) {
+		// e.g. HEIC or RAW files without MIME type
+		mediaType = "image"
+	}
+	// Without date the capture date is read from the file when it is processed
+	dateFromFile := parsedDate.IsZero()
+	if dateFromFile {
+		parsedDate = time.Now()
 	}
 
-	if media.Type == "image" || media.Type == "video" {
-		if media.Type == "image" {
-			bytes, err = s.convertToWebP(bytes)
-			if err != nil {
-				return nil, err
-			}
-		}
+	media := &models.Media{
+		UserID:           &user.ID,
+		Caption:          meta.Caption,
+		Type:             mediaType,
+		FileExt:          getFileExt(meta.FileName), // Original-Endung behalten
+		Date:             parsedDate,
+		ContentHash:      hex.EncodeToString(hash.Sum(nil)),
+		ProcessingStatus: ProcessingPending,
+		CreatedAt:        time.Now(),
+	}
 
-		if media.Type == "video" {
-			bytes, err = s.generateVideoPoster(media, bytes)
-			if err != nil {
-				return nil, err
+	existing, created, err := s.createUnlessDuplicate(media)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		existing.DuplicateOf = existing.ID
+		return existing, nil
+	}
+	if err := s.enqueueProcessing(media, tmpFile, dateFromFile); err != nil {
+		// The request fails, so the media item must not remain in the gallery or count as a duplicate
+		s.discardUpload(media)
+		return nil, err
+	}
+
+	// Without job workers the media was processed right away
+	if processed, err := s.mediaRepo.GetById(media.ID); err == nil && processed != nil {
+		processed.JobID = media.JobID
+		media = processed
+	}
+	if existing != nil {
+		media.DuplicateOf = existing.ID
+	}
+
+	return media, nil
+}
+
+// createUnlessDuplicate creates the media item, unless the duplicate policy returns an existing media item
+// with the same content hash instead. The lookup and the creation are done under the lock of the hash,
+// so concurrent uploads of the same content see each other. It returns the existing media item, if any,
+// and whether the new one was created.
+func (s *MediaService) createUnlessDuplicate(media *models.Media) (*models.Media, bool, error) {
+	defer s.lockContentHash(media.ContentHash)()
+
+	// Uploads whose processing failed don't count, their originals were never stored
+	existing, err := s.mediaRepo.GetByContentHash(media.ContentHash, []string{ProcessingPending, ProcessingDone})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to look up duplicates: %w", err)
+	}
+	if existing != nil {
+		switch s.config.DuplicatePolicy {
+		case "reject":
+			return nil, false, &DuplicateError{ExistingID: existing.ID}
+		case "existing":
+			// Media still being processed may fail yet, the upload is stored as a copy instead
+			if existing.ProcessingStatus == ProcessingDone {
+				return existing, false, nil
 			}
 		}
+	}
 
-		if err := s.saveMediaFile(media, bytes); err != nil {
-			return nil, err
+	if err := s.mediaRepo.Create(media); err != nil {
+		return nil, false, err
+	}
+	return existing, true, nil
+}
+
+// lockContentHash locks the content hash until the returned function is called.
+func (s *MediaService) lockContentHash(hash string) func() {
+	s.hashLocksMu.Lock()
+	if s.hashLocks == nil {
+		s.hashLocks = make(map[string]*hashLock)
+	}
+	lock, ok := s.hashLocks[hash]
+	if !ok {
+		lock = &hashLock{}
+		s.hashLocks[hash] = lock
+	}
+	lock.refs++
+	s.hashLocksMu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		s.hashLocksMu.Lock()
+		defer s.hashLocksMu.Unlock()
+		if lock.refs--; lock.refs == 0 {
+			delete(s.hashLocks, hash)
 		}
 	}
+}
+
+// MissingContentHash reports whether the content hash of a stored original is missing,
+// e.g. because it was uploaded before duplicates were detected.
+func (s *MediaService) MissingContentHash(media *models.Media) bool {
+	return media.ContentHash == "" && media.ProcessingStatus != ProcessingFailed
+}
+
+// UpdateContentHash computes the SHA-256 content hash of the original file, so later uploads of it are detected as duplicates.
+func (s *MediaService) UpdateContentHash(media *models.Media) error {
+	resp, err := s.storage.DownloadStream(media.RemotePath(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to download original file: %w", err)
+	}
+	defer resp.Body.Close()
 
-	return media, nil
+	hash := sha256.New()
+	if _, err := io.Copy(hash, resp.Body); err != nil {
+		return fmt.Errorf("failed to read original file: %w", err)
+	}
+	media.ContentHash = hex.EncodeToString(hash.Sum(nil))
+	return s.mediaRepo.SetContentHash(media.ID, media.ContentHash)
 }
 
-// UploadMedia handles the upload of multiple media files with their metadata.
-func (s *MediaService) UploadMedia(files []*multipart.FileHeader, metaList []dto.MediaUploadRequestDto, userEmail string) ([]dto.MediaResponseDto, error) {
+// RegenerateThumbnail downloads the original file and recreates all local thumbnail renditions.
+func (s *MediaService) RegenerateThumbnail(media *models.Media) error {
+	resp, err := s.storage.DownloadStream(media.RemotePath(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to download original file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	tmpFile, _, err := spoolToTempFile(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	return s.createThumbnail(media, tmpFile)
+}
+
+// UploadMedia stores multiple media files with their metadata, up to UploadConfig.Concurrency at a time.
+// A failing file does not affect the others: the results are in the order of the files, each with the
+// created media or the error. The error is only set if the request as a whole is invalid.
+func (s *MediaService) UploadMedia(files []*multipart.FileHeader, metaList []dto.MediaUploadRequestDto, userEmail string) ([]UploadResult, error) {
 	user, err := s.userRepo.GetByEmail(userEmail)
 	if err != nil || user == nil {
 		return nil, fmt.Errorf("user not found")
@@ -210,33 +494,63 @@ func (s *MediaService) UploadMedia(files []*multipart.FileHeader, metaList []dto
 		return nil, fmt.Errorf("meta and files count mismatch")
 	}
 
-	var uploaded []dto.MediaResponseDto
+	results := make([]UploadResult, len(files))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range min(max(s.config.Concurrency, 1), len(files)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				media, err := s.uploadFile(files[i], metaList[i], userEmail)
+				if err != nil {
+					slog.Warn("failed to save uploaded media", "file", files[i].Filename, "err", err)
+				}
+				results[i] = UploadResult{Media: media, Err: err}
+			}
+		}()
+	}
+	for i := range files {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
 
-	for i, fileHeader := range files {
-		meta := metaList[i]
+	return results, nil
+}
 
-		file, err := fileHeader.Open()
-		if err != nil {
-			return nil, fmt.Errorf("failed to open file: %w", err)
-		}
-		defer file.Close()
+// uploadFile stores one file of UploadMedia.
+func (s *MediaService) uploadFile(fileHeader *multipart.FileHeader, meta dto.MediaUploadRequestDto, userEmail string) (*dto.MediaResponseDto, error) {
+	if fileHeader.Size > MaxFileSize {
+		return nil, ErrUploadTooLarge
+	}
 
-		media, err := s.CreateFromRequest(meta, file, userEmail)
-		if err != nil {
-			return nil, fmt.Errorf("failed to save media: %w", err)
-		}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
 
-		uploaded = append(uploaded, dto.MediaResponseDto{
-			Id:          media.ID,
-			IsFavourite: false,
-			Caption:     media.Caption,
-			Date:        media.Date.Format(time.RFC3339),
-			Type:        media.Type,
-			CreatedAt:   media.CreatedAt,
-		})
+	media, err := s.CreateFromRequest(meta, file, userEmail)
+	if err != nil {
+		return nil, err
 	}
 
-	return uploaded, nil
+	return &dto.MediaResponseDto{
+		Id:          media.ID,
+		IsFavourite: false,
+		Caption:     media.Caption,
+		Date:        media.Date.Format(time.RFC3339),
+		Type:        media.Type,
+		CreatedAt:   media.CreatedAt,
+		DuplicateOf: media.DuplicateOf,
+		Place:       media.Place,
+		Country:     media.Country,
+
+		ProcessingStatus: media.ProcessingStatus,
+		JobId:            media.JobID,
+		MediaLayoutDto:   mediaLayout(media),
+	}, nil
 }
 
 // UpdateMediaBatch updates multiple media items based on the provided update requests.
@@ -260,6 +574,19 @@ func (s *MediaService) UpdateMediaBatch(updates []dto.MediaUpdateRequestDto, use
 			continue
 		}
 
+		locationChanged := update.RemoveLocation || update.Latitude != nil || update.Longitude != nil
+		if existingMedia.ProcessingStatus == ProcessingPending && (update.Date != nil || locationChanged) {
+			// The processing sets the date and location read from the file
+			updateErrors = append(updateErrors, fmt.Sprintf("media ID %d is still being processed, its date and location cannot be changed yet", update.ID))
+			continue
+		}
+		if existingMedia.TranscodeStatus == TranscodePending && update.Date != nil {
+			// The date decides the paths the web versions are written to
+			updateErrors = append(updateErrors, fmt.Sprintf("media ID %d is still being transcoded, its date cannot be changed yet", update.ID))
+			continue
+		}
+
+		previous := *existingMedia
 		existingMedia.UpdatedByID = &user.ID
 
 		if update.Caption != nil {
@@ -278,19 +605,40 @@ func (s *MediaService) UpdateMediaBatch(updates []dto.MediaUpdateRequestDto, use
 			existingMedia.Date = parsedDate
 		}
 
-		if err := s.mediaRepo.Update(existingMedia); err != nil {
+		if locationChanged && !update.RemoveLocation && !validLocation(update.Latitude, update.Longitude) {
+			updateErrors = append(updateErrors, fmt.Sprintf("invalid location for media ID %d: latitude (-90..90) and longitude (-180..180) are required", update.ID))
+			continue
+		}
+		if update.RemoveLocation {
+			update.Latitude, update.Longitude = nil, nil
+		}
+		var metadata *models.MediaMetadata
+		if locationChanged {
+			existingMedia.Place, existingMedia.Country = s.lookupPlace(update.Latitude, update.Longitude)
+			if metadata, err = s.locatedMetadata(existingMedia.ID, update.Latitude, update.Longitude); err != nil {
+				updateErrors = append(updateErrors, fmt.Sprintf("failed to retrieve metadata of media ID %d: %v", update.ID, err))
+				continue
+			}
+		}
+
+		// A date change also changes the file paths, so the files are moved along with the DB update
+		if existingMedia.BasePath() != previous.BasePath() {
+			err = s.mediaRepo.UpdateWith(existingMedia, metadata, func() error {
+				return s.moveMediaFiles(&previous, existingMedia)
+			}, func() {
+				if err := s.moveMediaFiles(existingMedia, &previous); err != nil {
+					slog.Error("failed to move files back", "media", existingMedia.ID, "err", err)
+				}
+			})
+		} else {
+			err = s.mediaRepo.Update(existingMedia, metadata)
+		}
+		if err != nil {
 			updateErrors = append(updateErrors, fmt.Sprintf("failed to update media ID %d: %v", update.ID, err))
 			continue
 		}
 
-		updatedMedia = append(updatedMedia, dto.MediaResponseDto{
-			Id:          existingMedia.ID,
-			IsFavourite: existingMedia.IsFavourite,
-			Caption:     existingMedia.Caption,
-			Date:        existingMedia.Date.Format(time.RFC3339),
-			Type:        existingMedia.Type,
-			CreatedAt:   existingMedia.CreatedAt,
-		})
+		updatedMedia = append(updatedMedia, mediaResponse(existingMedia, existingMedia.IsFavourite))
 	}
 
 	if len(updateErrors) > 0 {
@@ -320,27 +668,57 @@ func (s *MediaService) IsOwnerOfAll(userEmail string, ids []uint) (bool, error)
 	return true, nil
 }
 
-// DeleteMedia deletes media items by their IDs, removing both local and remote files.
+// DeleteMedia moves media items to the trash. Their files are kept until they are purged.
 func (s *MediaService) DeleteMedia(ids []uint) error {
-	mediaList, err := s.mediaRepo.GetByIDs(ids)
-	if err != nil {
-		return err
-	}
+	return s.mediaRepo.Delete(ids)
+}
 
+// PurgeMedia permanently deletes media items, removing both local and remote files.
+func (s *MediaService) PurgeMedia(mediaList []*models.Media) error {
+	var ids []uint
 	for _, media := range mediaList {
-		localFilePath := filepath.Join(MediaDir, media.Path())
-		_ = os.Remove(localFilePath)
+		localFiles, _ := localMediaFiles(media)
+		for _, localFilePath := range localFiles {
+			_ = os.RemoveAll(localFilePath)
+		}
 
 		if err := s.storage.Delete(media.RemotePath()); err != nil {
 			slog.Error("failed to delete remote file", "path", media.RemotePath(), "err", err)
 		}
+		ids = append(ids, media.ID)
 	}
 
-	return s.mediaRepo.Delete(ids)
+	if len(ids) == 0 {
+		return nil
+	}
+	return s.mediaRepo.Purge(ids)
 }
 
 // === private functions ===
 
+// mediaResponse returns the media as listed in the gallery.
+func mediaResponse(media *models.Media, isFavourite bool) dto.MediaResponseDto {
+	return dto.MediaResponseDto{
+		Id:          media.ID,
+		IsFavourite: isFavourite,
+		Caption:     media.Caption,
+		Date:        media.Date.Format(time.RFC3339),
+		Type:        media.Type,
+		CreatedAt:   media.CreatedAt,
+		IsBroken:    media.IsBroken,
+
+		ProcessingStatus: media.ProcessingStatus,
+		TranscodeStatus:  media.TranscodeStatus,
+		Duration:         media.Duration,
+		Bitrate:          media.Bitrate,
+		Title:            media.AudioTitle,
+		Artist:           media.AudioArtist,
+		Place:            media.Place,
+		Country:          media.Country,
+		MediaLayoutDto:   mediaLayout(media),
+	}
+}
+
 // getMediaLocalPath returns the absolute local path and its MIME type.
 func (s *MediaService) getMediaLocalPath(path string) (string, string, error) {
 	filePath := filepath.Join(MediaDir, path)
@@ -354,6 +732,131 @@ func (s *MediaService) getMediaLocalPath(path string) (string, string, error) {
 	return filePath, "image/webp", nil // Defaulting to webp as thumbnails are transformed
 }
 
+// moveMediaFiles moves the original and all local files (thumbnails etc.) of a media item
+// from the paths of from to the paths of to. On failure, already moved files are moved back.
+func (s *MediaService) moveMediaFiles(from, to *models.Media) error {
+	if err := s.storage.Move(from.RemotePath(), to.RemotePath()); err != nil {
+		return fmt.Errorf("failed to move original file: %w", err)
+	}
+
+	localFiles, err := localMediaFiles(from)
+	if err != nil {
+		return err
+	}
+
+	var moved [][2]string
+	for _, src := range localFiles {
+		dst := filepath.Join(MediaDir, to.BasePath()+strings.TrimPrefix(src, filepath.Join(MediaDir, from.BasePath())))
+		err := os.MkdirAll(filepath.Dir(dst), 0755)
+		if err == nil {
+			err = os.Rename(src, dst)
+		}
+		if err != nil {
+			for _, m := range moved {
+				_ = os.Rename(m[1], m[0])
+			}
+			if rollbackErr := s.storage.Move(to.RemotePath(), from.RemotePath()); rollbackErr != nil {
+				slog.Error("failed to move original file back", "path", to.RemotePath(), "err", rollbackErr)
+			}
+			return fmt.Errorf("failed to move local file %s: %w", src, err)
+		}
+		moved = append(moved, [2]string{src, dst})
+	}
+
+	return nil
+}
+
+// localMediaFiles returns all files below MediaDir that belong to the media item,
+// i.e. yyyy/mm/dd_Id.* and yyyy/mm/dd_Id_* (e.g. thumbnail renditions).
+func localMediaFiles(media *models.Media) ([]string, error) {
+	base := filepath.Join(MediaDir, media.BasePath())
+	var files []string
+	for _, pattern := range []string{base + ".*", base + "_*"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list local files: %w", err)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// createThumbnail generates the local thumbnail renditions from the original file,
+// for images from the image itself, for videos from a poster frame and for audio files from their cover art.
+// Other media types have no thumbnail.
+func (s *MediaService) createThumbnail(media *models.Media, file *os.File) error {
+	var img image.Image
+	var err error
+
+	switch media.Type {
+	case "image":
+		img, err = s.decodeImageFile(file)
+	case "video":
+		img, err = s.generateVideoPoster(file.Name())
+	case "audio":
+		// Audio files were always accepted without processing, missing data is created by embox-backfill
+		if err := s.processAudio(media, file.Name()); err != nil {
+			slog.Warn("failed to process audio file", "media", media.ID, "err", err)
+		}
+		return nil
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.saveRenditions(media, img); err != nil {
+		return err
+	}
+	media.PerceptualHash = perceptualHash(img)
+	if err := s.mediaRepo.SetPerceptualHash(media.ID, media.PerceptualHash); err != nil {
+		slog.Warn("failed to save perceptual hash", "media", media.ID, "err", err)
+	}
+	if err := s.setPlaceholder(media, img); err != nil {
+		slog.Warn("failed to save placeholder", "media", media.ID, "err", err)
+	}
+
+	if media.Type == "video" {
+		// The poster is enough to show the video, missing previews are created by embox-backfill
+		if err := createVideoPreviews(media, file.Name()); err != nil {
+			slog.Warn("failed to create video previews", "media", media.ID, "err", err)
+		}
+	}
+	return nil
+}
+
+// saveRenditions encodes and saves all thumbnail renditions of the image.
+func (s *MediaService) saveRenditions(media *models.Media, img image.Image) error {
+	for _, size := range s.ThumbnailSizes() {
+		thumbnail, err := encodeWebP(img, size)
+		if err != nil {
+			return err
+		}
+		if err := saveMediaFile(renditionPath(media, size), thumbnail); err != nil {
+			return err
+		}
+		s.removeThumbnailVariants(media, size)
+	}
+	return nil
+}
+
+// renditionPath returns the local path of the thumbnail rendition with the given width:
+// the default thumbnail at Media.Path(), all others at yyyy/mm/dd_Id_<size>.webp.
+func renditionPath(media *models.Media, size int) string {
+	if size == imgMaxSize {
+		return media.Path()
+	}
+	return fmt.Sprintf("%s_%d.webp", media.BasePath(), size)
+}
+
+func absDiff(a, b int) int {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
 // getMediaType determines the media type based on the MIME type.
 func getMediaType(mime string) string {
 	if strings.HasPrefix(mime, "image") {
@@ -368,9 +871,9 @@ func getMediaType(mime string) string {
 	return "other"
 }
 
-// saveMediaFile saves the media file data to disk at the specified path.
-func (s *MediaService) saveMediaFile(media *models.Media, data []byte) error {
-	savePath := filepath.Join(MediaDir, media.Path())
+// saveMediaFile saves the media file data to disk at the specified path below MediaDir.
+func saveMediaFile(path string, data []byte) error {
+	savePath := filepath.Join(MediaDir, path)
 	if err := os.MkdirAll(filepath.Dir(savePath), 0755); err != nil {
 		return err
 	}
@@ -382,74 +885,102 @@ func getFileExt(fileName string) string {
 	return strings.TrimPrefix(filepath.Ext(fileName), ".")
 }
 
-// generateVideoPoster extracts a poster image from the video and converts it to WebP format.
-func (s *MediaService) generateVideoPoster(media *models.Media, videoData []byte) ([]byte, error) {
-	tmpFile := filepath.Join(os.TempDir(), fmt.Sprintf("media_%d_orig.%s", media.ID, media.FileExt))
-	if err := os.WriteFile(tmpFile, videoData, 0644); err != nil {
-		return nil, fmt.Errorf("failed to write temp video: %w", err)
+// spoolToTempFile copies r into a new temp file and returns it rewound to the start, together with its size.
+// The caller is responsible for closing and removing the file.
+func spoolToTempFile(r io.Reader) (*os.File, int64, error) {
+	return spoolToDir("", r)
+}
+
+// spoolToDir is spoolToTempFile with the temp file in dir, the default temp dir if empty.
+func spoolToDir(dir string, r io.Reader) (*os.File, int64, error) {
+	tmpFile, err := os.CreateTemp(dir, "embox_upload_*")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create temp file: %w", err)
+	}
+
+	size, err := io.Copy(tmpFile, r)
+	if err == nil {
+		_, err = tmpFile.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return nil, 0, fmt.Errorf("failed to write temp file: %w", err)
 	}
-	defer os.Remove(tmpFile)
 
-	tmpPoster := tmpFile + "_poster.png"
+	return tmpFile, size, nil
+}
+
+// generateVideoPoster extracts a poster image from the video file, as wide as the largest rendition at most.
+// Instead of the first frame, which is often black, ffmpeg's thumbnail filter picks the most representative
+// of the frames following a short intro.
+func (s *MediaService) generateVideoPoster(videoPath string) (image.Image, error) {
+	tmpPoster := videoPath + "_poster.png"
 	defer os.Remove(tmpPoster)
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
+
+	start := 0.0
+	if info, err := probeVideo(videoPath); err == nil {
+		start = min(info.Duration*0.1, 3)
+	}
+	sizes := s.ThumbnailSizes()
 	cmd := exec.CommandContext(ctx, "ffmpeg",
-		"-i", tmpFile,
-		"-vf", fmt.Sprintf("scale='min(%d,iw)':-2", imgMaxSize),
-		"-vframes", "1",
+		"-ss", formatSeconds(start),
+		"-i", videoPath,
+		"-vf", fmt.Sprintf("thumbnail=%d,scale='min(%d,iw)':-2", posterCandidateFrames, sizes[len(sizes)-1]),
+		"-frames:v", "1",
 		tmpPoster,
 	)
 	if err := cmd.Run(); err != nil {
 		return nil, fmt.Errorf("failed to extract video poster: %w", err)
 	}
 
-	posterData, err := os.ReadFile(tmpPoster)
+	posterFile, err := os.Open(tmpPoster)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read poster image: %w", err)
 	}
+	defer posterFile.Close()
 
-	bytes, err := s.convertToWebP(posterData)
+	img, err := decodeImage(posterFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert poster to webp: %w", err)
+		return nil, fmt.Errorf("failed to decode poster: %w", err)
 	}
 
-	return bytes, nil
+	return img, nil
 }
 
-// convertToWebP converts the given image data to WebP format with specified size and quality.
-func (s *MediaService) convertToWebP(data []byte) ([]byte, error) {
-	img, format, err := image.Decode(bytes.NewReader(data))
+// decodeImage decodes the image and rotates it according to its EXIF orientation.
+// The image is read from r, which is rewound between decoding the pixels and the EXIF data.
+func decodeImage(r io.ReadSeeker) (image.Image, error) {
+	img, _, err := image.Decode(r)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode image: %w", err)
 	}
 
-	// 1. Handle Orientation
-	needsProcessing := false
-	exifData, err := exif.Decode(bytes.NewReader(data))
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind image: %w", err)
+	}
+	exifData, err := exif.Decode(r)
 	if err == nil {
 		orientTag, err := exifData.Get(exif.Orientation)
 		if err == nil {
 			orient, err := orientTag.Int(0)
 			if err == nil && orient > 1 {
 				img = applyOrientation(img, orient)
-				needsProcessing = true
 			}
 		}
 	}
 
-	// 2. Handle Resizing (Width must be at most imgMaxSize, height automatic)
-	if img.Bounds().Dx() > imgMaxSize {
-		img = imaging.Resize(img, imgMaxSize, 0, imaging.Lanczos)
-		needsProcessing = true
-	}
+	return img, nil
+}
 
-	// 3. Skip re-encoding if it's already WebP and no changes were made
-	if !needsProcessing && format == "webp" {
-		return data, nil
+// encodeWebP encodes the image to WebP, scaled down to maxWidth if it is wider. Images are never scaled up.
+func encodeWebP(img image.Image, maxWidth int) ([]byte, error) {
+	if img.Bounds().Dx() > maxWidth {
+		img = imaging.Resize(img, maxWidth, 0, imaging.Lanczos)
 	}
 
-	// 4. Encode to WebP
 	var buf bytes.Buffer
 	if err := webp.Encode(&buf, img, &webp.Options{Lossless: false, Quality: imgQuality}); err != nil {
 		return nil, fmt.Errorf("failed to encode webp: %w", err)
@@ -458,12 +989,21 @@ func (s *MediaService) convertToWebP(data []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// applyOrientation turns the image upright according to its EXIF orientation (1-8), including the mirrored ones.
 func applyOrientation(img image.Image, orient int) image.Image {
 	switch orient {
+	case 2:
+		return imaging.FlipH(img)
 	case 3:
 		return imaging.Rotate180(img)
+	case 4:
+		return imaging.FlipV(img)
+	case 5:
+		return imaging.Transpose(img)
 	case 6:
 		return imaging.Rotate270(img)
+	case 7:
+		return imaging.Transverse(img)
 	case 8:
 		return imaging.Rotate90(img)
 	default:
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockContentHash(t *testing.T) {
+	s := &MediaService{}
+
+	unlock := s.lockContentHash("a")
+	locked := make(chan func())
+	go func() { locked <- s.lockContentHash("a") }()
+
+	// Other hashes are not blocked
+	s.lockContentHash("b")()
+
+	select {
+	case <-locked:
+		t.Fatal("expected the second lock of the same hash to wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	(<-locked)()
+
+	if len(s.hashLocks) != 0 {
+		t.Errorf("expected all locks to be removed, got %v", s.hashLocks)
+	}
+}
@@ -0,0 +1,346 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"embox/internal/config"
+)
+
+const (
+	s3EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" // SHA-256 of ""
+	s3UnsignedPayload  = "UNSIGNED-PAYLOAD"
+)
+
+// S3StorageAdapter stores files as objects in an S3-compatible bucket.
+// Requests are signed with AWS Signature Version 4.
+type S3StorageAdapter struct {
+	config *config.StorageConfig
+	client *http.Client
+}
+
+func NewS3StorageAdapter(cfg *config.StorageConfig) *S3StorageAdapter {
+	return &S3StorageAdapter{
+		config: cfg,
+		client: &http.Client{},
+	}
+}
+
+func (a *S3StorageAdapter) Upload(r io.Reader, size int64, filePath string) error {
+	// S3 needs the Content-Length up front, so uploads of unknown size are spooled to disk first
+	if size < 0 {
+		tmpFile, tmpSize, err := spoolToTempFile(r)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmpFile.Name())
+		defer tmpFile.Close()
+		r, size = tmpFile, tmpSize
+	}
+
+	req, err := a.newRequest("PUT", filePath, r)
+	if err != nil {
+		return fmt.Errorf("failed to create upload request: %w", err)
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	if contentType := mime.TypeByExtension(filepath.Ext(filePath)); contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := a.do(req, s3UnsignedPayload)
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(b))
+	}
+
+	return nil
+}
+
+func (a *S3StorageAdapter) Download(path string) ([]byte, string, error) {
+	resp, err := a.DownloadStream(path, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read stream data: %w", err)
+	}
+
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+// DownloadStream returns an http.Response for the object at path.
+// Range and conditional headers are passed through, so the response may be a 206 Partial Content.
+// The caller is responsible for closing the response body.
+func (a *S3StorageAdapter) DownloadStream(path string, headers http.Header) (*http.Response, error) {
+	req, err := a.newRequest("GET", path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create download request: %w", err)
+	}
+	for _, key := range []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"} {
+		if value := headers.Get(key); value != "" {
+			req.Header.Set(key, value)
+		}
+	}
+
+	resp, err := a.do(req, s3EmptyPayloadHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download stream: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusNotModified, http.StatusRequestedRangeNotSatisfiable:
+		return resp, nil
+	default:
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("stream download failed with status %d: %s", resp.StatusCode, string(b))
+	}
+}
+
+func (a *S3StorageAdapter) Delete(filePath string) error {
+	req, err := a.newRequest("DELETE", filePath, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create delete request: %w", err)
+	}
+
+	resp, err := a.do(req, s3EmptyPayloadHash)
+	if err != nil {
+		return fmt.Errorf("failed to execute delete request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("delete failed with status %d: %s", resp.StatusCode, string(b))
+	}
+
+	return nil
+}
+
+// Move copies the object to dstPath and deletes the source, as S3 has no rename.
+func (a *S3StorageAdapter) Move(srcPath, dstPath string) error {
+	req, err := a.newRequest("PUT", dstPath, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create copy request: %w", err)
+	}
+	req.Header.Set("X-Amz-Copy-Source", "/"+a.config.S3Bucket+"/"+s3EscapePath(srcPath))
+
+	resp, err := a.do(req, s3EmptyPayloadHash)
+	if err != nil {
+		return fmt.Errorf("failed to execute copy request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// A copy can fail after the 200 header has been sent, the error is then reported in the body
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || strings.Contains(string(b), "<Error>") {
+		return fmt.Errorf("copy failed with status %d: %s", resp.StatusCode, string(b))
+	}
+
+	return a.Delete(srcPath)
+}
+
+// List returns the keys of all objects in the bucket, following the pagination of ListObjectsV2.
+func (a *S3StorageAdapter) List() ([]string, error) {
+	var keys []string
+	continuationToken := ""
+	for {
+		req, err := a.newRequest("GET", "", nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create list request: %w", err)
+		}
+		query := url.Values{"list-type": {"2"}}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+		req.URL.RawQuery = s3CanonicalQuery(query)
+
+		resp, err := a.do(req, s3EmptyPayloadHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute list request: %w", err)
+		}
+
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, fmt.Errorf("list failed with status %d: %s", resp.StatusCode, string(b))
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode list response: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+// newRequest creates a request for the object key in the configured bucket.
+func (a *S3StorageAdapter) newRequest(method, key string, body io.Reader) (*http.Request, error) {
+	endpoint, err := url.Parse(a.config.S3Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+
+	objectPath := "/" + strings.TrimPrefix(key, "/")
+	if a.config.S3PathStyle {
+		objectPath = "/" + a.config.S3Bucket + objectPath
+	} else {
+		endpoint.Host = a.config.S3Bucket + "." + endpoint.Host
+	}
+	endpoint.Path = objectPath
+	endpoint.RawPath = s3EscapePath(objectPath)
+
+	return http.NewRequest(method, endpoint.String(), body)
+}
+
+// do signs the request with the given payload hash and executes it.
+func (a *S3StorageAdapter) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	a.sign(req, payloadHash, time.Now().UTC())
+	return a.client.Do(req)
+}
+
+// sign adds the AWS Signature Version 4 Authorization header.
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func (a *S3StorageAdapter) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	shortDate := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Sign the host, the range and all x-amz-* headers
+	headers := map[string]string{"host": req.URL.Host}
+	for key, values := range req.Header {
+		lower := strings.ToLower(key)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "range" || lower == "content-type" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := shortDate + "/" + a.config.S3Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+a.config.S3SecretKey), shortDate)
+	key = hmacSHA256(key, a.config.S3Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		a.config.S3AccessKey, scope, signedHeaders, signature))
+}
+
+// s3CanonicalQuery encodes the query with sorted keys as required by SigV4.
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, s3Escape(key)+"="+s3Escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3EscapePath URI-encodes every segment of the path, keeping the slashes.
+func s3EscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// s3Escape encodes everything except the unreserved characters of RFC 3986.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
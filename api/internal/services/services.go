@@ -13,24 +13,26 @@ type Services struct {
 	Media     *MediaService
 	Favourite *FavouriteService
 	Album     *AlbumService
+	Upload    *UploadService
+	Fsck      *FsckService
+	Trash     *TrashService
+	Job       *JobService
 }
 
 // Init initializes all services with the provided API configuration and repositories.
 // It sets up the necessary dependencies for each service and returns a Services struct.
 func Init(apiConfig *config.ApiConfig, repos *repositories.Repositories) *Services {
-	var storageService Storage
-	if apiConfig.Storage.Adapter == "local" {
-		log.Println("INFO: Using local storage adapter")
-		storageService = NewLocalStorageAdapter(apiConfig.Storage.LocalDir)
-	} else {
-		storageService = NewStorageService(apiConfig.Storage)
-	}
+	storageService := NewStorage(apiConfig.Storage)
 	emailService := NewEmailService(apiConfig.Email)
 	userService := NewUserService(repos.User)
 	authService := NewAuthService(apiConfig.Auth, emailService)
-	mediaService := NewMediaService(storageService, repos.Media, repos.User)
+	jobService := NewJobService(apiConfig.Media, repos.Job, repos.User)
+	mediaService := NewMediaService(apiConfig.Upload, apiConfig.Media, storageService, repos.Media, repos.User, jobService)
 	favouriteService := NewFavouriteService(repos.User, repos.Favourite)
 	albumService := NewAlbumService(repos.User, repos.Album)
+	uploadService := NewUploadService(apiConfig.Upload, mediaService)
+	fsckService := NewFsckService(storageService, mediaService, repos.Media)
+	trashService := NewTrashService(apiConfig.Trash, repos.User, repos.Media, repos.Album, mediaService)
 
 	return &Services{
 		Auth:      authService,
@@ -39,5 +41,39 @@ func Init(apiConfig *config.ApiConfig, repos *repositories.Repositories) *Servic
 		Media:     mediaService,
 		Favourite: favouriteService,
 		Album:     albumService,
+		Upload:    uploadService,
+		Fsck:      fsckService,
+		Trash:     trashService,
+		Job:       jobService,
+	}
+}
+
+// NewStorage creates the storage for original files as selected by the STORAGE_ADAPTER setting.
+// If an encryption key is configured, the storage is wrapped to encrypt all originals.
+func NewStorage(cfg *config.StorageConfig) Storage {
+	var storage Storage
+	switch cfg.Adapter {
+	case "local":
+		log.Println("INFO: Using local storage adapter")
+		storage = NewLocalStorageAdapter(cfg.LocalDir)
+	case "s3":
+		log.Println("INFO: Using S3 storage adapter")
+		storage = NewS3StorageAdapter(cfg)
+	case "webdav":
+		log.Println("INFO: Using WebDAV storage adapter")
+		storage = NewWebdavStorageAdapter(cfg)
+	default:
+		storage = NewStorageService(cfg)
+	}
+
+	key, err := LoadEncryptionKey(cfg)
+	if err != nil {
+		log.Fatalf("invalid storage encryption key: %v", err)
 	}
+	if key != nil {
+		log.Println("INFO: Encrypting originals in storage")
+		storage = NewEncryptedStorage(storage, key)
+	}
+
+	return storage
 }
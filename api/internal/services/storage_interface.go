@@ -1,10 +1,18 @@
 package services
 
-import "net/http"
+import (
+	"io"
+	"net/http"
+)
 
 type Storage interface {
-	Upload(data []byte, filePath string) error
+	// Upload streams r to filePath. size is a hint for the total length in bytes (-1 if unknown).
+	Upload(r io.Reader, size int64, filePath string) error
 	Download(path string) ([]byte, string, error)
 	DownloadStream(path string, headers http.Header) (*http.Response, error)
 	Delete(filePath string) error
+	// Move relocates a file, creating missing parent directories of dstPath.
+	Move(srcPath, dstPath string) error
+	// List returns the paths of all stored files, relative to the storage root.
+	List() ([]string, error)
 }
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"embox/internal/api/dto"
+	"embox/internal/models"
+	"embox/internal/repositories"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// StorageMigration copies the originals of all media from one storage to another.
+// Every copy is verified by reading it back from the destination and comparing size and SHA-256.
+// Verified copies are appended to a state file, so an interrupted migration resumes where it stopped.
+type StorageMigration struct {
+	src         Storage
+	dst         Storage
+	mediaRepo   repositories.MediaRepository
+	stateFile   string
+	concurrency int
+
+	stateMu sync.Mutex
+	state   *os.File
+}
+
+// migrationState is one line of the state file.
+type migrationState struct {
+	ID     uint   `json:"id"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func NewStorageMigration(src, dst Storage, mediaRepo repositories.MediaRepository, stateFile string, concurrency int) *StorageMigration {
+	return &StorageMigration{
+		src:         src,
+		dst:         dst,
+		mediaRepo:   mediaRepo,
+		stateFile:   stateFile,
+		concurrency: max(concurrency, 1),
+	}
+}
+
+// Run copies all originals that are not recorded as done in the state file.
+func (m *StorageMigration) Run() (*dto.MigrationReportDto, error) {
+	started := time.Now()
+
+	done, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+	m.state, err = os.OpenFile(m.stateFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state file: %w", err)
+	}
+	defer m.state.Close()
+
+	mediaList, err := m.mediaRepo.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load media: %w", err)
+	}
+
+	report := &dto.MigrationReportDto{Total: len(mediaList), Failed: []dto.MigrationFailureDto{}}
+	var reportMu sync.Mutex
+
+	jobs := make(chan *models.Media)
+	var wg sync.WaitGroup
+	for range m.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for media := range jobs {
+				size, err := m.copy(media)
+
+				reportMu.Lock()
+				if err != nil {
+					slog.Error("failed to migrate original", "media", media.ID, "path", media.RemotePath(), "err", err)
+					report.Failed = append(report.Failed, dto.MigrationFailureDto{Id: media.ID, Path: media.RemotePath(), Error: err.Error()})
+				} else {
+					report.Copied++
+					report.Bytes += size
+				}
+				reportMu.Unlock()
+			}
+		}()
+	}
+
+	for _, media := range mediaList {
+		if done[media.RemotePath()] {
+			report.Skipped++
+			continue
+		}
+		jobs <- media
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.Slice(report.Failed, func(i, j int) bool { return report.Failed[i].Id < report.Failed[j].Id })
+	report.Duration = time.Since(started).Round(time.Millisecond).String()
+	return report, nil
+}
+
+// copy streams the original of media to the destination, verifies it and records it in the state file.
+func (m *StorageMigration) copy(media *models.Media) (int64, error) {
+	path := media.RemotePath()
+
+	resp, err := m.src.DownloadStream(path, nil)
+	if err != nil {
+		return 0, fmt.Errorf("download: %w", err)
+	}
+	defer resp.Body.Close()
+
+	size := resp.ContentLength
+	if size == 0 && resp.Header.Get("Content-Length") == "" {
+		size = -1 // not set by the adapter
+	}
+
+	srcHash := sha256.New()
+	counter := &countingReader{r: io.TeeReader(resp.Body, srcHash)}
+	if err := m.dst.Upload(counter, size, path); err != nil {
+		return 0, fmt.Errorf("upload: %w", err)
+	}
+	srcSum := hex.EncodeToString(srcHash.Sum(nil))
+
+	// The checksums are only known once the file is copied, a corrupt copy is removed again
+	// so the destination never holds it, e.g. if the migration is not resumed
+	if media.ContentHash != "" && media.ContentHash != srcSum {
+		return 0, m.removeCopy(path, fmt.Errorf("source checksum %s does not match the checksum %s recorded on upload", srcSum, media.ContentHash))
+	}
+
+	dstSize, dstSum, err := m.checksum(path)
+	if err != nil {
+		return 0, fmt.Errorf("verify: %w", err)
+	}
+	if dstSize != counter.n || dstSum != srcSum {
+		return 0, m.removeCopy(path, fmt.Errorf("verification failed: copied %d bytes (sha256 %s), destination has %d bytes (sha256 %s)",
+			counter.n, srcSum, dstSize, dstSum))
+	}
+
+	return counter.n, m.recordDone(migrationState{ID: media.ID, Path: path, Size: dstSize, SHA256: dstSum})
+}
+
+// removeCopy deletes a corrupt copy from the destination and returns the error that caused it.
+func (m *StorageMigration) removeCopy(path string, cause error) error {
+	if err := m.dst.Delete(path); err != nil {
+		return fmt.Errorf("%w, failed to remove the copy: %v", cause, err)
+	}
+	return cause
+}
+
+// checksum reads the file back from the destination.
+func (m *StorageMigration) checksum(path string) (int64, string, error) {
+	resp, err := m.dst.DownloadStream(path, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, resp.Body)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (m *StorageMigration) loadState() (map[string]bool, error) {
+	done := make(map[string]bool)
+
+	f, err := os.Open(m.stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry migrationState
+		// A line cut off by an interruption is ignored, the file is then copied again
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
+			done[entry.Path] = true
+		}
+	}
+	return done, scanner.Err()
+}
+
+func (m *StorageMigration) recordDone(entry migrationState) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	if _, err := m.state.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -1,15 +1,21 @@
 package services
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
+	"math/rand/v2"
 	"mime/multipart"
+	"net"
 	"net/http"
+	"net/url"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"embox/internal/config"
 )
@@ -18,27 +24,46 @@ type StorageService struct {
 	token     string
 	tokenLock sync.Mutex
 	config    *config.StorageConfig
-	client    *http.Client
+	// client is used for API calls (auth, links, delete, move) and bounded by the overall timeout.
+	client *http.Client
+	// streamClient transfers file contents, which may take longer than any fixed timeout.
+	streamClient *http.Client
 }
 
 func NewStorageService(cfg *config.StorageConfig) *StorageService {
+	connectTimeout := time.Duration(cfg.ConnectTimeout) * time.Second
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = connectTimeout
+	transport.ResponseHeaderTimeout = time.Duration(cfg.ResponseTimeout) * time.Second
+
 	return &StorageService{
 		config: cfg,
-		client: &http.Client{},
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   time.Duration(cfg.Timeout) * time.Second,
+		},
+		streamClient: &http.Client{Transport: transport},
 	}
 }
 
 // Retrieves and caches an authentication token.
+// The lock is not held while the token is requested, so retries do not block other requests;
+// concurrent callers without a token may each request one.
 func (s *StorageService) Auth() error {
-	s.tokenLock.Lock()
-	defer s.tokenLock.Unlock()
-
-	if s.token != "" {
+	if s.getToken() != "" {
 		return nil // Token is already available
 	}
 
-	body := strings.NewReader(fmt.Sprintf("username=%s&password=%s", s.config.Username, s.config.Password))
-	resp, err := http.Post(s.config.Url+"/auth-token/", "application/x-www-form-urlencoded", body)
+	form := url.Values{"username": {s.config.Username}, "password": {s.config.Password}}
+	resp, err := s.doWithRetry(s.client, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", s.config.Url+"/auth-token/", strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	})
 	if err != nil {
 		return fmt.Errorf("failed to request auth token: %w", err)
 	}
@@ -56,40 +81,139 @@ func (s *StorageService) Auth() error {
 		return fmt.Errorf("failed to decode auth response: %w", err)
 	}
 
+	s.tokenLock.Lock()
+	defer s.tokenLock.Unlock()
 	s.token = result.Token
 	return nil
 }
 
 // Uploads a file to the repository.
 // path is a full path in the repo, e.g. "2025/09/15_123.jpg"
-func (s *StorageService) Upload(data []byte, filePath string) error {
-	if err := s.Auth(); err != nil {
+// The multipart body is written through an io.Pipe, so the file is never held in memory.
+// If the token has been revoked, the upload is sent again with a new token, provided the content
+// has not been read yet or r can be rewound.
+func (s *StorageService) Upload(r io.Reader, size int64, filePath string) error {
+	content, err := newUploadContent(r)
+	if err != nil {
 		return err
 	}
 
+	for refreshed := false; ; refreshed = true {
+		token, resp, err := s.upload(content, filePath)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			s.invalidateToken(token)
+			if !refreshed && content.rewind() {
+				resp.Body.Close()
+				continue
+			}
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			b, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("upload failed: %s", string(b))
+		}
+		return nil
+	}
+}
+
+// upload sends the content once and returns the token it was sent with and the response.
+func (s *StorageService) upload(content *uploadContent, filePath string) (string, *http.Response, error) {
+	if err := s.Auth(); err != nil {
+		return "", nil, err
+	}
+
 	parentDir := "/"
 	relativePath := filepath.Dir(filePath) + "/"
 	filename := filepath.Base(filePath)
 
 	uploadURL, err := s.getUploadUrl(parentDir)
 	if err != nil {
-		return fmt.Errorf("failed to get upload URL: %w", err)
+		return "", nil, fmt.Errorf("failed to get upload URL: %w", err)
 	}
 
-	// Prepare the multipart body
-	var buf bytes.Buffer
-	writer := multipart.NewWriter(&buf)
+	// Prepare the multipart body, written by a goroutine while the request is being sent.
+	// The goroutine has stopped reading the content when upload returns.
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	done := make(chan struct{})
+	defer func() {
+		pr.Close()
+		<-done
+	}()
 
-	// Add the file
-	part, err := writer.CreateFormFile("file", filename)
+	go func() {
+		defer close(done)
+		pw.CloseWithError(writeUploadBody(writer, content, filename, parentDir, relativePath))
+	}()
+
+	// Upload the file
+	upReq, err := http.NewRequest("POST", uploadURL, pr)
 	if err != nil {
-		return fmt.Errorf("failed to create form file: %w", err)
+		return "", nil, fmt.Errorf("failed to create upload request: %w", err)
 	}
-	if _, err := part.Write(data); err != nil {
-		return fmt.Errorf("failed to write file data: %w", err)
+	token := s.getToken()
+	upReq.Header.Set("Authorization", "Token "+token)
+	upReq.Header.Set("Content-Type", writer.FormDataContentType())
+	// The content is only sent once the server accepted the request, so it is still unread after a 401
+	upReq.Header.Set("Expect", "100-continue")
+
+	// The streamed body cannot be replayed, so the upload itself is not retried on server errors
+	upResp, err := s.streamClient.Do(upReq)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to upload file: %w", err)
 	}
+	return token, upResp, nil
+}
 
-	// Add Params parent_dir, relative_path and replace
+// uploadContent is the content of an upload, which tracks whether it has been read so it can be sent again.
+type uploadContent struct {
+	r     io.Reader
+	read  bool
+	start int64 // offset to rewind to, if r is an io.Seeker
+}
+
+func newUploadContent(r io.Reader) (*uploadContent, error) {
+	content := &uploadContent{r: r}
+	if seeker, ok := r.(io.Seeker); ok {
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get upload offset: %w", err)
+		}
+		content.start = start
+	}
+	return content, nil
+}
+
+func (c *uploadContent) Read(p []byte) (int, error) {
+	c.read = true
+	return c.r.Read(p)
+}
+
+// rewind reports whether the content can be sent again: it has not been read yet or could be rewound.
+func (c *uploadContent) rewind() bool {
+	if !c.read {
+		return true
+	}
+	seeker, ok := c.r.(io.Seeker)
+	if !ok {
+		return false
+	}
+	if _, err := seeker.Seek(c.start, io.SeekStart); err != nil {
+		return false
+	}
+	c.read = false
+	return true
+}
+
+// writeUploadBody writes the upload form fields and the file content to the multipart writer.
+func writeUploadBody(writer *multipart.Writer, r io.Reader, filename, parentDir, relativePath string) error {
+	// Add Params parent_dir, relative_path and replace before the file,
+	// so the upload server knows the target before the content arrives
 	if err := writer.WriteField("parent_dir", parentDir); err != nil {
 		return fmt.Errorf("failed to write parent_dir field: %w", err)
 	}
@@ -102,28 +226,18 @@ func (s *StorageService) Upload(data []byte, filePath string) error {
 		return fmt.Errorf("failed to write replace field: %w", err)
 	}
 
-	// Close the writer to finalize the multipart body
-	if err := writer.Close(); err != nil {
-		return fmt.Errorf("failed to close multipart writer: %w", err)
-	}
-
-	// Upload the file
-	upReq, err := http.NewRequest("POST", uploadURL, &buf)
+	// Add the file
+	part, err := writer.CreateFormFile("file", filename)
 	if err != nil {
-		return fmt.Errorf("failed to create upload request: %w", err)
+		return fmt.Errorf("failed to create form file: %w", err)
 	}
-	upReq.Header.Set("Authorization", "Token "+s.token)
-	upReq.Header.Set("Content-Type", writer.FormDataContentType())
-
-	upResp, err := s.client.Do(upReq)
-	if err != nil {
-		return fmt.Errorf("failed to upload file: %w", err)
+	if _, err := io.Copy(part, r); err != nil {
+		return fmt.Errorf("failed to write file data: %w", err)
 	}
-	defer upResp.Body.Close()
 
-	if upResp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(upResp.Body)
-		return fmt.Errorf("upload failed: %s", string(b))
+	// Close the writer to finalize the multipart body
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to close multipart writer: %w", err)
 	}
 
 	return nil
@@ -154,13 +268,14 @@ func (s *StorageService) DownloadStream(path string, headers http.Header) (*http
 
 	// Get the file download link
 	fileURL := fmt.Sprintf("%s/repos/%s/file/?p=/%s", s.config.Url, s.config.RepoID, path)
-	req, err := http.NewRequest("GET", fileURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create download request: %w", err)
-	}
-	req.Header.Set("Authorization", "Token "+s.token)
-
-	resp, err := s.client.Do(req)
+	resp, err := s.doAuthorized(s.client, true, func(token string) (*http.Request, error) {
+		req, err := http.NewRequest("GET", fileURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Token "+token)
+		return req, nil
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to request file url: %w", err)
 	}
@@ -189,7 +304,7 @@ func (s *StorageService) DownloadStream(path string, headers http.Header) (*http
 		}
 	}
 
-	fileResp, err := s.client.Do(fileReq)
+	fileResp, err := s.streamClient.Do(fileReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to download stream: %w", err)
 	}
@@ -212,14 +327,15 @@ func (s *StorageService) Delete(filePath string) error {
 
 	deleteURL := fmt.Sprintf("%s/repos/%s/file/?p=/%s", s.config.Url, s.config.RepoID, filePath)
 
-	req, err := http.NewRequest("DELETE", deleteURL, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create delete request: %w", err)
-	}
-	req.Header.Set("Authorization", "Token "+s.token)
-	req.Header.Set("Accept", "application/json; charset=utf-8; indent=4")
-
-	resp, err := s.client.Do(req)
+	resp, err := s.doAuthorized(s.client, true, func(token string) (*http.Request, error) {
+		req, err := http.NewRequest("DELETE", deleteURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Token "+token)
+		req.Header.Set("Accept", "application/json; charset=utf-8; indent=4")
+		return req, nil
+	})
 	if err != nil {
 		return fmt.Errorf("failed to execute delete request: %w", err)
 	}
@@ -241,19 +357,146 @@ func (s *StorageService) Delete(filePath string) error {
 	return nil
 }
 
-func (s *StorageService) getUploadUrl(parentDir string) (string, error) {
-	// Erstelle die URL für den Upload-Link
-	uploadLinkURL := fmt.Sprintf("%s/repos/%s/upload-link/?p=%s", s.config.Url, s.config.RepoID, parentDir)
+// List returns the paths of all files in the repo, e.g. "2025/09/15_123.jpg".
+func (s *StorageService) List() ([]string, error) {
+	if err := s.Auth(); err != nil {
+		return nil, err
+	}
+
+	listURL := fmt.Sprintf("%s/repos/%s/dir/?p=/&t=f&recursive=1", s.config.Url, s.config.RepoID)
+
+	resp, err := s.doAuthorized(s.client, true, func(token string) (*http.Request, error) {
+		req, err := http.NewRequest("GET", listURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Token "+token)
+		req.Header.Set("Accept", "application/json; charset=utf-8; indent=4")
+		return req, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute list request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("list failed: %s", string(b))
+	}
+
+	var entries []struct {
+		Name      string `json:"name"`
+		ParentDir string `json:"parent_dir"`
+		Type      string `json:"type"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("failed to decode list response: %w", err)
+	}
+
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type == "file" {
+			files = append(files, strings.TrimPrefix(path.Join(entry.ParentDir, entry.Name), "/"))
+		}
+	}
+
+	return files, nil
+}
+
+// Move relocates a file within the repo, e.g. from "2025/09/15_123.jpg" to "2024/06/01_123.jpg".
+// Seafile can only rename within a directory and move without renaming, so both steps are combined.
+func (s *StorageService) Move(srcPath, dstPath string) error {
+	if err := s.Auth(); err != nil {
+		return err
+	}
 
-	// Erstelle die HTTP-Anfrage
-	req, err := http.NewRequest("GET", uploadLinkURL, nil)
+	srcDir, srcName := path.Split("/" + srcPath)
+	dstDir, dstName := path.Split("/" + dstPath)
+
+	if srcDir != dstDir {
+		if err := s.fileOperation("dir", dstDir, url.Values{
+			"operation":      {"mkdir"},
+			"create_parents": {"true"},
+		}); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", dstDir, err)
+		}
+	}
+
+	current := "/" + srcPath
+	if srcName != dstName {
+		if err := s.fileOperation("file", current, url.Values{
+			"operation": {"rename"},
+			"newname":   {dstName},
+		}); err != nil {
+			return fmt.Errorf("failed to rename file: %w", err)
+		}
+		current = srcDir + dstName
+	}
+
+	if srcDir != dstDir {
+		if err := s.fileOperation("file", current, url.Values{
+			"operation": {"move"},
+			"dst_repo":  {s.config.RepoID},
+			"dst_dir":   {dstDir},
+		}); err != nil {
+			// Undo the rename, so the file stays reachable under its old path
+			if current != "/"+srcPath {
+				_ = s.fileOperation("file", current, url.Values{"operation": {"rename"}, "newname": {srcName}})
+			}
+			return fmt.Errorf("failed to move file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// fileOperation posts a form to the file or dir endpoint of the repo (e.g. rename, move, mkdir).
+// Seafile answers successful operations with a redirect, which is not followed.
+func (s *StorageService) fileOperation(endpoint, p string, form url.Values) error {
+	opURL := fmt.Sprintf("%s/repos/%s/%s/?p=%s", s.config.Url, s.config.RepoID, endpoint, url.QueryEscape(p))
+
+	client := *s.client
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	// Rename and move are not idempotent, so only the token refresh is applied, no retries
+	resp, err := s.doAuthorized(&client, false, func(token string) (*http.Request, error) {
+		req, err := http.NewRequest("POST", opURL, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Token "+token)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to create upload-link request: %w", err)
+		return fmt.Errorf("failed to execute %s request: %w", form.Get("operation"), err)
 	}
-	req.Header.Set("Authorization", "Token "+s.token)
+	defer resp.Body.Close()
 
-	// Führe die Anfrage aus
-	resp, err := s.client.Do(req)
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusMovedPermanently, http.StatusFound:
+		return nil
+	default:
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s failed with status %d: %s", form.Get("operation"), resp.StatusCode, string(b))
+	}
+}
+
+func (s *StorageService) getUploadUrl(parentDir string) (string, error) {
+	// Erstelle die URL für den Upload-Link
+	uploadLinkURL := fmt.Sprintf("%s/repos/%s/upload-link/?p=%s", s.config.Url, s.config.RepoID, parentDir)
+
+	// Erstelle und führe die HTTP-Anfrage aus
+	resp, err := s.doAuthorized(s.client, true, func(token string) (*http.Request, error) {
+		req, err := http.NewRequest("GET", uploadLinkURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Token "+token)
+		return req, nil
+	})
 	if err != nil {
 		return "", fmt.Errorf("failed to request upload link: %w", err)
 	}
@@ -273,3 +516,104 @@ func (s *StorageService) getUploadUrl(parentDir string) (string, error) {
 
 	return uploadURL, nil
 }
+
+// doAuthorized executes the request built by newReq with the cached token.
+// If the token has been revoked or expired (401/403), it is dropped and re-acquired once.
+// With retry, network errors, 429 and 5xx responses are retried with backoff.
+func (s *StorageService) doAuthorized(client *http.Client, retry bool, newReq func(token string) (*http.Request, error)) (*http.Response, error) {
+	refreshed := false
+	for {
+		if err := s.Auth(); err != nil {
+			return nil, err
+		}
+		token := s.getToken()
+
+		var resp *http.Response
+		var err error
+		if retry {
+			resp, err = s.doWithRetry(client, func() (*http.Request, error) { return newReq(token) })
+		} else {
+			var req *http.Request
+			if req, err = newReq(token); err == nil {
+				resp, err = client.Do(req)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) && !refreshed {
+			resp.Body.Close()
+			s.invalidateToken(token)
+			refreshed = true
+			continue
+		}
+
+		return resp, nil
+	}
+}
+
+// doWithRetry executes the request built by newReq and retries it on network errors,
+// 429 and 5xx responses with exponential backoff and full jitter.
+// newReq is called for every attempt, so each attempt gets a fresh request body.
+func (s *StorageService) doWithRetry(client *http.Client, newReq func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		retryable := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		if !retryable || attempt >= s.config.MaxRetries {
+			return resp, err
+		}
+
+		delay := s.retryDelay(attempt)
+		if err == nil {
+			if after, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+				delay = after
+			}
+			resp.Body.Close()
+		}
+
+		slog.Warn("storage request failed, retrying", "url", req.URL.Redacted(), "attempt", attempt+1, "delay", delay, "err", err)
+		time.Sleep(delay)
+	}
+}
+
+// maxRetryDelay caps the backoff and the Retry-After delay requested by the server.
+const maxRetryDelay = 30 * time.Second
+
+// retryDelay returns a random delay between 0 and RetryDelay * 2^attempt, capped at maxRetryDelay.
+func (s *StorageService) retryDelay(attempt int) time.Duration {
+	backoff := time.Duration(s.config.RetryDelay) * time.Millisecond << attempt
+	if backoff <= 0 || backoff > maxRetryDelay {
+		backoff = maxRetryDelay
+	}
+	return time.Duration(rand.Int64N(int64(backoff) + 1))
+}
+
+// retryAfter parses a Retry-After header in seconds, capped at maxRetryDelay.
+func retryAfter(header string) (time.Duration, bool) {
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds <= 0 {
+		return 0, false
+	}
+	return min(time.Duration(seconds)*time.Second, maxRetryDelay), true
+}
+
+func (s *StorageService) getToken() string {
+	s.tokenLock.Lock()
+	defer s.tokenLock.Unlock()
+	return s.token
+}
+
+// invalidateToken drops the cached token, unless it has already been replaced by another request.
+func (s *StorageService) invalidateToken(token string) {
+	s.tokenLock.Lock()
+	defer s.tokenLock.Unlock()
+	if s.token == token {
+		s.token = ""
+	}
+}
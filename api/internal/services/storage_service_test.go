@@ -0,0 +1,41 @@
+package services
+
+import (
+	"embox/internal/config"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	for header, want := range map[string]time.Duration{
+		"1":   time.Second,
+		"12":  12 * time.Second,
+		"30":  30 * time.Second,
+		"31":  maxRetryDelay,
+		"600": maxRetryDelay,
+	} {
+		if got, ok := retryAfter(header); !ok || got != want {
+			t.Errorf("retryAfter(%q) = %v, %v, want %v", header, got, ok, want)
+		}
+	}
+	// HTTP dates are not supported, the backoff is used instead
+	for _, header := range []string{"", "0", "-5", "Wed, 21 Oct 2026 07:28:00 GMT"} {
+		if got, ok := retryAfter(header); ok {
+			t.Errorf("retryAfter(%q) = %v, expected none", header, got)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	s := &StorageService{config: &config.StorageConfig{RetryDelay: 100}}
+	for attempt, limit := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		for range 20 {
+			if delay := s.retryDelay(attempt); delay < 0 || delay > limit {
+				t.Fatalf("retryDelay(%d) = %v, expected at most %v", attempt, delay, limit)
+			}
+		}
+	}
+	if delay := s.retryDelay(20); delay > maxRetryDelay {
+		t.Errorf("retryDelay(20) = %v, expected at most %v", delay, maxRetryDelay)
+	}
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"embox/internal/models"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chai2010/webp"
+	"github.com/disintegration/imaging"
+)
+
+// Thumbnail formats besides WebP are derived from the WebP renditions on first request
+// and cached next to them, e.g. yyyy/mm/dd_Id_1600.webp → yyyy/mm/dd_Id_1600.avif.
+const (
+	ThumbnailFormatWebP = "webp"
+	ThumbnailFormatAVIF = "avif"
+	ThumbnailFormatJPEG = "jpeg"
+)
+
+// Time after which a failed conversion is tried again
+const variantFailureTTL = 10 * time.Minute
+
+// variantFailure is a cached failed conversion of a thumbnail variant.
+type variantFailure struct {
+	err error
+	at  time.Time
+}
+
+var thumbnailVariantExts = map[string]string{
+	ThumbnailFormatAVIF: ".avif",
+	ThumbnailFormatJPEG: ".jpg",
+}
+
+// thumbnailVariant returns the path of the WebP thumbnail at webpPath converted to format,
+// creating the file if it does not exist yet. Conversions that failed are not retried for variantFailureTTL,
+// e.g. if ffmpeg was built without an AV1 encoder.
+func (s *MediaService) thumbnailVariant(webpPath, format string) (string, error) {
+	ext, ok := thumbnailVariantExts[format]
+	if !ok {
+		return webpPath, nil
+	}
+
+	variantPath := strings.TrimSuffix(webpPath, filepath.Ext(webpPath)) + ext
+	if _, err := os.Stat(variantPath); err == nil {
+		return variantPath, nil
+	}
+	if failed, ok := s.variantFailures.Load(variantPath); ok {
+		if failure := failed.(variantFailure); time.Since(failure.at) < variantFailureTTL {
+			return "", failure.err
+		}
+		s.variantFailures.Delete(variantPath)
+	}
+
+	// Each request converts into its own temp file, concurrent requests for the same variant
+	// never see a partial file and the last rename wins
+	tmpPath, err := createTempPath(variantPath)
+	if err != nil {
+		return "", err
+	}
+
+	switch format {
+	case ThumbnailFormatAVIF:
+		err = convertToAVIF(webpPath, tmpPath)
+	case ThumbnailFormatJPEG:
+		err = convertToJPEG(webpPath, tmpPath)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, variantPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		err = fmt.Errorf("failed to convert thumbnail to %s: %w", format, err)
+		s.variantFailures.Store(variantPath, variantFailure{err: err, at: time.Now()})
+		return "", err
+	}
+
+	return variantPath, nil
+}
+
+// removeThumbnailVariants deletes the cached AVIF and JPEG variants of a WebP rendition below MediaDir,
+// so they are recreated from the new rendition.
+func (s *MediaService) removeThumbnailVariants(media *models.Media, size int) {
+	webpPath := filepath.Join(MediaDir, renditionPath(media, size))
+	for _, ext := range thumbnailVariantExts {
+		variantPath := strings.TrimSuffix(webpPath, filepath.Ext(webpPath)) + ext
+		_ = os.Remove(variantPath)
+		s.variantFailures.Delete(variantPath)
+	}
+}
+
+// convertToAVIF encodes the image with the default AV1 encoder of ffmpeg.
+func convertToAVIF(src, dst string) error {
+	return runFFmpeg(30*time.Second,
+		"-i", src,
+		"-frames:v", "1",
+		"-pix_fmt", "yuv420p",
+		"-crf", "32",
+		"-f", "avif",
+		dst,
+	)
+}
+
+// convertToJPEG re-encodes the WebP image as JPEG. Transparent areas become white.
+func convertToJPEG(src, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	img, err := webp.Decode(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to decode webp: %w", err)
+	}
+
+	bounds := img.Bounds()
+	flat := imaging.Overlay(imaging.New(bounds.Dx(), bounds.Dy(), color.White), img, image.Pt(0, 0), 1.0)
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(out, flat, &jpeg.Options{Quality: int(imgQuality)}); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to encode jpeg: %w", err)
+	}
+	return out.Close()
+}
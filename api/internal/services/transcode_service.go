@@ -0,0 +1,381 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"embox/internal/config"
+	"embox/internal/models"
+	"embox/internal/repositories"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transcoding states of a video, stored in Media.TranscodeStatus
+const (
+	TranscodePending = "pending"
+	TranscodeDone    = "done"
+	TranscodeFailed  = "failed"
+)
+
+// HLS master playlist below Media.HlsDir(). It references the variant playlists as stream/<height>p.m3u8,
+// relative to the URL /media/:id/stream.m3u8 it is served at.
+const hlsMasterPlaylist = "index.m3u8"
+
+const hlsSegmentSeconds = 6
+
+// Transcodings of a video, failed ones are retried by embox-backfill until this many were made
+const maxTranscodeTries = 3
+
+// TranscodeService converts videos to H.264/AAC, which every browser plays: an MP4 at the largest
+// configured height and an HLS ladder with one rendition per height. The files are kept below MediaDir.
+type TranscodeService struct {
+	config    *config.MediaConfig
+	storage   Storage
+	mediaRepo repositories.MediaRepository
+
+	queue     chan uint
+	startOnce sync.Once
+
+	mu        sync.Mutex
+	scheduled map[uint]bool // queued or being transcoded
+	overflow  bool          // pending videos were not queued as the queue was full
+}
+
+func NewTranscodeService(cfg *config.MediaConfig, storage Storage, mediaRepo repositories.MediaRepository) *TranscodeService {
+	return &TranscodeService{
+		config:    cfg,
+		storage:   storage,
+		mediaRepo: mediaRepo,
+		queue:     make(chan uint, 256),
+		scheduled: make(map[uint]bool),
+	}
+}
+
+// Enqueue schedules the video for transcoding in the background; its TranscodeStatus must be pending.
+// The workers are started on first use, so command line tools do not start them.
+func (s *TranscodeService) Enqueue(id uint) {
+	s.startOnce.Do(func() {
+		for range max(s.config.TranscodeWorkers, 1) {
+			go s.work()
+		}
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.scheduled[id] {
+		return
+	}
+	select {
+	case s.queue <- id:
+		s.scheduled[id] = true
+	default:
+		// Don't block the upload if many videos are waiting, the video stays pending in the database
+		// and is loaded again once the queue is empty
+		s.overflow = true
+	}
+}
+
+// ResumePending enqueues the videos whose transcoding was interrupted, e.g. by a restart.
+func (s *TranscodeService) ResumePending() {
+	mediaList, err := s.mediaRepo.GetByTranscodeStatus(TranscodePending)
+	if err != nil {
+		slog.Error("failed to load pending transcodings", "err", err)
+		return
+	}
+	for _, media := range mediaList {
+		s.Enqueue(media.ID)
+	}
+}
+
+func (s *TranscodeService) work() {
+	for id := range s.queue {
+		media, err := s.mediaRepo.GetById(id)
+		if err != nil || media == nil {
+			slog.Error("failed to load video for transcoding", "media", id, "err", err)
+		} else if err := s.Transcode(media); err != nil {
+			slog.Error("failed to transcode video", "media", id, "err", err)
+		}
+
+		s.mu.Lock()
+		delete(s.scheduled, id)
+		refill := s.overflow && len(s.queue) == 0
+		if refill {
+			s.overflow = false
+		}
+		s.mu.Unlock()
+		if refill {
+			s.ResumePending()
+		}
+	}
+}
+
+// Transcode creates the MP4 and the HLS ladder of the video and records the result in its TranscodeStatus.
+func (s *TranscodeService) Transcode(media *models.Media) error {
+	if err := s.mediaRepo.AddTranscodeTry(media.ID); err != nil {
+		return fmt.Errorf("failed to save transcode attempt: %w", err)
+	}
+	media.TranscodeTries++
+
+	err := s.transcode(media)
+
+	status := TranscodeDone
+	if err != nil {
+		status = TranscodeFailed
+	}
+	if statusErr := s.mediaRepo.SetTranscodeStatus(media.ID, status); statusErr != nil && err == nil {
+		err = fmt.Errorf("failed to save transcode status: %w", statusErr)
+	}
+	media.TranscodeStatus = status
+
+	return err
+}
+
+func (s *TranscodeService) transcode(media *models.Media) error {
+	resp, err := s.storage.DownloadStream(media.RemotePath(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to download original file: %w", err)
+	}
+	tmpFile, _, err := spoolToTempFile(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Close()
+
+	source, err := probeVideo(tmpFile.Name())
+	if err != nil {
+		return err
+	}
+	rungs, err := s.hlsRungs(min(source.Width, source.Height))
+	if err != nil {
+		return err
+	}
+
+	// Everything is written to a temp dir first, so a running stream is not interrupted
+	hlsDir := filepath.Join(MediaDir, media.HlsDir())
+	if err := os.MkdirAll(filepath.Dir(hlsDir), 0755); err != nil {
+		return err
+	}
+	workDir, err := os.MkdirTemp(filepath.Dir(hlsDir), filepath.Base(hlsDir)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+	if err := os.Chmod(workDir, 0755); err != nil {
+		return err
+	}
+
+	// The MP4 is encoded at the top rung, whose HLS rendition is then cut from it without re-encoding
+	top := rungs[len(rungs)-1]
+	webVideo := filepath.Join(workDir, "web.mp4")
+	if err := runFFmpeg(10*time.Minute, append(encodeArgs(tmpFile.Name(), top), "-movflags", "+faststart", webVideo)...); err != nil {
+		return fmt.Errorf("failed to transcode to mp4: %w", err)
+	}
+	for _, rung := range rungs {
+		var args []string
+		if rung == top {
+			args = []string{"-i", webVideo, "-map", "0", "-c", "copy"}
+		} else {
+			args = encodeArgs(tmpFile.Name(), rung)
+		}
+		args = append(args,
+			"-f", "hls",
+			"-hls_time", strconv.Itoa(hlsSegmentSeconds),
+			"-hls_playlist_type", "vod",
+			"-hls_segment_filename", filepath.Join(workDir, fmt.Sprintf("%dp_%%03d.ts", rung)),
+			filepath.Join(workDir, fmt.Sprintf("%dp.m3u8", rung)),
+		)
+		if err := runFFmpeg(10*time.Minute, args...); err != nil {
+			return fmt.Errorf("failed to create %dp hls rendition: %w", rung, err)
+		}
+	}
+	if err := writeMasterPlaylist(workDir, rungs); err != nil {
+		return err
+	}
+
+	if err := os.Rename(webVideo, filepath.Join(MediaDir, media.WebVideoPath())); err != nil {
+		return fmt.Errorf("failed to save mp4: %w", err)
+	}
+	if err := os.RemoveAll(hlsDir); err != nil {
+		return err
+	}
+	if err := os.Rename(workDir, hlsDir); err != nil {
+		return fmt.Errorf("failed to save hls files: %w", err)
+	}
+
+	return nil
+}
+
+// hlsRungs returns the short side lengths of the HLS renditions for a video with the given short side.
+// Videos are never scaled up: configured heights above the source are replaced by the source itself.
+func (s *TranscodeService) hlsRungs(short int) ([]int, error) {
+	if short < 2 {
+		return nil, fmt.Errorf("failed to transcode video: unknown dimensions")
+	}
+
+	var rungs []int
+	heights := s.config.HlsHeights
+	for _, height := range heights {
+		if height < short {
+			rungs = append(rungs, height&^1)
+		}
+	}
+	top := short
+	if len(heights) > 0 {
+		top = min(short, heights[len(heights)-1])
+	}
+	top &^= 1 // libx264 needs even dimensions
+	if len(rungs) == 0 || rungs[len(rungs)-1] != top {
+		rungs = append(rungs, top)
+	}
+	return rungs, nil
+}
+
+// encodeArgs returns the ffmpeg arguments encoding the first video and audio stream of src
+// to H.264/AAC with the short side scaled to the given size. Keyframes are forced every 2 seconds,
+// so the result can be cut into HLS segments.
+func encodeArgs(src string, short int) []string {
+	// Scale the short side, portrait videos keep their orientation (ffmpeg applies the rotation first)
+	scale := fmt.Sprintf("scale='if(gt(iw,ih),-2,%[1]d)':'if(gt(iw,ih),%[1]d,-2)',format=yuv420p", short)
+	maxrate := max(800, short*5) // kbit/s
+	return []string{
+		"-i", src,
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-vf", scale,
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-profile:v", "high",
+		"-maxrate", fmt.Sprintf("%dk", maxrate), "-bufsize", fmt.Sprintf("%dk", 2*maxrate),
+		"-force_key_frames", "expr:gte(t,n_forced*2)",
+		"-c:a", "aac", "-b:a", "128k", "-ac", "2",
+	}
+}
+
+// hlsVariant is a rendition listed in the HLS master playlist.
+type hlsVariant struct {
+	name          string // variant playlist, e.g. 720p.m3u8
+	bandwidth     int    // peak bit/s
+	width, height int
+}
+
+// writeMasterPlaylist writes the HLS master playlist for the renditions in dir.
+// Bandwidth and resolution are taken from the generated segments.
+func writeMasterPlaylist(dir string, rungs []int) error {
+	variants := make([]hlsVariant, len(rungs))
+	for i, rung := range rungs {
+		name := fmt.Sprintf("%dp.m3u8", rung)
+		bandwidth, err := peakBandwidth(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		video, err := probeVideo(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		variants[i] = hlsVariant{name: name, bandwidth: bandwidth, width: video.Width, height: video.Height}
+	}
+	return os.WriteFile(filepath.Join(dir, hlsMasterPlaylist), []byte(masterPlaylist(variants)), 0644)
+}
+
+// masterPlaylist returns the HLS master playlist of the variants, relative to /media/:id/stream.m3u8.
+func masterPlaylist(variants []hlsVariant) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, v := range variants {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d\nstream/%s\n", v.bandwidth, v.width, v.height, v.name)
+	}
+	return b.String()
+}
+
+// peakBandwidth returns the highest bit rate of all segments of the variant playlist.
+func peakBandwidth(playlist string) (int, error) {
+	f, err := os.Open(playlist)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	peak := 0
+	duration := 0.0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if value, ok := strings.CutPrefix(line, "#EXTINF:"); ok {
+			duration, _ = strconv.ParseFloat(strings.TrimSuffix(value, ","), 64)
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") || duration <= 0 {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(filepath.Dir(playlist), line))
+		if err != nil {
+			return 0, err
+		}
+		peak = max(peak, int(float64(info.Size()*8)/duration))
+	}
+	return peak, scanner.Err()
+}
+
+type videoInfo struct {
+	Width    int     `json:"width"`
+	Height   int     `json:"height"`
+	Duration float64 // seconds, 0 if unknown
+}
+
+// probeVideo returns the dimensions of the first video stream as displayed, i.e. with rotation applied,
+// and the duration of the video.
+func probeVideo(path string) (*videoInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height:stream_side_data=rotation:format=duration",
+		"-of", "json",
+		path,
+	).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe video: %w", err)
+	}
+
+	var probe struct {
+		Streams []struct {
+			videoInfo
+			SideData []struct {
+				Rotation int `json:"rotation"`
+			} `json:"side_data_list"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil || len(probe.Streams) == 0 {
+		return nil, fmt.Errorf("failed to probe video: no video stream found")
+	}
+
+	info := probe.Streams[0].videoInfo
+	info.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	for _, side := range probe.Streams[0].SideData {
+		if side.Rotation == 90 || side.Rotation == -90 || side.Rotation == 270 || side.Rotation == -270 {
+			info.Width, info.Height = info.Height, info.Width
+		}
+	}
+	return &info, nil
+}
+
+func runFFmpeg(timeout time.Duration, args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "ffmpeg", append([]string{"-y", "-loglevel", "error"}, args...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"embox/internal/config"
+	"embox/internal/models"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestHlsRungs(t *testing.T) {
+	s := &TranscodeService{config: &config.MediaConfig{HlsHeights: []int{360, 720, 1080}}}
+	for _, tc := range []struct {
+		short int
+		want  []int
+	}{
+		{2160, []int{360, 720, 1080}},
+		{1080, []int{360, 720, 1080}},
+		// Never scaled up, the source is the top rung
+		{800, []int{360, 720, 800}},
+		{720, []int{360, 720}},
+		{240, []int{240}},
+		// libx264 needs even dimensions
+		{721, []int{360, 720}},
+		{239, []int{238}},
+	} {
+		got, err := s.hlsRungs(tc.short)
+		if err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("hlsRungs(%d) = %v, %v, want %v", tc.short, got, err, tc.want)
+		}
+	}
+
+	// ffprobe found no dimensions
+	for _, short := range []int{0, 1} {
+		if got, err := s.hlsRungs(short); err == nil {
+			t.Errorf("hlsRungs(%d) = %v, expected error", short, got)
+		}
+	}
+
+	s.config.HlsHeights = nil
+	if got, err := s.hlsRungs(480); err != nil || !slices.Equal(got, []int{480}) {
+		t.Errorf("hlsRungs without heights = %v, %v, want [480]", got, err)
+	}
+}
+
+func TestMasterPlaylist(t *testing.T) {
+	got := masterPlaylist([]hlsVariant{
+		{name: "360p.m3u8", bandwidth: 1200000, width: 640, height: 360},
+		{name: "720p.m3u8", bandwidth: 3600000, width: 1280, height: 720},
+	})
+	want := "#EXTM3U\n#EXT-X-VERSION:3\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=1200000,RESOLUTION=640x360\nstream/360p.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=3600000,RESOLUTION=1280x720\nstream/720p.m3u8\n"
+	if got != want {
+		t.Errorf("masterPlaylist:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPeakBandwidth(t *testing.T) {
+	dir := t.TempDir()
+	for name, size := range map[string]int{"720p_000.ts": 6000, "720p_001.ts": 4000} {
+		if err := os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	playlist := filepath.Join(dir, "720p.m3u8")
+	os.WriteFile(playlist, []byte("#EXTM3U\n#EXT-X-TARGETDURATION:6\n"+
+		"#EXTINF:6.000000,\n720p_000.ts\n"+
+		"#EXTINF:2.000000,\n720p_001.ts\n"+
+		"#EXT-X-ENDLIST\n"), 0644)
+
+	// 6000 bytes in 6s are 8000 bit/s, 4000 bytes in 2s 16000 bit/s
+	if got, err := peakBandwidth(playlist); err != nil || got != 16000 {
+		t.Errorf("peakBandwidth = %d, %v, want 16000", got, err)
+	}
+
+	os.Remove(filepath.Join(dir, "720p_001.ts"))
+	if _, err := peakBandwidth(playlist); err == nil {
+		t.Error("expected error for missing segment")
+	}
+}
+
+func TestCanTranscode(t *testing.T) {
+	s := &MediaService{mediaConfig: &config.MediaConfig{Transcode: true}}
+	for _, tc := range []struct {
+		media models.Media
+		want  bool
+	}{
+		{models.Media{Type: "video"}, true},
+		{models.Media{Type: "image"}, false},
+		{models.Media{Type: "video", TranscodeStatus: TranscodePending}, false},
+		{models.Media{Type: "video", TranscodeStatus: TranscodeDone, TranscodeTries: 1}, false},
+		// Failed transcodings are retried up to the limit
+		{models.Media{Type: "video", TranscodeStatus: TranscodeFailed, TranscodeTries: 1}, true},
+		{models.Media{Type: "video", TranscodeStatus: TranscodeFailed, TranscodeTries: maxTranscodeTries}, false},
+	} {
+		if got := s.CanTranscode(&tc.media); got != tc.want {
+			t.Errorf("CanTranscode(%s, %q, %d tries) = %v, want %v",
+				tc.media.Type, tc.media.TranscodeStatus, tc.media.TranscodeTries, got, tc.want)
+		}
+	}
+
+	s.mediaConfig.Transcode = false
+	if s.CanTranscode(&models.Media{Type: "video"}) {
+		t.Error("expected no transcoding when disabled")
+	}
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"embox/internal/api/dto"
+	"embox/internal/config"
+	"embox/internal/repositories"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TrashService lists and restores deleted media and albums
+// and permanently deletes them once the retention period has passed.
+type TrashService struct {
+	config       *config.TrashConfig
+	userRepo     repositories.UserRepository
+	mediaRepo    repositories.MediaRepository
+	albumRepo    repositories.AlbumRepository
+	mediaService *MediaService
+}
+
+func NewTrashService(cfg *config.TrashConfig, userRepo repositories.UserRepository, mediaRepo repositories.MediaRepository, albumRepo repositories.AlbumRepository, mediaService *MediaService) *TrashService {
+	return &TrashService{cfg, userRepo, mediaRepo, albumRepo, mediaService}
+}
+
+// GetTrash returns the media and albums of the user in the trash, latest deleted first.
+// Admins see the trash of all users.
+func (s *TrashService) GetTrash(userEmail string) (*dto.TrashResponseDto, error) {
+	user, err := s.userRepo.GetByEmail(userEmail)
+	if err != nil || user == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	var owner *uuid.UUID
+	if !user.IsAdmin {
+		owner = &user.ID
+	}
+
+	mediaList, err := s.mediaRepo.GetTrashed(time.Now(), owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch media in trash: %w", err)
+	}
+	albums, err := s.albumRepo.GetTrashed(time.Now(), owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch albums in trash: %w", err)
+	}
+
+	result := &dto.TrashResponseDto{
+		Media:  []dto.TrashMediaDto{},
+		Albums: []dto.TrashAlbumDto{},
+	}
+	for _, media := range mediaList {
+		result.Media = append(result.Media, dto.TrashMediaDto{
+			MediaResponseDto: dto.MediaResponseDto{
+				Id:        media.ID,
+				Caption:   media.Caption,
+				Date:      media.Date.Format(time.RFC3339),
+				Type:      media.Type,
+				CreatedAt: media.CreatedAt,
+				IsBroken:  media.IsBroken,
+				Place:     media.Place,
+				Country:   media.Country,
+			},
+			DeletedAt: media.DeletedAt.Time,
+			PurgeAt:   media.DeletedAt.Time.Add(s.retention()),
+		})
+	}
+	for _, album := range albums {
+		result.Albums = append(result.Albums, dto.TrashAlbumDto{
+			Id:          album.ID,
+			Name:        album.Name,
+			Description: album.Description,
+			MediaCount:  album.MediaCount,
+			DeletedAt:   album.DeletedAt.Time,
+			PurgeAt:     album.DeletedAt.Time.Add(s.retention()),
+		})
+	}
+
+	return result, nil
+}
+
+// Restore moves the given media and albums out of the trash.
+func (s *TrashService) Restore(req dto.TrashRestoreRequestDto) error {
+	if len(req.MediaIDs) > 0 {
+		if err := s.mediaRepo.Restore(req.MediaIDs); err != nil {
+			return fmt.Errorf("failed to restore media: %w", err)
+		}
+	}
+	if len(req.AlbumIDs) > 0 {
+		if err := s.albumRepo.Restore(req.AlbumIDs); err != nil {
+			return fmt.Errorf("failed to restore albums: %w", err)
+		}
+	}
+	return nil
+}
+
+// IsOwnerOfAll reports whether the user owns all given media and albums in the trash.
+// Admins own everything.
+func (s *TrashService) IsOwnerOfAll(userEmail string, req dto.TrashRestoreRequestDto) (bool, error) {
+	user, err := s.userRepo.GetByEmail(userEmail)
+	if err != nil || user == nil {
+		return false, fmt.Errorf("user not found")
+	}
+	if user.IsAdmin {
+		return true, nil
+	}
+
+	mediaList, err := s.mediaRepo.GetTrashedByIDs(req.MediaIDs)
+	if err != nil {
+		return false, err
+	}
+	for _, m := range mediaList {
+		if m.UserID == nil || *m.UserID != user.ID {
+			return false, nil
+		}
+	}
+
+	albums, err := s.albumRepo.GetTrashedByIDs(req.AlbumIDs)
+	if err != nil {
+		return false, err
+	}
+	for _, a := range albums {
+		if a.UserID == nil || *a.UserID != user.ID {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Purge permanently deletes all media and albums that were deleted before the given time.
+// It returns the number of purged media and albums.
+func (s *TrashService) Purge(before time.Time) (int, int, error) {
+	mediaList, err := s.mediaRepo.GetTrashed(before, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to fetch media in trash: %w", err)
+	}
+	if err := s.mediaService.PurgeMedia(mediaList); err != nil {
+		return 0, 0, fmt.Errorf("failed to purge media: %w", err)
+	}
+
+	albums, err := s.albumRepo.GetTrashed(before, nil)
+	if err != nil {
+		return len(mediaList), 0, fmt.Errorf("failed to fetch albums in trash: %w", err)
+	}
+	var albumIDs []uint
+	for _, album := range albums {
+		albumIDs = append(albumIDs, album.ID)
+	}
+	if len(albumIDs) > 0 {
+		if err := s.albumRepo.Purge(albumIDs); err != nil {
+			return len(mediaList), 0, fmt.Errorf("failed to purge albums: %w", err)
+		}
+	}
+
+	return len(mediaList), len(albumIDs), nil
+}
+
+// StartPurgeJob purges expired items from the trash in the background, every PurgeInterval seconds.
+func (s *TrashService) StartPurgeJob() {
+	if s.config.PurgeInterval <= 0 {
+		return
+	}
+
+	go func() {
+		for {
+			media, albums, err := s.Purge(time.Now().Add(-s.retention()))
+			if err != nil {
+				slog.Error("failed to purge trash", "err", err)
+			} else if media > 0 || albums > 0 {
+				slog.Info("purged trash", "media", media, "albums", albums)
+			}
+			time.Sleep(time.Duration(s.config.PurgeInterval) * time.Second)
+		}
+	}()
+}
+
+func (s *TrashService) retention() time.Duration {
+	return time.Duration(s.config.Retention) * 24 * time.Hour
+}
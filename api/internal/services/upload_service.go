@@ -0,0 +1,301 @@
+package services
+
+import (
+	"embox/internal/api/dto"
+	"embox/internal/config"
+	"embox/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxFileSize is the maximum size of a single uploaded file.
+const MaxFileSize = 500 << 20 // 500 MB
+
+// uploadCleanupInterval is the time between two runs of the cleanup of expired uploads.
+const uploadCleanupInterval = time.Hour
+
+var (
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadOffsetMismatch = errors.New("upload offset mismatch")
+	ErrUploadLocked         = errors.New("upload is locked by another request")
+	ErrUploadTooLarge       = errors.New("upload exceeds maximum file size")
+)
+
+// UploadService implements resumable uploads (tus 1.0).
+// Chunks are assembled in the staging directory as <id>.bin, the upload state is kept in <id>.info.
+// Once all bytes have arrived, the file is handed to MediaService.CreateFromRequest.
+type UploadService struct {
+	config       *config.UploadConfig
+	mediaService *MediaService
+
+	locksMu sync.Mutex
+	locks   map[string]bool
+}
+
+// UploadInfo is the persisted state of a resumable upload.
+type UploadInfo struct {
+	ID        string                    `json:"id"`
+	Length    int64                     `json:"length"`
+	Offset    int64                     `json:"-"` // derived from the size of the data file
+	Meta      dto.MediaUploadRequestDto `json:"meta"`
+	UserEmail string                    `json:"userEmail"`
+	ExpiresAt time.Time                 `json:"expiresAt"`
+}
+
+func NewUploadService(cfg *config.UploadConfig, mediaService *MediaService) *UploadService {
+	_ = os.MkdirAll(cfg.StagingDir, 0755)
+	return &UploadService{
+		config:       cfg,
+		mediaService: mediaService,
+		locks:        make(map[string]bool),
+	}
+}
+
+// Create registers a new upload of the given length and returns its state.
+func (s *UploadService) Create(length int64, meta dto.MediaUploadRequestDto, userEmail string) (*UploadInfo, error) {
+	if length > MaxFileSize {
+		return nil, ErrUploadTooLarge
+	}
+
+	s.RemoveExpired()
+
+	info := &UploadInfo{
+		ID:        strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Length:    length,
+		Meta:      meta,
+		UserEmail: userEmail,
+		ExpiresAt: time.Now().Add(time.Duration(s.config.Expiration) * time.Second),
+	}
+
+	if err := os.WriteFile(s.dataPath(info.ID), nil, 0644); err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %w", err)
+	}
+	if err := s.saveInfo(info); err != nil {
+		os.Remove(s.dataPath(info.ID))
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// Get returns the state of an upload owned by the given user.
+func (s *UploadService) Get(id, userEmail string) (*UploadInfo, error) {
+	info, err := s.loadInfo(id)
+	if err != nil {
+		return nil, err
+	}
+	if info.UserEmail != userEmail {
+		return nil, ErrUploadNotFound
+	}
+	if time.Now().After(info.ExpiresAt) {
+		s.remove(id)
+		return nil, ErrUploadNotFound
+	}
+
+	stat, err := os.Stat(s.dataPath(id))
+	if err != nil {
+		return nil, ErrUploadNotFound
+	}
+	info.Offset = stat.Size()
+
+	return info, nil
+}
+
+// WriteChunk appends the data from r at the given offset.
+// When the upload is complete, the media is created and returned; otherwise the returned media is nil.
+func (s *UploadService) WriteChunk(id, userEmail string, offset int64, r io.Reader) (*UploadInfo, *models.Media, error) {
+	if !s.lock(id) {
+		return nil, nil, ErrUploadLocked
+	}
+	defer s.unlock(id)
+
+	info, err := s.Get(id, userEmail)
+	if err != nil {
+		return nil, nil, err
+	}
+	if offset != info.Offset {
+		return info, nil, ErrUploadOffsetMismatch
+	}
+
+	f, err := os.OpenFile(s.dataPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open upload file: %w", err)
+	}
+
+	// Bytes received before an interrupted request are kept, so the client can resume from there
+	written, copyErr := io.Copy(f, io.LimitReader(r, info.Length-info.Offset))
+	closeErr := f.Close()
+	info.Offset += written
+	if copyErr != nil {
+		return info, nil, fmt.Errorf("failed to write upload chunk: %w", copyErr)
+	}
+	if closeErr != nil {
+		return info, nil, fmt.Errorf("failed to write upload chunk: %w", closeErr)
+	}
+
+	if info.Offset < info.Length {
+		return info, nil, nil
+	}
+
+	media, err := s.finish(info)
+	if err != nil {
+		return info, nil, err
+	}
+
+	return info, media, nil
+}
+
+// Terminate removes an upload and its data.
+func (s *UploadService) Terminate(id, userEmail string) error {
+	if !s.lock(id) {
+		return ErrUploadLocked
+	}
+	defer s.unlock(id)
+
+	if _, err := s.Get(id, userEmail); err != nil {
+		return err
+	}
+	s.remove(id)
+	return nil
+}
+
+// finish hands the assembled file to the media pipeline and removes the staging files on success.
+// On failure the files are kept, so the client can retry the final PATCH. Uploads rejected for good,
+// as duplicates or for their type or date, are removed.
+func (s *UploadService) finish(info *UploadInfo) (*models.Media, error) {
+	f, err := os.Open(s.dataPath(info.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload file: %w", err)
+	}
+	defer f.Close()
+
+	media, err := s.mediaService.CreateFromRequest(info.Meta, f, info.UserEmail)
+	if err != nil {
+		var duplicateErr *DuplicateError
+		if errors.As(err, &duplicateErr) || errors.Is(err, ErrTypeMismatch) || errors.Is(err, ErrInvalidDate) {
+			s.remove(info.ID)
+		}
+		return nil, fmt.Errorf("failed to save media: %w", err)
+	}
+
+	s.remove(info.ID)
+	return media, nil
+}
+
+// RemoveExpired deletes all uploads whose expiration date has passed.
+func (s *UploadService) RemoveExpired() {
+	entries, err := os.ReadDir(s.config.StagingDir)
+	if err != nil {
+		slog.Error("failed to read upload staging dir", "dir", s.config.StagingDir, "err", err)
+		return
+	}
+
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".info")
+		if !ok {
+			continue
+		}
+		info, err := s.loadInfo(id)
+		if err != nil || time.Now().After(info.ExpiresAt) {
+			if s.lock(id) {
+				s.remove(id)
+				s.unlock(id)
+			}
+		}
+	}
+}
+
+// StartCleanupJob removes expired uploads in the background, every uploadCleanupInterval.
+// Unfinished uploads are also removed when new ones are created, but they may stop before the last one expires.
+func (s *UploadService) StartCleanupJob() {
+	go func() {
+		for {
+			time.Sleep(uploadCleanupInterval)
+			s.RemoveExpired()
+		}
+	}()
+}
+
+func (s *UploadService) loadInfo(id string) (*UploadInfo, error) {
+	if !isValidUploadID(id) {
+		return nil, ErrUploadNotFound
+	}
+
+	data, err := os.ReadFile(s.infoPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, fmt.Errorf("failed to read upload info: %w", err)
+	}
+
+	var info UploadInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to decode upload info: %w", err)
+	}
+
+	return &info, nil
+}
+
+func (s *UploadService) saveInfo(info *UploadInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("failed to encode upload info: %w", err)
+	}
+	if err := os.WriteFile(s.infoPath(info.ID), data, 0644); err != nil {
+		return fmt.Errorf("failed to write upload info: %w", err)
+	}
+	return nil
+}
+
+func (s *UploadService) remove(id string) {
+	_ = os.Remove(s.dataPath(id))
+	_ = os.Remove(s.infoPath(id))
+}
+
+func (s *UploadService) lock(id string) bool {
+	s.locksMu.Lock()
+	defer s.locksMu.Unlock()
+	if s.locks[id] {
+		return false
+	}
+	s.locks[id] = true
+	return true
+}
+
+func (s *UploadService) unlock(id string) {
+	s.locksMu.Lock()
+	defer s.locksMu.Unlock()
+	delete(s.locks, id)
+}
+
+func (s *UploadService) dataPath(id string) string {
+	return filepath.Join(s.config.StagingDir, id+".bin")
+}
+
+func (s *UploadService) infoPath(id string) string {
+	return filepath.Join(s.config.StagingDir, id+".info")
+}
+
+// isValidUploadID guards against path traversal via the upload ID in the URL.
+func isValidUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
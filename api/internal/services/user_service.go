@@ -132,6 +132,19 @@ func (s *UserService) GetUserByEmail(email string) (*dto.UserResponse, error) {
 	return result, nil
 }
 
+// IsAdmin reports whether the user with the given email is an admin
+func (s *UserService) IsAdmin(email string) (bool, error) {
+	user, err := s.userRepo.GetByEmail(email)
+	if err != nil {
+		return false, err
+	}
+	if user == nil {
+		return false, fmt.Errorf("user not found")
+	}
+
+	return user.IsAdmin, nil
+}
+
 // UpdateUser updates an existing user
 func (s *UserService) UpdateUser(id uuid.UUID, req dto.UpdateUserRequest) (*dto.UserResponse, error) {
 	existingUser, err := s.userRepo.GetById(id)
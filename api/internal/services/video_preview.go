@@ -0,0 +1,263 @@
+package services
+
+import (
+	"embox/internal/models"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Preview files of videos and audio files, as requested below /media/:id/
+const (
+	PreviewFileAnimation = "preview"
+	PreviewFileSprites   = "sprites.jpg"
+	PreviewFileSpriteVtt = "sprites.vtt"
+	PreviewFileWaveform  = "waveform"
+)
+
+const (
+	posterCandidateFrames = 120 // frames the poster is chosen from, about 4s at 30fps
+
+	previewClips       = 4   // excerpts of the animated preview, spread over the video
+	previewClipSeconds = 1.5 // length of each excerpt
+	previewSize        = 320 // long side of the animated preview
+	previewFps         = 10
+
+	spriteMaxFrames = 100 // frames of the sprite sheet, longer videos get a larger interval
+	spriteColumns   = 10
+	spriteSize      = 160 // long side of a sprite
+)
+
+// GetPreviewFile returns the local path of the animated preview, the sprite sheet
+// or the WebVTT file of the sprites of a video, or of the waveform of an audio file.
+func (s *MediaService) GetPreviewFile(id uint, name string) (string, error) {
+	media, err := s.mediaRepo.GetById(id)
+	if err != nil {
+		return "", fmt.Errorf("failed to find media with id %d: %w", id, err)
+	}
+	if media == nil {
+		return "", fmt.Errorf("media with id %d not found", id)
+	}
+
+	var path string
+	switch name {
+	case PreviewFileAnimation:
+		path = media.PreviewPath()
+	case PreviewFileSprites:
+		path = media.SpritesPath()
+	case PreviewFileSpriteVtt:
+		path = media.SpritesVttPath()
+	case PreviewFileWaveform:
+		path = media.WaveformPath()
+	default:
+		return "", fmt.Errorf("invalid preview file %q", name)
+	}
+
+	filePath := filepath.Join(MediaDir, path)
+	if _, err := os.Stat(filePath); err != nil {
+		return "", err
+	}
+	return filePath, nil
+}
+
+// MissingVideoPreviews reports whether the animated preview or the sprites of a video are missing.
+func (s *MediaService) MissingVideoPreviews(media *models.Media) bool {
+	if media.Type != "video" {
+		return false
+	}
+	for _, path := range []string{media.PreviewPath(), media.SpritesPath(), media.SpritesVttPath()} {
+		if _, err := os.Stat(filepath.Join(MediaDir, path)); err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// RegenerateVideoPreviews downloads the original video and recreates the animated preview and the sprites.
+func (s *MediaService) RegenerateVideoPreviews(media *models.Media) error {
+	resp, err := s.storage.DownloadStream(media.RemotePath(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to download original file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	tmpFile, _, err := spoolToTempFile(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Close()
+
+	return createVideoPreviews(media, tmpFile.Name())
+}
+
+// createVideoPreviews creates the animated preview and the sprites of a video.
+func createVideoPreviews(media *models.Media, videoPath string) error {
+	info, err := probeVideo(videoPath)
+	if err != nil {
+		return err
+	}
+	if info.Duration <= 0 {
+		return fmt.Errorf("failed to create video preview: unknown duration")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filepath.Join(MediaDir, media.BasePath())), 0755); err != nil {
+		return err
+	}
+	if err := createAnimatedPreview(videoPath, info.Duration, filepath.Join(MediaDir, media.PreviewPath())); err != nil {
+		return err
+	}
+	return createSprites(videoPath, info.Duration, filepath.Join(MediaDir, media.SpritesPath()), filepath.Join(MediaDir, media.SpritesVttPath()))
+}
+
+// createAnimatedPreview writes a silent, looping animated WebP made of short excerpts spread over the video.
+// Each excerpt is read with a fast input seek, so long videos are not decoded completely.
+func createAnimatedPreview(videoPath string, duration float64, dst string) error {
+	clips := previewClips
+	clipSeconds := previewClipSeconds
+	if duration < 2*previewClips*previewClipSeconds {
+		// Short videos: the beginning as a single excerpt
+		clips = 1
+		clipSeconds = min(duration, previewClips*previewClipSeconds)
+	}
+
+	var args []string
+	var filter strings.Builder
+	for i := range clips {
+		start := 0.0
+		if clips > 1 {
+			start = duration*(float64(i)+0.5)/float64(clips) - clipSeconds/2
+		}
+		args = append(args, "-ss", formatSeconds(start), "-t", formatSeconds(clipSeconds), "-i", videoPath)
+		fmt.Fprintf(&filter, "[%d:v:0]fps=%d,scale=%d:%d:force_original_aspect_ratio=decrease:force_divisible_by=2,setsar=1[v%d];",
+			i, previewFps, previewSize, previewSize, i)
+	}
+	for i := range clips {
+		fmt.Fprintf(&filter, "[v%d]", i)
+	}
+	fmt.Fprintf(&filter, "concat=n=%d:v=1:a=0[out]", clips)
+
+	tmp, err := createTempPath(dst)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	args = append(args,
+		"-filter_complex", filter.String(),
+		"-map", "[out]", "-an",
+		"-c:v", "libwebp", "-lossless", "0", "-q:v", "60", "-loop", "0",
+		"-f", "webp", tmp,
+	)
+	if err := runFFmpeg(2*time.Minute, args...); err != nil {
+		return fmt.Errorf("failed to create animated preview: %w", err)
+	}
+	return os.Rename(tmp, dst)
+}
+
+// createSprites writes a sprite sheet with frames in regular intervals and the WebVTT file
+// mapping each interval to its sprite (sprites.jpg#xywh=x,y,w,h), as used by seek bar previews.
+// Only keyframes are decoded, so the frames are approximate but long videos are processed quickly.
+func createSprites(videoPath string, duration float64, dst, vttDst string) error {
+	grid := newSpriteGrid(duration)
+	interval, columns, rows := grid.interval, grid.columns, grid.rows
+
+	tmp, err := createTempPath(dst)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	err = runFFmpeg(5*time.Minute,
+		"-skip_frame", "nokey",
+		"-i", videoPath,
+		"-an",
+		"-vf", fmt.Sprintf("fps=1/%s,scale=%d:%d:force_original_aspect_ratio=decrease:force_divisible_by=2,tile=%dx%d",
+			formatSeconds(interval), spriteSize, spriteSize, columns, rows),
+		"-frames:v", "1", "-q:v", "5",
+		"-f", "image2", "-c:v", "mjpeg", tmp,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create sprites: %w", err)
+	}
+
+	f, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+	sheet, _, err := image.DecodeConfig(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read sprites: %w", err)
+	}
+	vtt := grid.vtt(duration, sheet.Width/columns, sheet.Height/rows)
+
+	if err := os.Rename(tmp, dst); err != nil {
+		return err
+	}
+	vttTmp, err := createTempPath(vttDst)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(vttTmp)
+	if err := os.WriteFile(vttTmp, []byte(vtt), 0644); err != nil {
+		return err
+	}
+	return os.Rename(vttTmp, vttDst)
+}
+
+// spriteGrid is the layout of a sprite sheet: one frame every interval seconds,
+// columns frames per row, filled row by row.
+type spriteGrid struct {
+	interval float64
+	frames   int
+	columns  int
+	rows     int
+}
+
+// newSpriteGrid returns the sprite layout of a video: one frame per second,
+// a larger interval in whole seconds for videos longer than spriteMaxFrames seconds.
+func newSpriteGrid(duration float64) spriteGrid {
+	interval := max(1, math.Ceil(duration/spriteMaxFrames))
+	frames := max(1, int(math.Ceil(duration/interval)))
+	columns := min(frames, spriteColumns)
+	return spriteGrid{interval: interval, frames: frames, columns: columns, rows: (frames + columns - 1) / columns}
+}
+
+// vtt returns the WebVTT file mapping each interval to its sprite of width x height pixels.
+func (g spriteGrid) vtt(duration float64, width, height int) string {
+	var vtt strings.Builder
+	vtt.WriteString("WEBVTT\n")
+	for i := range g.frames {
+		start := float64(i) * g.interval
+		end := min(start+g.interval, duration)
+		fmt.Fprintf(&vtt, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(start), vttTimestamp(end), PreviewFileSprites,
+			(i%g.columns)*width, (i/g.columns)*height, width, height)
+	}
+	return vtt.String()
+}
+
+// createTempPath creates an empty temp file next to dst and returns its path. Files are written to it
+// and renamed to dst, so concurrent writers never see or remove each other's partial output.
+func createTempPath(dst string) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmp.Close()
+	return tmp.Name(), nil
+}
+
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
+}
+
+// vttTimestamp formats seconds as a WebVTT timestamp: hh:mm:ss.ttt
+func vttTimestamp(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
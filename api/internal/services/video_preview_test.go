@@ -0,0 +1,59 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewSpriteGrid(t *testing.T) {
+	for _, tc := range []struct {
+		duration float64
+		want     spriteGrid
+	}{
+		{0.4, spriteGrid{interval: 1, frames: 1, columns: 1, rows: 1}},
+		{7.2, spriteGrid{interval: 1, frames: 8, columns: 8, rows: 1}},
+		{25, spriteGrid{interval: 1, frames: 25, columns: 10, rows: 3}},
+		{100, spriteGrid{interval: 1, frames: 100, columns: 10, rows: 10}},
+		// Longer videos get a larger interval in whole seconds, never more than spriteMaxFrames frames
+		{101, spriteGrid{interval: 2, frames: 51, columns: 10, rows: 6}},
+		{3600, spriteGrid{interval: 36, frames: 100, columns: 10, rows: 10}},
+	} {
+		if got := newSpriteGrid(tc.duration); got != tc.want {
+			t.Errorf("newSpriteGrid(%v) = %+v, want %+v", tc.duration, got, tc.want)
+		}
+	}
+}
+
+func TestSpriteGridVtt(t *testing.T) {
+	grid := newSpriteGrid(12.5)
+	vtt := grid.vtt(12.5, 160, 90)
+
+	cues := strings.Split(strings.TrimPrefix(vtt, "WEBVTT\n\n"), "\n\n")
+	if !strings.HasPrefix(vtt, "WEBVTT\n") || len(cues) != 13 {
+		t.Fatalf("expected 13 cues, got %q", vtt)
+	}
+	for i, want := range map[int]string{
+		0:  "00:00:00.000 --> 00:00:01.000\nsprites.jpg#xywh=0,0,160,90",
+		9:  "00:00:09.000 --> 00:00:10.000\nsprites.jpg#xywh=1440,0,160,90",
+		10: "00:00:10.000 --> 00:00:11.000\nsprites.jpg#xywh=0,90,160,90",
+		// The last cue ends with the video
+		12: "00:00:12.000 --> 00:00:12.500\nsprites.jpg#xywh=320,90,160,90\n",
+	} {
+		if cues[i] != want {
+			t.Errorf("cue %d: expected %q, got %q", i, want, cues[i])
+		}
+	}
+}
+
+func TestVttTimestamp(t *testing.T) {
+	for seconds, want := range map[float64]string{
+		0:        "00:00:00.000",
+		1.2345:   "00:00:01.235",
+		59.9999:  "00:01:00.000",
+		3723.004: "01:02:03.004",
+	} {
+		if got := vttTimestamp(seconds); got != want {
+			t.Errorf("vttTimestamp(%v) = %q, want %q", seconds, got, want)
+		}
+	}
+}
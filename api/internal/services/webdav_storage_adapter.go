@@ -0,0 +1,352 @@
+package services
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"embox/internal/config"
+)
+
+// WebdavStorageAdapter stores files on a WebDAV server (LuckyCloud, Nextcloud, most NAS boxes).
+type WebdavStorageAdapter struct {
+	config *config.StorageConfig
+	client *http.Client
+
+	// Directories known to exist, so parent directories are only checked once per process
+	dirs sync.Map
+}
+
+func NewWebdavStorageAdapter(cfg *config.StorageConfig) *WebdavStorageAdapter {
+	return &WebdavStorageAdapter{
+		config: cfg,
+		client: &http.Client{},
+	}
+}
+
+func (a *WebdavStorageAdapter) Upload(r io.Reader, size int64, filePath string) error {
+	if err := a.mkdirAll(path.Dir(filePath)); err != nil {
+		return err
+	}
+
+	req, err := a.newRequest("PUT", filePath, r)
+	if err != nil {
+		return fmt.Errorf("failed to create upload request: %w", err)
+	}
+	if size >= 0 {
+		req.ContentLength = size
+	}
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(b))
+	}
+
+	return nil
+}
+
+func (a *WebdavStorageAdapter) Download(path string) ([]byte, string, error) {
+	resp, err := a.DownloadStream(path, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read stream data: %w", err)
+	}
+
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+// DownloadStream returns an http.Response for the file at path.
+// Range and conditional headers are passed through, so the response may be a 206 Partial Content.
+// The caller is responsible for closing the response body.
+func (a *WebdavStorageAdapter) DownloadStream(path string, headers http.Header) (*http.Response, error) {
+	req, err := a.newRequest("GET", path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create download request: %w", err)
+	}
+	for _, key := range []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"} {
+		if value := headers.Get(key); value != "" {
+			req.Header.Set(key, value)
+		}
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download stream: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusNotModified, http.StatusRequestedRangeNotSatisfiable:
+		return resp, nil
+	default:
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("stream download failed with status %d: %s", resp.StatusCode, string(b))
+	}
+}
+
+func (a *WebdavStorageAdapter) Delete(filePath string) error {
+	req, err := a.newRequest("DELETE", filePath, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create delete request: %w", err)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute delete request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("delete failed with status %d: %s", resp.StatusCode, string(b))
+	}
+
+	return nil
+}
+
+func (a *WebdavStorageAdapter) Move(srcPath, dstPath string) error {
+	if err := a.mkdirAll(path.Dir(dstPath)); err != nil {
+		return err
+	}
+
+	req, err := a.newRequest("MOVE", srcPath, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create move request: %w", err)
+	}
+	destination, err := a.url(dstPath)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Destination", destination)
+	req.Header.Set("Overwrite", "F")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute move request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("move failed with status %d: %s", resp.StatusCode, string(b))
+	}
+
+	return nil
+}
+
+// Exists checks via PROPFIND whether a file or directory exists.
+func (a *WebdavStorageAdapter) Exists(filePath string) (bool, error) {
+	req, err := a.newRequest("PROPFIND", filePath, strings.NewReader(
+		`<?xml version="1.0" encoding="utf-8"?><d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/></d:prop></d:propfind>`))
+	if err != nil {
+		return false, fmt.Errorf("failed to create propfind request: %w", err)
+	}
+	req.Header.Set("Depth", "0")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to execute propfind request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusMultiStatus, http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("propfind failed with status %d", resp.StatusCode)
+	}
+}
+
+// List returns all files below the base URL.
+// Directories are walked one level at a time, as many servers reject PROPFIND with Depth: infinity.
+func (a *WebdavStorageAdapter) List() ([]string, error) {
+	base, err := url.Parse(a.config.WebdavUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebDAV url: %w", err)
+	}
+	basePath := strings.Trim(base.Path, "/")
+
+	var files []string
+	dirs := []string{""}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+
+		entries, err := a.propfindChildren(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			hrefURL, err := url.Parse(entry.Href)
+			if err != nil {
+				return nil, fmt.Errorf("invalid href %q in propfind response: %w", entry.Href, err)
+			}
+			rel := strings.Trim(hrefURL.Path, "/")
+			if basePath != "" {
+				var ok bool
+				if rel, ok = strings.CutPrefix(rel, basePath+"/"); !ok {
+					continue // the base collection itself
+				}
+			}
+			if rel == "" || rel == dir {
+				continue
+			}
+
+			if entry.isCollection() {
+				dirs = append(dirs, rel)
+			} else {
+				files = append(files, rel)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+type davResponse struct {
+	Href     string `xml:"href"`
+	Propstat []struct {
+		Prop struct {
+			ResourceType struct {
+				Collection *struct{} `xml:"collection"`
+			} `xml:"resourcetype"`
+		} `xml:"prop"`
+	} `xml:"propstat"`
+}
+
+func (r davResponse) isCollection() bool {
+	for _, propstat := range r.Propstat {
+		if propstat.Prop.ResourceType.Collection != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// propfindChildren returns the PROPFIND entries of dir and its direct children.
+func (a *WebdavStorageAdapter) propfindChildren(dir string) ([]davResponse, error) {
+	req, err := a.newRequest("PROPFIND", dir+"/", strings.NewReader(
+		`<?xml version="1.0" encoding="utf-8"?><d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/></d:prop></d:propfind>`))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create propfind request: %w", err)
+	}
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute propfind request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("propfind %s failed with status %d: %s", dir, resp.StatusCode, string(b))
+	}
+
+	var result struct {
+		Responses []davResponse `xml:"response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode propfind response: %w", err)
+	}
+
+	return result.Responses, nil
+}
+
+// mkdirAll creates dir and all missing parent directories with MKCOL.
+func (a *WebdavStorageAdapter) mkdirAll(dir string) error {
+	dir = strings.Trim(dir, "/")
+	if dir == "" || dir == "." {
+		return nil
+	}
+	if _, ok := a.dirs.Load(dir); ok {
+		return nil
+	}
+
+	exists, err := a.Exists(dir + "/")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := a.mkdirAll(path.Dir(dir)); err != nil {
+			return err
+		}
+		if err := a.mkcol(dir); err != nil {
+			return err
+		}
+	}
+
+	a.dirs.Store(dir, true)
+	return nil
+}
+
+func (a *WebdavStorageAdapter) mkcol(dir string) error {
+	req, err := a.newRequest("MKCOL", dir+"/", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create mkcol request: %w", err)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute mkcol request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 405 Method Not Allowed: the collection was created concurrently
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("mkcol %s failed with status %d: %s", dir, resp.StatusCode, string(b))
+	}
+
+	return nil
+}
+
+func (a *WebdavStorageAdapter) newRequest(method, filePath string, body io.Reader) (*http.Request, error) {
+	fileURL, err := a.url(filePath)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, fileURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(a.config.Username, a.config.Password)
+	return req, nil
+}
+
+// url returns the escaped URL of filePath below the configured base URL.
+func (a *WebdavStorageAdapter) url(filePath string) (string, error) {
+	base, err := url.Parse(strings.TrimSuffix(a.config.WebdavUrl, "/"))
+	if err != nil {
+		return "", fmt.Errorf("invalid WebDAV url: %w", err)
+	}
+	segments := strings.Split(strings.TrimPrefix(filePath, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return base.String() + "/" + strings.Join(segments, "/"), nil
+}
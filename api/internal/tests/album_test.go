@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"testing"
+	"time"
 
 	"embox/internal/models"
 )
@@ -101,7 +102,7 @@ func TestUpdateAlbum_OtherUser(t *testing.T) {
 }
 
 func TestDeleteAlbum_CascadesMedia(t *testing.T) {
-	server, db, _, teardown := SetupTestApp(t)
+	server, db, cfg, teardown := SetupTestApp(t)
 	defer teardown()
 
 	email, cookie := CreateTestUser(t, db, server)
@@ -131,12 +132,24 @@ func TestDeleteAlbum_CascadesMedia(t *testing.T) {
 		t.Error("album still present in DB after deletion")
 	}
 
-	// album_media entries must be cascade-deleted
+	// album_media entries are kept while the album is in the trash
 	var count int64
 	db.Model(&models.AlbumMedia{}).Where("album_id = ?", album.ID).Count(&count)
+	if count != 1 {
+		t.Errorf("expected 1 album_media entry while in trash, got %d", count)
+	}
+
+	// album_media entries must be cascade-deleted when the album is purged
+	if _, _, err := newTestTrashService(db, cfg).Purge(time.Now()); err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	db.Model(&models.AlbumMedia{}).Where("album_id = ?", album.ID).Count(&count)
 	if count != 0 {
 		t.Errorf("expected 0 album_media entries after cascade delete, got %d", count)
 	}
+	if err := db.First(&models.Media{}, media.ID).Error; err != nil {
+		t.Errorf("media of purged album was deleted: %v", err)
+	}
 }
 
 func TestAddMediaToAlbum(t *testing.T) {
@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"embox/internal/api/dto"
+	"embox/internal/models"
+	"embox/internal/services"
+)
+
+func TestAudio_UploadAndWaveform(t *testing.T) {
+	server, db, _, teardown := SetupTestApp(t)
+	defer teardown()
+
+	_, cookie := CreateTestUser(t, db, server)
+
+	// Audio files are accepted even if they cannot be probed
+	meta := `[{"fileName":"song.mp3","type":"audio/mpeg","date":"2024-06-01T12:00:00Z","caption":""}]`
+	resp := doMultipart(t, server, "/media/", func(w *multipart.Writer) {
+		part, _ := w.CreateFormFile("files", "song.mp3")
+		part.Write([]byte("ID3\x03\x00\x00\x00\x00\x00\x00not really an mp3"))
+		w.WriteField("meta", meta)
+	}, cookie)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload returned %d", resp.StatusCode)
+	}
+	var media models.Media
+	db.Order("id DESC").First(&media)
+	if media.Type != "audio" {
+		t.Fatalf("expected audio media, got %q", media.Type)
+	}
+
+	resp = doJSON(t, server, "GET", fmt.Sprintf("/media/%d/waveform", media.ID), "", cookie)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for missing waveform, got %d", resp.StatusCode)
+	}
+
+	waveform := `{"duration":1.5,"peaks":[0.1,0.8,0.3]}`
+	path := filepath.Join(services.MediaDir, media.WaveformPath())
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(waveform), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resp = doJSON(t, server, "GET", fmt.Sprintf("/media/%d/waveform", media.ID), "", cookie)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != waveform {
+		t.Errorf("expected waveform, got %d %q", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "application/json; charset=utf-8" {
+		t.Errorf("expected JSON content type, got %q", got)
+	}
+
+	// Audio info is part of the media list
+	db.Model(&media).Updates(map[string]any{"duration": 1.5, "bitrate": 128000, "audio_title": "Song", "audio_artist": "Band"})
+	resp = doJSON(t, server, "GET", "/media/", "", cookie)
+	defer resp.Body.Close()
+	var envelope struct {
+		Data []dto.MediaResponseDto `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		t.Fatalf("decode media list: %v", err)
+	}
+	if len(envelope.Data) != 1 {
+		t.Fatalf("expected 1 media, got %d", len(envelope.Data))
+	}
+	got := envelope.Data[0]
+	if got.Title != "Song" || got.Artist != "Band" || got.Duration != 1.5 || got.Bitrate != 128000 {
+		t.Errorf("expected audio info in media list, got %+v", got)
+	}
+}
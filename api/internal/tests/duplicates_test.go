@@ -0,0 +1,188 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"embox/internal/api/dto"
+	"embox/internal/models"
+	"embox/internal/services"
+)
+
+func TestDuplicates_GroupAndResolve(t *testing.T) {
+	server, db, cfg, teardown := SetupTestApp(t)
+	defer teardown()
+
+	email, cookie := CreateTestUser(t, db, server)
+	user := getUserFromDB(t, db, email)
+
+	// The original, a smaller re-compressed copy and an unrelated image
+	var original, copy bytes.Buffer
+	png.Encode(&original, createPatternImage(320, 240, false))
+	jpeg.Encode(&copy, createPatternImage(160, 120, false), &jpeg.Options{Quality: 40})
+	var other bytes.Buffer
+	png.Encode(&other, createPatternImage(320, 240, true))
+
+	var ids []uint
+	for i, data := range [][]byte{original.Bytes(), copy.Bytes(), other.Bytes()} {
+		ids = append(ids, uploadTestImage(t, server, fmt.Sprintf("2024-01-0%dT10:00:00Z", i+1), data, cookie))
+	}
+	originalId, copyId, otherId := ids[0], ids[1], ids[2]
+
+	groups := getDuplicateGroups(t, server, "", cookie)
+	if len(groups) != 1 || len(groups[0].Media) != 2 || groups[0].Media[0].Id != originalId || groups[0].Media[1].Id != copyId {
+		t.Fatalf("expected original and copy grouped, got %+v", groups)
+	}
+	if groups := getDuplicateGroups(t, server, "?threshold=0", cookie); len(groups) > 1 {
+		t.Errorf("expected at most the copy at threshold 0, got %+v", groups)
+	}
+	resp := doJSON(t, server, "GET", "/media/duplicates?threshold=99", "", cookie)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for threshold 99, got %d", resp.StatusCode)
+	}
+
+	// The copy is the cover of an album and a favourite, both move to the original
+	album := &models.Album{Name: "Holiday", UserID: &user.ID}
+	db.Create(album)
+	db.Create(&models.AlbumMedia{AlbumID: album.ID, MediaID: otherId})
+	db.Create(&models.AlbumMedia{AlbumID: album.ID, MediaID: copyId, IsCover: true})
+	db.Create(&models.Favourite{UserID: user.ID, MediaID: copyId, CreatedAt: time.Now()})
+
+	for _, body := range []string{
+		fmt.Sprintf(`{"keepId":%d,"ids":[%d]}`, originalId, originalId),
+		fmt.Sprintf(`{"keepId":%d,"ids":[]}`, originalId),
+	} {
+		resp := doJSON(t, server, "POST", "/media/duplicates/resolve", body, cookie)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, resp.StatusCode)
+		}
+	}
+
+	// The kept media must be the user's and the removed media similar to it
+	_, otherCookie := CreateTestUser(t, db, server)
+	var foreign bytes.Buffer
+	png.Encode(&foreign, createPatternImage(300, 225, false))
+	foreignId := uploadTestImage(t, server, "2024-01-04T10:00:00Z", foreign.Bytes(), otherCookie)
+	for body, want := range map[string]int{
+		fmt.Sprintf(`{"keepId":%d,"ids":[%d]}`, foreignId, copyId):                 http.StatusForbidden,
+		fmt.Sprintf(`{"keepId":%d,"ids":[%d]}`, originalId, otherId):               http.StatusBadRequest,
+		fmt.Sprintf(`{"keepId":%d,"ids":[%d],"threshold":99}`, originalId, copyId): http.StatusBadRequest,
+		fmt.Sprintf(`{"keepId":%d,"ids":[%d,%d]}`, originalId, copyId, otherId):    http.StatusBadRequest,
+	} {
+		resp := doJSON(t, server, "POST", "/media/duplicates/resolve", body, cookie)
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: expected %d, got %d", body, want, resp.StatusCode)
+		}
+	}
+	var remaining int64
+	db.Model(&models.Media{}).Where("id IN ?", []uint{copyId, otherId, foreignId}).Count(&remaining)
+	if remaining != 3 {
+		t.Errorf("expected rejected requests to remove nothing, %d of 3 media left", remaining)
+	}
+	// All users' media are listed, keep the foreign one out of the groups below
+	db.Delete(&models.Media{}, foreignId)
+
+	resp = doJSON(t, server, "POST", "/media/duplicates/resolve", fmt.Sprintf(`{"keepId":%d,"ids":[%d]}`, originalId, copyId), cookie)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("resolve returned %d", resp.StatusCode)
+	}
+
+	var trashed models.Media
+	if err := db.Unscoped().First(&trashed, copyId).Error; err != nil || !trashed.DeletedAt.Valid {
+		t.Errorf("expected copy in the trash, got %+v (%v)", trashed.DeletedAt, err)
+	}
+	var cover models.AlbumMedia
+	if err := db.Where("album_id = ? AND is_cover = ?", album.ID, true).First(&cover).Error; err != nil || cover.MediaID != originalId {
+		t.Errorf("expected original as album cover, got %+v (%v)", cover, err)
+	}
+	var favourites int64
+	db.Model(&models.Favourite{}).Where("user_id = ? AND media_id = ?", user.ID, originalId).Count(&favourites)
+	if favourites != 1 {
+		t.Errorf("expected original to be a favourite")
+	}
+	if groups := getDuplicateGroups(t, server, "", cookie); len(groups) != 0 {
+		t.Errorf("expected no duplicates left, got %+v", groups)
+	}
+
+	// Hashes of media uploaded before are computed from their thumbnails by the backfill
+	db.Model(&models.Media{}).Where("id = ?", otherId).Update("perceptual_hash", "")
+	report := runBackfill(t, db, cfg)
+	if !slices.Equal(report.Hashed, []uint{otherId}) {
+		t.Errorf("expected hash of media %d to be backfilled, got %v", otherId, report.Hashed)
+	}
+	if _, err := os.Stat(filepath.Join(services.MediaDir, trashed.Path())); err != nil {
+		t.Errorf("expected trashed copy to keep its thumbnail: %v", err)
+	}
+}
+
+// createPatternImage returns an image with a horizontal gradient and a bright square,
+// mirrored horizontally if inverted.
+func createPatternImage(width, height int, inverted bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			px := x
+			if inverted {
+				px = width - 1 - x
+			}
+			v := uint8(px * 200 / width)
+			if px > width/4 && px < width/2 && y > height/4 && y < height*3/4 {
+				v = 255
+			}
+			img.Set(x, y, color.RGBA{v, v / 2, 255 - v, 255})
+		}
+	}
+	return img
+}
+
+func uploadTestImage(t *testing.T, server *httptest.Server, date string, data []byte, cookie string) uint {
+	t.Helper()
+	resp := doMultipart(t, server, "/media/", func(w *multipart.Writer) {
+		part, _ := w.CreateFormFile("files", "image.png")
+		part.Write(data)
+		w.WriteField("meta", fmt.Sprintf(`[{"fileName":"image.png","type":"image/png","caption":"","date":%q}]`, date))
+	}, cookie)
+	defer resp.Body.Close()
+	var uploaded struct {
+		Data []dto.MediaResponseDto `json:"data"`
+	}
+	json.NewDecoder(resp.Body).Decode(&uploaded)
+	if resp.StatusCode != http.StatusOK || len(uploaded.Data) != 1 {
+		t.Fatalf("upload returned %d", resp.StatusCode)
+	}
+	return uploaded.Data[0].Id
+}
+
+func getDuplicateGroups(t *testing.T, server *httptest.Server, query, cookie string) []dto.DuplicateGroupDto {
+	t.Helper()
+	resp := doJSON(t, server, "GET", "/media/duplicates"+query, "", cookie)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("duplicates%s returned %d", query, resp.StatusCode)
+	}
+
+	var envelope struct {
+		Data []dto.DuplicateGroupDto `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		t.Fatalf("decode duplicates: %v", err)
+	}
+	return envelope.Data
+}
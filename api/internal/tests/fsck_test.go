@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"embox/internal/api/dto"
+	"embox/internal/models"
+	"embox/internal/services"
+)
+
+func TestFsck_NonAdminForbidden(t *testing.T) {
+	server, db, _, teardown := SetupTestApp(t)
+	defer teardown()
+
+	_, cookie := CreateTestUser(t, db, server)
+
+	resp := doJSON(t, server, "GET", "/admin/fsck", "", cookie)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403, got %d", resp.StatusCode)
+	}
+}
+
+func TestFsck_ReportAndRepair(t *testing.T) {
+	server, db, cfg, teardown := SetupTestApp(t)
+	defer teardown()
+
+	email, cookie := CreateTestUser(t, db, server)
+	user := getUserFromDB(t, db, email)
+	db.Model(user).Update("is_admin", true)
+
+	// A consistent image upload
+	meta := `[{"fileName":"test.png","type":"image/png","date":"2024-06-01T12:00:00Z","caption":""}]`
+	resp := doMultipart(t, server, "/media/", func(w *multipart.Writer) {
+		part, _ := w.CreateFormFile("files", "test.png")
+		part.Write(createTestPNG())
+		w.WriteField("meta", meta)
+	}, cookie)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload returned %d", resp.StatusCode)
+	}
+	var uploaded models.Media
+	db.Order("id DESC").First(&uploaded)
+
+	// Its thumbnail is lost, a row without any files and orphans in both locations
+	os.Remove(filepath.Join(services.MediaDir, uploaded.Path()))
+	missing := createTestMedia(t, db, &user.ID)
+	writeTestFile(t, filepath.Join(cfg.Storage.LocalDir, "2020/01/01_999.jpg"))
+	writeTestFile(t, filepath.Join(services.MediaDir, "2020/01/01_999.webp"))
+	// Files still being written are no orphans
+	writeTestFile(t, filepath.Join(cfg.Storage.LocalDir, "2020/01/01_998.jpg.part"))
+	writeTestFile(t, filepath.Join(services.MediaDir, "2020/01/01_998.webp.123.tmp"))
+	writeTestFile(t, filepath.Join(services.MediaDir, "2020/01/01_998_hls.456.tmp/360p.m3u8"))
+
+	report := runFsck(t, server, "GET", "", cookie)
+	if report.CheckedMedia != 2 {
+		t.Errorf("expected 2 checked media, got %d", report.CheckedMedia)
+	}
+	if len(report.MissingOriginals) != 1 || report.MissingOriginals[0].Id != missing.ID {
+		t.Errorf("expected media %d without original, got %+v", missing.ID, report.MissingOriginals)
+	}
+	if len(report.MissingThumbnails) != 2 {
+		t.Errorf("expected 2 missing thumbnails, got %+v", report.MissingThumbnails)
+	}
+	if len(report.OrphanFiles) != 2 {
+		t.Errorf("expected 2 orphan files, got %+v", report.OrphanFiles)
+	}
+
+	report = runFsck(t, server, "POST", `{"regenerateThumbnails":true,"deleteOrphans":true,"markBroken":true}`, cookie)
+	if len(report.RegeneratedThumbnails) != 1 || report.RegeneratedThumbnails[0] != uploaded.ID {
+		t.Errorf("expected thumbnail of media %d to be regenerated, got %v", uploaded.ID, report.RegeneratedThumbnails)
+	}
+	if len(report.DeletedOrphans) != 2 {
+		t.Errorf("expected 2 deleted orphans, got %+v", report.DeletedOrphans)
+	}
+	if len(report.MarkedBroken) != 1 || report.MarkedBroken[0] != missing.ID {
+		t.Errorf("expected media %d to be marked broken, got %v", missing.ID, report.MarkedBroken)
+	}
+
+	if _, err := os.Stat(filepath.Join(services.MediaDir, uploaded.Path())); err != nil {
+		t.Errorf("thumbnail not regenerated: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Storage.LocalDir, "2020/01/01_999.jpg")); !os.IsNotExist(err) {
+		t.Error("expected orphan original to be deleted")
+	}
+	for _, path := range []string{
+		filepath.Join(cfg.Storage.LocalDir, "2020/01/01_998.jpg.part"),
+		filepath.Join(services.MediaDir, "2020/01/01_998.webp.123.tmp"),
+		filepath.Join(services.MediaDir, "2020/01/01_998_hls.456.tmp/360p.m3u8"),
+	} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected temp file %s to be kept: %v", path, err)
+		}
+	}
+	var broken models.Media
+	db.First(&broken, missing.ID)
+	if !broken.IsBroken {
+		t.Error("expected media without original to be flagged as broken")
+	}
+}
+
+func runFsck(t *testing.T, server *httptest.Server, method, body, cookie string) dto.FsckReportDto {
+	t.Helper()
+	resp := doJSON(t, server, method, "/admin/fsck", body, cookie)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		t.Fatalf("fsck returned %d: %s", resp.StatusCode, b)
+	}
+
+	var envelope struct {
+		Data dto.FsckReportDto `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+	return envelope.Data
+}
+
+func writeTestFile(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("orphan"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
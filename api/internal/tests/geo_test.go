@@ -0,0 +1,113 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"embox/internal/api/dto"
+	"embox/internal/models"
+
+	"gorm.io/gorm"
+)
+
+func TestGeo_ClustersAndLocationEditing(t *testing.T) {
+	server, db, _, teardown := SetupTestApp(t)
+	defer teardown()
+
+	email, cookie := CreateTestUser(t, db, server)
+	user := getUserFromDB(t, db, email)
+
+	hamburg := createGeoTestMedia(t, db, user, 53.55, 10.00, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	altona := createGeoTestMedia(t, db, user, 53.56, 9.94, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	newYork := createGeoTestMedia(t, db, user, 40.71, -74.00, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	trashed := createGeoTestMedia(t, db, user, 53.55, 10.00, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	db.Delete(trashed)
+	createTestMedia(t, db, &user.ID) // without location
+
+	// Zoomed out, the two Hamburg photos form one cluster represented by the latest
+	features := getGeoFeatures(t, server, "-180,-90,180,90", 0, cookie)
+	if len(features) != 2 {
+		t.Fatalf("expected 2 clusters, got %+v", features)
+	}
+	if features[0].Properties.Count != 2 || features[0].Properties.MediaId != altona.ID {
+		t.Errorf("expected Hamburg cluster of 2 represented by %d, got %+v", altona.ID, features[0].Properties)
+	}
+	if features[1].Properties.Count != 1 || features[1].Properties.MediaId != newYork.ID {
+		t.Errorf("expected New York with 1 media, got %+v", features[1].Properties)
+	}
+	if lon, lat := features[0].Geometry.Coordinates[0], features[0].Geometry.Coordinates[1]; lon < 9.94 || lon > 10 || lat < 53.55 || lat > 53.56 {
+		t.Errorf("expected cluster between its media, got %v", features[0].Geometry.Coordinates)
+	}
+
+	// Zoomed in, they are separate
+	if features := getGeoFeatures(t, server, "-180,-90,180,90", 16, cookie); len(features) != 3 {
+		t.Errorf("expected 3 points at zoom 16, got %d", len(features))
+	}
+	// Only media within the bounding box
+	if features := getGeoFeatures(t, server, "0,40,20,60", 5, cookie); len(features) != 1 || features[0].Properties.Count != 2 {
+		t.Errorf("expected only the Hamburg cluster, got %+v", features)
+	}
+	// Bounding box across the antimeridian
+	if features := getGeoFeatures(t, server, "170,-90,-60,90", 0, cookie); len(features) != 1 || features[0].Properties.MediaId != newYork.ID {
+		t.Errorf("expected only New York, got %+v", features)
+	}
+
+	for _, query := range []string{"bbox=1,2,3", "bbox=0,60,20,40", "bbox=-180,-90,180,90&zoom=99"} {
+		resp := doJSON(t, server, "GET", "/media/geo?"+query, "", cookie)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, resp.StatusCode)
+		}
+	}
+
+	// Move New York to Hamburg and remove the location of one Hamburg photo
+	body := fmt.Sprintf(`{"updates":[{"id":%d,"latitude":53.55,"longitude":10.0},{"id":%d,"removeLocation":true}]}`, newYork.ID, hamburg.ID)
+	resp := doJSON(t, server, "PUT", "/media/", body, cookie)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("update returned %d", resp.StatusCode)
+	}
+	features = getGeoFeatures(t, server, "-180,-90,180,90", 0, cookie)
+	if len(features) != 1 || features[0].Properties.Count != 2 {
+		t.Errorf("expected one cluster of 2 after editing, got %+v", features)
+	}
+
+	// Latitude without longitude is rejected
+	resp = doJSON(t, server, "PUT", "/media/", fmt.Sprintf(`{"updates":[{"id":%d,"latitude":1}]}`, hamburg.ID), cookie)
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		t.Error("expected incomplete location to fail")
+	}
+}
+
+func createGeoTestMedia(t *testing.T, db *gorm.DB, user *models.User, lat, lon float64, date time.Time) *models.Media {
+	t.Helper()
+	media := createTestMedia(t, db, &user.ID)
+	db.Model(media).Update("date", date)
+	metadata := &models.MediaMetadata{MediaID: media.ID, Latitude: &lat, Longitude: &lon}
+	if err := db.Create(metadata).Error; err != nil {
+		t.Fatalf("createGeoTestMedia: %v", err)
+	}
+	return media
+}
+
+func getGeoFeatures(t *testing.T, server *httptest.Server, bbox string, zoom int, cookie string) []dto.GeoFeatureDto {
+	t.Helper()
+	resp := doJSON(t, server, "GET", fmt.Sprintf("/media/geo?bbox=%s&zoom=%d", bbox, zoom), "", cookie)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("geo returned %d", resp.StatusCode)
+	}
+
+	var envelope struct {
+		Data dto.GeoFeatureCollectionDto `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		t.Fatalf("decode geo: %v", err)
+	}
+	return envelope.Data.Features
+}
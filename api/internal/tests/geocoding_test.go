@@ -0,0 +1,170 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"embox/internal/api/dto"
+	"embox/pkg/geocoder"
+)
+
+func TestGeocoding_UploadFilterEditAndBackfill(t *testing.T) {
+	server, db, cfg, teardown := SetupTestApp(t)
+	defer teardown()
+
+	email, cookie := CreateTestUser(t, db, server)
+	user := getUserFromDB(t, db, email)
+
+	// The EXIF position 53.5N 10E is near Hamburg
+	resp := doMultipart(t, server, "/media/", func(w *multipart.Writer) {
+		part, _ := w.CreateFormFile("files", "photo.jpg")
+		part.Write(createTestExifJPEG(t))
+		w.WriteField("meta", `[{"fileName":"photo.jpg","type":"image/jpeg","caption":""}]`)
+	}, cookie)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload returned %d", resp.StatusCode)
+	}
+
+	list := getMediaListFiltered(t, server, "", cookie)
+	if len(list) != 1 || list[0].Place != "Hamburg" || list[0].Country != "Germany" {
+		t.Fatalf("expected upload in Hamburg, Germany, got %+v", list)
+	}
+	uploaded := list[0].Id
+
+	// Geotagged before geocoding: at Suva and in the middle of the ocean
+	suva := createGeoTestMedia(t, db, user, -18.1, 178.4, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	ocean := createGeoTestMedia(t, db, user, 0, -30, time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC))
+
+	report := runBackfill(t, db, cfg)
+	if !slices.Equal(report.Geocoded, []uint{suva.ID}) {
+		t.Errorf("expected media %d to be geocoded, got %v", suva.ID, report.Geocoded)
+	}
+
+	for _, tc := range []struct {
+		query string
+		ids   []uint
+	}{
+		{"?country=Fiji", []uint{suva.ID}},
+		{"?place=Hamburg&country=Germany", []uint{uploaded}},
+		{"?place=Hamburg&country=France", []uint{}},
+		{"?country=", []uint{uploaded, suva.ID, ocean.ID}},
+	} {
+		var ids []uint
+		for _, media := range getMediaListFiltered(t, server, tc.query, cookie) {
+			ids = append(ids, media.Id)
+		}
+		slices.Sort(ids)
+		if !slices.Equal(ids, tc.ids) {
+			t.Errorf("%s: expected %v, got %v", tc.query, tc.ids, ids)
+		}
+	}
+
+	// Moving the media names the new place, removing the location clears it
+	body := fmt.Sprintf(`{"updates":[{"id":%d,"latitude":48.86,"longitude":2.35},{"id":%d,"removeLocation":true}]}`, ocean.ID, suva.ID)
+	resp = doJSON(t, server, "PUT", "/media/", body, cookie)
+	var updated struct {
+		Data []dto.MediaResponseDto `json:"data"`
+	}
+	json.NewDecoder(resp.Body).Decode(&updated)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(updated.Data) != 2 {
+		t.Fatalf("update returned %d", resp.StatusCode)
+	}
+	if updated.Data[0].Place != "Paris" || updated.Data[0].Country != "France" || updated.Data[1].Place != "" || updated.Data[1].Country != "" {
+		t.Errorf("expected Paris and no place, got %+v", updated.Data)
+	}
+	if list := getMediaListFiltered(t, server, "?country=France", cookie); len(list) != 1 || list[0].Id != ocean.ID {
+		t.Errorf("expected moved media in France, got %+v", list)
+	}
+
+	// The updated media are returned as listed
+	resp = doJSON(t, server, "PUT", "/media/", fmt.Sprintf(`{"updates":[{"id":%d,"caption":"Harbour"}]}`, uploaded), cookie)
+	json.NewDecoder(resp.Body).Decode(&updated)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(updated.Data) != 1 {
+		t.Fatalf("update returned %d", resp.StatusCode)
+	}
+	if got := updated.Data[0]; got.Caption != "Harbour" || got.ProcessingStatus != "done" || got.Width != 20 || got.BlurHash == "" || got.Place != "Hamburg" {
+		t.Errorf("expected the media as listed, got %+v", got)
+	}
+
+	// The place is not saved if the location cannot be
+	db.Exec("CREATE TRIGGER fail_metadata BEFORE UPDATE ON media_metadata BEGIN SELECT RAISE(ABORT, 'metadata locked'); END")
+	resp = doJSON(t, server, "PUT", "/media/", fmt.Sprintf(`{"updates":[{"id":%d,"latitude":48.86,"longitude":2.35}]}`, uploaded), cookie)
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		t.Fatal("expected the location update to fail")
+	}
+	if list := getMediaListFiltered(t, server, "?place=Hamburg", cookie); len(list) != 1 || list[0].Id != uploaded {
+		t.Errorf("expected media to stay in Hamburg, got %+v", list)
+	}
+}
+
+func TestGeocoder_NearestMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	randomPosition := func() (float64, float64) {
+		return math.Asin(2*rng.Float64()-1) * 180 / math.Pi, rng.Float64()*360 - 180
+	}
+	places := make([]geocoder.Place, 2000)
+	for i := range places {
+		lat, lon := randomPosition()
+		places[i] = geocoder.Place{Name: fmt.Sprint(i), CountryCode: "DE", Latitude: lat, Longitude: lon}
+	}
+	// Across the antimeridian
+	places = append(places, geocoder.Place{Name: "east", CountryCode: "FJ", Latitude: -18, Longitude: 179.9})
+	g := geocoder.New(slices.Clone(places))
+
+	if p := g.Nearest(-18, -179.95, 50); p == nil || p.Name != "east" || p.Country() != "Fiji" {
+		t.Errorf("expected nearest place across the antimeridian, got %+v", p)
+	}
+
+	for range 500 {
+		lat, lon := randomPosition()
+		want, wantDistance := "", math.Inf(1)
+		for _, p := range places {
+			if d := haversine(lat, lon, p.Latitude, p.Longitude); d < wantDistance {
+				want, wantDistance = p.Name, d
+			}
+		}
+		if got := g.Nearest(lat, lon, 20000); got == nil || got.Name != want {
+			t.Fatalf("nearest to %f,%f: expected %s, got %+v", lat, lon, want, got)
+		}
+		if got := g.Nearest(lat, lon, wantDistance*0.99); got != nil {
+			t.Fatalf("nearest to %f,%f: expected none within %fkm, got %+v", lat, lon, wantDistance*0.99, got)
+		}
+	}
+}
+
+func getMediaListFiltered(t *testing.T, server *httptest.Server, query, cookie string) []dto.MediaResponseDto {
+	t.Helper()
+	resp := doJSON(t, server, "GET", "/media/"+query, "", cookie)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("media list%s returned %d", query, resp.StatusCode)
+	}
+
+	var envelope struct {
+		Data []dto.MediaResponseDto `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		t.Fatalf("decode media list: %v", err)
+	}
+	return envelope.Data
+}
+
+// haversine returns the great-circle distance in km.
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat, dLon := (lat2-lat1)*rad, (lon2-lon1)*rad
+	a := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * 6371 * math.Asin(math.Sqrt(a))
+}
@@ -18,7 +18,11 @@ import (
 	"testing"
 	"time"
 
+	"embox/internal/api/dto"
+	"embox/internal/config"
 	"embox/internal/models"
+	"embox/internal/repositories"
+	"embox/internal/services"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
@@ -174,3 +178,20 @@ func createTestPNG() []byte {
 	_ = png.Encode(&buf, img)
 	return buf.Bytes()
 }
+
+// newTestMediaService returns a media service on the test database and local storage, as used by the command line tools.
+func newTestMediaService(db *gorm.DB, cfg *config.ApiConfig) *services.MediaService {
+	repos := repositories.Init(db)
+	storage := services.NewLocalStorageAdapter(cfg.Storage.LocalDir)
+	return services.NewMediaService(cfg.Upload, cfg.Media, storage, repos.Media, repos.User, services.NewJobService(cfg.Media, repos.Job, repos.User))
+}
+
+// runBackfill runs embox-backfill on the test database and returns its report.
+func runBackfill(t *testing.T, db *gorm.DB, cfg *config.ApiConfig) *dto.BackfillReportDto {
+	t.Helper()
+	report, err := services.NewBackfillService(newTestMediaService(db, cfg), repositories.Init(db).Media).Run(2)
+	if err != nil {
+		t.Fatalf("backfill: %v", err)
+	}
+	return report
+}
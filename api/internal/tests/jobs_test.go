@@ -0,0 +1,209 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"embox/internal/api/dto"
+	"embox/internal/api/routes"
+	"embox/internal/models"
+	"embox/internal/services"
+)
+
+func TestJobs_ProcessUploadsInBackground(t *testing.T) {
+	_, db, cfg, teardown := SetupTestApp(t)
+	defer teardown()
+
+	// A server with job workers, uploads return before they are processed
+	cfg.Media.JobWorkers = 2
+	cfg.Media.JobMaxAttempts = 2
+	router, services := routes.Init(db, cfg)
+	services.Job.Start()
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	_, cookie := CreateTestUser(t, db, server)
+	_, otherCookie := CreateTestUser(t, db, server)
+
+	uploaded := uploadForProcessing(t, server, "photo.jpg", createTestExifJPEG(t), cookie)
+	if uploaded.ProcessingStatus != "pending" || uploaded.JobId == 0 {
+		t.Fatalf("expected pending upload with job, got %+v", uploaded)
+	}
+
+	job := waitForJob(t, server, uploaded.JobId, cookie)
+	if job.Status != "done" || job.Progress != 100 || job.Attempts != 1 || job.MediaId != uploaded.Id {
+		t.Fatalf("expected job done after one attempt, got %+v", job)
+	}
+
+	// The capture date is read from the EXIF data by the job
+	resp := doJSON(t, server, "GET", fmt.Sprintf("/media/%d", uploaded.Id), "", cookie)
+	var envelope struct {
+		Data dto.MediaDetailResponseDto `json:"data"`
+	}
+	json.NewDecoder(resp.Body).Decode(&envelope)
+	resp.Body.Close()
+	detail := envelope.Data
+	if date, err := time.Parse(time.RFC3339, detail.Date); err != nil || !date.Equal(time.Date(2023, 7, 14, 16, 30, 0, 0, time.UTC)) {
+		t.Errorf("expected capture date from EXIF, got %q", detail.Date)
+	}
+	if detail.ProcessingStatus != "done" || detail.Metadata == nil {
+		t.Errorf("expected processed media with metadata, got %+v", detail)
+	}
+	if width, _ := getThumbnailWidth(t, server, uploaded.Id, "", cookie); width == 0 {
+		t.Error("expected thumbnail after processing")
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Upload.StagingDir, "processing", fmt.Sprint(uploaded.Id))); !os.IsNotExist(err) {
+		t.Errorf("expected staged upload to be removed, got %v", err)
+	}
+
+	// Other users don't see the job
+	resp = doJSON(t, server, "GET", fmt.Sprintf("/jobs/%d", uploaded.JobId), "", otherCookie)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for job of other user, got %d", resp.StatusCode)
+	}
+
+	// A file that cannot be decoded is retried and then given up, keeping the original
+	broken := uploadForProcessing(t, server, "broken.jpg", bytes.Repeat([]byte{0x00, 0xff}, 64), cookie)
+	job = waitForJob(t, server, broken.JobId, cookie)
+	if job.Status != "dead" || job.Attempts != 2 || job.Error == "" {
+		t.Fatalf("expected dead job after two attempts, got %+v", job)
+	}
+	resp = doJSON(t, server, "GET", fmt.Sprintf("/media/%d", broken.Id), "", cookie)
+	json.NewDecoder(resp.Body).Decode(&envelope)
+	resp.Body.Close()
+	if envelope.Data.ProcessingStatus != "failed" {
+		t.Errorf("expected processing status failed, got %q", envelope.Data.ProcessingStatus)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Upload.StagingDir, "processing", fmt.Sprint(broken.Id))); err != nil {
+		t.Errorf("expected staged upload of failed job to be kept: %v", err)
+	}
+}
+
+func uploadForProcessing(t *testing.T, server *httptest.Server, fileName string, data []byte, cookie string) dto.MediaResponseDto {
+	t.Helper()
+	resp := doMultipart(t, server, "/media/", func(w *multipart.Writer) {
+		part, _ := w.CreateFormFile("files", fileName)
+		part.Write(data)
+		w.WriteField("meta", fmt.Sprintf(`[{"fileName":%q,"type":"image/jpeg","caption":""}]`, fileName))
+	}, cookie)
+	defer resp.Body.Close()
+	var uploaded struct {
+		Data []dto.MediaResponseDto `json:"data"`
+	}
+	json.NewDecoder(resp.Body).Decode(&uploaded)
+	if resp.StatusCode != http.StatusOK || len(uploaded.Data) != 1 {
+		t.Fatalf("upload returned %d", resp.StatusCode)
+	}
+	return uploaded.Data[0]
+}
+
+// waitForJob polls the job until it is done or dead.
+func waitForJob(t *testing.T, server *httptest.Server, id uint, cookie string) dto.JobResponseDto {
+	t.Helper()
+	deadline := time.Now().Add(15 * time.Second)
+	for {
+		resp := doJSON(t, server, "GET", fmt.Sprintf("/jobs/%d", id), "", cookie)
+		var envelope struct {
+			Data dto.JobResponseDto `json:"data"`
+		}
+		json.NewDecoder(resp.Body).Decode(&envelope)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("job %d returned %d", id, resp.StatusCode)
+		}
+		job := envelope.Data
+		if job.Status == "done" || job.Status == "dead" {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %d not finished in time: %+v", id, job)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestJobs_FailedUploadDiscarded(t *testing.T) {
+	server, db, cfg, teardown := SetupTestApp(t)
+	defer teardown()
+
+	_, cookie := CreateTestUser(t, db, server)
+
+	// Without job workers the upload is processed within the request, which fails
+	resp := doMultipart(t, server, "/media/", func(w *multipart.Writer) {
+		part, _ := w.CreateFormFile("files", "broken.jpg")
+		part.Write(bytes.Repeat([]byte{0x00, 0xff}, 64))
+		w.WriteField("meta", `[{"fileName":"broken.jpg","type":"image/jpeg","caption":""}]`)
+	}, cookie)
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		t.Fatal("expected the upload to fail")
+	}
+
+	if count := getMediaCount(t, db); count != 0 {
+		t.Errorf("expected the media of the failed upload to be removed, got %d", count)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(cfg.Upload.StagingDir, "processing")); len(entries) != 0 {
+		t.Errorf("expected no staged uploads, got %d", len(entries))
+	}
+}
+
+func TestJobs_BackfillRetriesFailedUploads(t *testing.T) {
+	server, db, cfg, teardown := SetupTestApp(t)
+	defer teardown()
+
+	_, cookie := CreateTestUser(t, db, server)
+	uploaded := uploadForProcessing(t, server, "photo.jpg", createTestExifJPEG(t), cookie)
+	missing := uploadForProcessing(t, server, "other.jpg", createTestPNG(), cookie)
+
+	// The processing failed before the original was stored, e.g. as the storage was down
+	var media models.Media
+	db.First(&media, uploaded.Id)
+	staged := filepath.Join(cfg.Upload.StagingDir, "processing", fmt.Sprint(uploaded.Id))
+	original, err := os.ReadFile(filepath.Join(cfg.Storage.LocalDir, media.RemotePath()))
+	if err != nil {
+		t.Fatalf("read original: %v", err)
+	}
+	os.WriteFile(staged, original, 0644)
+	os.Remove(filepath.Join(cfg.Storage.LocalDir, media.RemotePath()))
+	db.Model(&models.Media{}).Where("id IN ?", []uint{uploaded.Id, missing.Id}).Updates(map[string]any{"processing_status": services.ProcessingFailed, "date": time.Now()})
+
+	report := runBackfill(t, db, cfg)
+	if !slices.Equal(report.Reprocessed, []uint{uploaded.Id}) {
+		t.Errorf("expected media %d to be processed again, got %v", uploaded.Id, report.Reprocessed)
+	}
+	// Without staged original nothing can be done
+	if len(report.Failed) != 1 || report.Failed[0].Id != missing.Id {
+		t.Errorf("expected media %d to fail, got %+v", missing.Id, report.Failed)
+	}
+
+	media = models.Media{}
+	db.First(&media, uploaded.Id)
+	if media.ProcessingStatus != services.ProcessingDone {
+		t.Errorf("expected processing status done, got %q", media.ProcessingStatus)
+	}
+	// The job is run with the arguments of the failed one, the date is read from the file again
+	if !media.Date.Equal(time.Date(2023, 7, 14, 16, 30, 0, 0, time.UTC)) {
+		t.Errorf("expected capture date from EXIF, got %v", media.Date)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Storage.LocalDir, media.RemotePath())); err != nil {
+		t.Errorf("expected original to be stored: %v", err)
+	}
+	if _, err := os.Stat(staged); !os.IsNotExist(err) {
+		t.Errorf("expected staged upload to be removed, got %v", err)
+	}
+	var failed models.Media
+	db.First(&failed, missing.Id)
+	if failed.ProcessingStatus != services.ProcessingFailed {
+		t.Errorf("expected processing status failed, got %q", failed.ProcessingStatus)
+	}
+}
@@ -1,17 +1,27 @@
 package tests
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/png"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"sync"
 	"testing"
 	"time"
 
+	"embox/internal/api/dto"
 	"embox/internal/models"
+	"embox/internal/repositories"
+	"embox/internal/services"
 
 	"gorm.io/gorm"
 )
@@ -40,7 +50,11 @@ func TestUploadMedia_Image(t *testing.T) {
 	// Decode response to get media ID
 	var envelope struct {
 		Data []struct {
-			ID float64 `json:"id"`
+			ID       float64 `json:"id"`
+			Index    int     `json:"index"`
+			FileName string  `json:"fileName"`
+			Status   int     `json:"status"`
+			Error    any     `json:"error"`
 		} `json:"data"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
@@ -49,8 +63,12 @@ func TestUploadMedia_Image(t *testing.T) {
 	if len(envelope.Data) == 0 {
 		t.Fatal("expected at least one media item in response")
 	}
+	result := envelope.Data[0]
+	if result.Index != 0 || result.FileName != "test.png" || result.Status != http.StatusOK || result.Error != nil {
+		t.Errorf("expected successful result for test.png, got %+v", result)
+	}
 
-	mediaID := uint(envelope.Data[0].ID)
+	mediaID := uint(result.ID)
 
 	// DB entry must exist
 	var media models.Media
@@ -88,6 +106,112 @@ func TestUploadMedia_WrongMIME(t *testing.T) {
 	}
 }
 
+func TestUploadMedia_Rejected(t *testing.T) {
+	server, db, _, teardown := SetupTestApp(t)
+	defer teardown()
+
+	_, cookie := CreateTestUser(t, db, server)
+	before := getMediaCount(t, db)
+
+	upload := func(meta string, names ...string) (int, map[string]any) {
+		resp := doMultipart(t, server, "/media/", func(w *multipart.Writer) {
+			for _, name := range names {
+				part, _ := w.CreateFormFile("files", name)
+				part.Write(createTestPNG())
+			}
+			w.WriteField("meta", meta)
+		}, cookie)
+		defer resp.Body.Close()
+		return resp.StatusCode, decodeJSON(t, resp.Body)
+	}
+
+	// A single file fails with its own status, so clients checking the status see the failure
+	status, body := upload(`[{"fileName":"a.png","type":"image/png","date":"yesterday","caption":""}]`, "a.png")
+	if status != http.StatusBadRequest || body["status"] != "error" {
+		t.Errorf("expected 400 error for invalid date, got %d %v", status, body)
+	}
+
+	// If no file was stored, the highest status of the files is returned
+	status, body = upload(`[
+		{"fileName":"a.png","type":"image/png","date":"yesterday","caption":""},
+		{"fileName":"b.mp4","type":"video/mp4","date":"2024-06-01T12:00:00Z","caption":""}
+	]`, "a.png", "b.mp4")
+	if status != http.StatusUnsupportedMediaType || body["status"] != "error" {
+		t.Errorf("expected 415 error if all files failed, got %d %v", status, body)
+	}
+
+	if count := getMediaCount(t, db); count != before {
+		t.Errorf("expected no new media, got %d", count-before)
+	}
+}
+
+func TestUploadMedia_PartialFailure(t *testing.T) {
+	server, db, _, teardown := SetupTestApp(t)
+	defer teardown()
+
+	_, cookie := CreateTestUser(t, db, server)
+	before := getMediaCount(t, db)
+
+	// The second file declares the wrong type, the third an invalid date; the others are stored anyway
+	meta := `[
+		{"fileName":"a.png","type":"image/png","date":"2024-06-01T12:00:00Z","caption":""},
+		{"fileName":"b.mp4","type":"video/mp4","date":"2024-06-01T12:00:00Z","caption":""},
+		{"fileName":"c.png","type":"image/png","date":"yesterday","caption":""},
+		{"fileName":"d.png","type":"image/png","date":"2024-06-02T12:00:00Z","caption":""}
+	]`
+	resp := doMultipart(t, server, "/media/", func(w *multipart.Writer) {
+		for i, name := range []string{"a.png", "b.mp4", "c.png", "d.png"} {
+			part, _ := w.CreateFormFile("files", name)
+			img := image.NewRGBA(image.Rect(0, 0, 4+i, 4))
+			png.Encode(part, img)
+		}
+		w.WriteField("meta", meta)
+	}, cookie)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		t.Fatalf("expected 207, got %d", resp.StatusCode)
+	}
+	var envelope struct {
+		Data []dto.MediaUploadResultDto `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(envelope.Data) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(envelope.Data))
+	}
+
+	expected := []struct {
+		fileName string
+		status   int
+		code     string
+	}{
+		{"a.png", http.StatusOK, ""},
+		{"b.mp4", http.StatusUnsupportedMediaType, "type_mismatch"},
+		{"c.png", http.StatusBadRequest, "invalid_date"},
+		{"d.png", http.StatusOK, ""},
+	}
+	for i, want := range expected {
+		result := envelope.Data[i]
+		if result.Index != i || result.FileName != want.fileName || result.Status != want.status {
+			t.Errorf("result %d: expected %s with status %d, got %+v", i, want.fileName, want.status, result)
+			continue
+		}
+		if want.code == "" {
+			if result.MediaResponseDto == nil || result.Id == 0 || result.Error != nil {
+				t.Errorf("result %d: expected media, got %+v", i, result)
+			}
+		} else if result.Error == nil || result.Error.Code != want.code || result.MediaResponseDto != nil {
+			t.Errorf("result %d: expected error %q, got %+v", i, want.code, result.Error)
+		}
+	}
+
+	if count := getMediaCount(t, db); count != before+2 {
+		t.Errorf("expected 2 new media, got %d", count-before)
+	}
+}
+
 func TestGetMediaList(t *testing.T) {
 	server, db, _, teardown := SetupTestApp(t)
 	defer teardown()
@@ -169,6 +293,152 @@ func TestDeleteMedia_OtherUser(t *testing.T) {
 	}
 }
 
+func TestUpdateMedia_DateMovesFiles(t *testing.T) {
+	server, db, cfg, teardown := SetupTestApp(t)
+	defer teardown()
+
+	_, cookie := CreateTestUser(t, db, server)
+	imgData := createTestPNG()
+
+	meta := `[{"fileName":"test.png","type":"image/png","date":"2024-06-01T12:00:00Z","caption":""}]`
+	resp := doMultipart(t, server, "/media/", func(w *multipart.Writer) {
+		part, _ := w.CreateFormFile("files", "test.png")
+		part.Write(imgData)
+		w.WriteField("meta", meta)
+	}, cookie)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("upload returned %d: %s", resp.StatusCode, body)
+	}
+
+	var before models.Media
+	if err := db.Order("id DESC").First(&before).Error; err != nil {
+		t.Fatalf("media not found in DB: %v", err)
+	}
+
+	body := fmt.Sprintf(`{"updates":[{"id":%d,"date":"2023-12-24T18:00:00Z"}]}`, before.ID)
+	updateResp := doJSON(t, server, "PUT", "/media/", body, cookie)
+	defer updateResp.Body.Close()
+	if updateResp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(updateResp.Body)
+		t.Fatalf("update returned %d: %s", updateResp.StatusCode, b)
+	}
+
+	var after models.Media
+	if err := db.First(&after, before.ID).Error; err != nil {
+		t.Fatalf("media not found in DB: %v", err)
+	}
+	if after.RemotePath() == before.RemotePath() {
+		t.Fatalf("expected remote path to change, still %s", after.RemotePath())
+	}
+
+	// Original and thumbnail must be reachable under the new paths only
+	if _, err := os.Stat(filepath.Join(cfg.Storage.LocalDir, after.RemotePath())); err != nil {
+		t.Errorf("original not found at new path: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Storage.LocalDir, before.RemotePath())); !os.IsNotExist(err) {
+		t.Errorf("original still present at old path %s", before.RemotePath())
+	}
+	if _, err := os.Stat(filepath.Join(services.MediaDir, after.Path())); err != nil {
+		t.Errorf("thumbnail not found at new path: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(services.MediaDir, before.Path())); !os.IsNotExist(err) {
+		t.Errorf("thumbnail still present at old path %s", before.Path())
+	}
+
+	// While the web versions are written, the date cannot be changed, the caption can
+	db.Model(&models.Media{}).Where("id = ?", before.ID).Update("transcode_status", services.TranscodePending)
+	body = fmt.Sprintf(`{"updates":[{"id":%d,"date":"2022-01-01T00:00:00Z","caption":"Transcoding"}]}`, before.ID)
+	updateResp = doJSON(t, server, "PUT", "/media/", body, cookie)
+	updateResp.Body.Close()
+	if updateResp.StatusCode == http.StatusOK {
+		t.Error("expected date change to fail while transcoding")
+	}
+	body = fmt.Sprintf(`{"updates":[{"id":%d,"caption":"Transcoding"}]}`, before.ID)
+	updateResp = doJSON(t, server, "PUT", "/media/", body, cookie)
+	updateResp.Body.Close()
+	if updateResp.StatusCode != http.StatusOK {
+		t.Errorf("expected caption change while transcoding, got %d", updateResp.StatusCode)
+	}
+	var transcoding models.Media
+	db.First(&transcoding, before.ID)
+	if !transcoding.Date.Equal(after.Date) || transcoding.Caption != "Transcoding" {
+		t.Errorf("expected only the caption to change, got %v %q", transcoding.Date, transcoding.Caption)
+	}
+}
+
+func TestUpdateMedia_FailedCommitMovesFilesBack(t *testing.T) {
+	server, db, cfg, teardown := SetupTestApp(t)
+	defer teardown()
+
+	_, cookie := CreateTestUser(t, db, server)
+	id := uploadTestImage(t, server, "2024-06-01T12:00:00Z", createTestPNG(), cookie)
+	var before models.Media
+	db.First(&before, id)
+
+	// A deferred foreign key, violated by a trigger on the date, fails the commit after the files were moved
+	db.Exec("CREATE TABLE commit_guard (user_id char(36) REFERENCES users(id) DEFERRABLE INITIALLY DEFERRED)")
+	db.Exec("CREATE TRIGGER fail_commit AFTER UPDATE OF date ON media BEGIN INSERT INTO commit_guard VALUES ('missing'); END")
+
+	body := fmt.Sprintf(`{"updates":[{"id":%d,"date":"2023-12-24T18:00:00Z"}]}`, id)
+	resp := doJSON(t, server, "PUT", "/media/", body, cookie)
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		t.Fatal("expected the update to fail")
+	}
+
+	var after models.Media
+	db.First(&after, id)
+	if !after.Date.Equal(before.Date) {
+		t.Errorf("expected the date to be kept, got %v", after.Date)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Storage.LocalDir, before.RemotePath())); err != nil {
+		t.Errorf("original not moved back: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(services.MediaDir, before.Path())); err != nil {
+		t.Errorf("thumbnail not moved back: %v", err)
+	}
+}
+
+func TestUpdateMedia_KeepsBackgroundColumns(t *testing.T) {
+	server, db, _, teardown := SetupTestApp(t)
+	defer teardown()
+
+	_, cookie := CreateTestUser(t, db, server)
+	id := uploadTestImage(t, server, "2024-06-01T12:00:00Z", createTestPNG(), cookie)
+	db.Model(&models.Media{}).Where("id = ?", id).Updates(map[string]any{"processing_status": services.ProcessingPending, "transcode_status": services.TranscodePending})
+
+	// A caption is edited while the background jobs finish
+	repo := repositories.Init(db).Media
+	stale, err := repo.GetById(id)
+	if err != nil || stale == nil {
+		t.Fatalf("get media: %v", err)
+	}
+	processed := *stale
+	processed.ProcessingStatus = services.ProcessingDone
+	processed.Date = time.Date(2023, 5, 1, 9, 0, 0, 0, time.UTC)
+	if err := repo.SetProcessed(&processed); err != nil {
+		t.Fatalf("set processed: %v", err)
+	}
+	db.Model(&models.Media{}).Where("id = ?", id).UpdateColumns(map[string]any{"transcode_status": services.TranscodeDone, "blur_hash": "L00000fQfQfQfQfQfQfQfQfQfQfQ"})
+
+	stale.Caption = "Edited"
+	stale.Date = processed.Date
+	if err := repo.Update(stale, nil); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	var after models.Media
+	db.First(&after, id)
+	if after.Caption != "Edited" {
+		t.Errorf("expected the caption to be saved, got %q", after.Caption)
+	}
+	if after.ProcessingStatus != services.ProcessingDone || after.TranscodeStatus != services.TranscodeDone || after.BlurHash == "" {
+		t.Errorf("expected the columns of the background jobs to be kept, got %q, %q, %q", after.ProcessingStatus, after.TranscodeStatus, after.BlurHash)
+	}
+}
+
 // getMediaCount returns the number of media records in the DB.
 func getMediaCount(t *testing.T, db *gorm.DB) int64 {
 	t.Helper()
@@ -176,3 +446,192 @@ func getMediaCount(t *testing.T, db *gorm.DB) int64 {
 	db.Model(&models.Media{}).Count(&count)
 	return count
 }
+
+func TestUploadMedia_DuplicateReturnsExisting(t *testing.T) {
+	server, db, _, teardown := SetupTestApp(t)
+	defer teardown()
+
+	_, cookie := CreateTestUser(t, db, server)
+	imgData := createTestPNG()
+	meta := `[{"fileName":"test.png","type":"image/png","date":"2024-06-01T12:00:00Z","caption":""}]`
+
+	var ids []uint
+	for i := 0; i < 2; i++ {
+		resp := doMultipart(t, server, "/media/", func(w *multipart.Writer) {
+			part, _ := w.CreateFormFile("files", "test.png")
+			part.Write(imgData)
+			w.WriteField("meta", meta)
+		}, cookie)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			t.Fatalf("upload %d returned %d: %s", i, resp.StatusCode, body)
+		}
+
+		var envelope struct {
+			Data []struct {
+				ID          uint `json:"id"`
+				DuplicateOf uint `json:"duplicateOf"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil || len(envelope.Data) != 1 {
+			t.Fatalf("decode response: %v", err)
+		}
+		if i == 0 && envelope.Data[0].DuplicateOf != 0 {
+			t.Errorf("first upload must not be a duplicate, got duplicateOf %d", envelope.Data[0].DuplicateOf)
+		}
+		if i == 1 && envelope.Data[0].DuplicateOf != ids[0] {
+			t.Errorf("expected duplicateOf %d, got %d", ids[0], envelope.Data[0].DuplicateOf)
+		}
+		ids = append(ids, envelope.Data[0].ID)
+	}
+
+	if ids[0] != ids[1] {
+		t.Errorf("expected the existing media %d to be returned, got %d", ids[0], ids[1])
+	}
+	var count int64
+	db.Model(&models.Media{}).Count(&count)
+	if count != 1 {
+		t.Errorf("expected 1 media row, got %d", count)
+	}
+}
+
+func TestUploadMedia_DuplicateOfUnprocessed(t *testing.T) {
+	server, db, cfg, teardown := SetupTestApp(t)
+	defer teardown()
+	cfg.Upload.DuplicatePolicy = "existing"
+
+	_, cookie := CreateTestUser(t, db, server)
+	imgData := createTestPNG()
+	first := uploadForProcessing(t, server, "test.png", imgData, cookie)
+
+	// The original of a failed upload was never stored, it is not a duplicate
+	db.Model(&models.Media{}).Where("id = ?", first.Id).Update("processing_status", services.ProcessingFailed)
+	second := uploadForProcessing(t, server, "test.png", imgData, cookie)
+	if second.Id == first.Id || second.DuplicateOf != 0 {
+		t.Errorf("expected new media without duplicateOf, got %d (duplicate of %d)", second.Id, second.DuplicateOf)
+	}
+
+	// Media still being processed may fail yet, so a copy is stored
+	db.Model(&models.Media{}).Where("id = ?", second.Id).Update("processing_status", services.ProcessingPending)
+	third := uploadForProcessing(t, server, "test.png", imgData, cookie)
+	if third.Id == second.Id || third.DuplicateOf != second.Id {
+		t.Errorf("expected copy of media %d, got %d (duplicate of %d)", second.Id, third.Id, third.DuplicateOf)
+	}
+}
+
+func TestUploadMedia_ConcurrentDuplicates(t *testing.T) {
+	server, db, cfg, teardown := SetupTestApp(t)
+	defer teardown()
+	cfg.Upload.DuplicatePolicy = "reject"
+
+	_, cookie := CreateTestUser(t, db, server)
+	imgData := createTestPNG()
+	meta := `[{"fileName":"test.png","type":"image/png","date":"2024-06-01T12:00:00Z","caption":""}]`
+
+	const uploads = 6
+	statuses := make(chan int, uploads)
+	var wg sync.WaitGroup
+	for range uploads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := doMultipart(t, server, "/media/", func(w *multipart.Writer) {
+				part, _ := w.CreateFormFile("files", "test.png")
+				part.Write(imgData)
+				w.WriteField("meta", meta)
+			}, cookie)
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusOK] != 1 || counts[http.StatusConflict] != uploads-1 {
+		t.Errorf("expected one upload to be stored and the others rejected, got %v", counts)
+	}
+	var count int64
+	db.Model(&models.Media{}).Count(&count)
+	if count != 1 {
+		t.Errorf("expected 1 media row, got %d", count)
+	}
+}
+
+func TestUploadMedia_BackfillContentHash(t *testing.T) {
+	server, db, cfg, teardown := SetupTestApp(t)
+	defer teardown()
+	cfg.Upload.DuplicatePolicy = "reject"
+
+	_, cookie := CreateTestUser(t, db, server)
+	imgData := createTestPNG()
+	first := uploadForProcessing(t, server, "test.png", imgData, cookie)
+	var trashedData bytes.Buffer
+	png.Encode(&trashedData, createPatternImage(300, 225, false))
+	trashed := uploadForProcessing(t, server, "trashed.png", trashedData.Bytes(), cookie)
+	db.Delete(&models.Media{}, trashed.Id)
+
+	// Media uploaded before duplicates were detected get their hash from the backfill, also those in the trash
+	db.Unscoped().Model(&models.Media{}).Where("id IN ?", []uint{first.Id, trashed.Id}).Update("content_hash", "")
+	db.Unscoped().Model(&models.Media{}).Where("id = ?", trashed.Id).Update("perceptual_hash", "")
+	report := runBackfill(t, db, cfg)
+	if !slices.Equal(report.Checksummed, []uint{first.Id, trashed.Id}) {
+		t.Errorf("expected content hashes of media %d and %d to be backfilled, got %v", first.Id, trashed.Id, report.Checksummed)
+	}
+	if !slices.Equal(report.Hashed, []uint{trashed.Id}) {
+		t.Errorf("expected perceptual hash of media %d to be backfilled, got %v", trashed.Id, report.Hashed)
+	}
+	if report = runBackfill(t, db, cfg); len(report.Checksummed) != 0 || len(report.Hashed) != 0 {
+		t.Errorf("expected nothing left to hash, got %v and %v", report.Checksummed, report.Hashed)
+	}
+	var media models.Media
+	db.First(&media, first.Id)
+	if sum := sha256.Sum256(imgData); media.ContentHash != hex.EncodeToString(sum[:]) {
+		t.Errorf("expected SHA-256 of the original, got %q", media.ContentHash)
+	}
+
+	resp := doMultipart(t, server, "/media/", func(w *multipart.Writer) {
+		part, _ := w.CreateFormFile("files", "test.png")
+		part.Write(imgData)
+		w.WriteField("meta", `[{"fileName":"test.png","type":"image/png","date":"2024-06-01T12:00:00Z","caption":""}]`)
+	}, cookie)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected the upload to be rejected as duplicate, got %d", resp.StatusCode)
+	}
+}
+
+func TestUploadMedia_DuplicateRejected(t *testing.T) {
+	server, db, cfg, teardown := SetupTestApp(t)
+	defer teardown()
+	cfg.Upload.DuplicatePolicy = "reject"
+
+	_, cookie := CreateTestUser(t, db, server)
+	imgData := createTestPNG()
+	meta := `[{"fileName":"test.png","type":"image/png","date":"2024-06-01T12:00:00Z","caption":""}]`
+
+	var statuses []int
+	var results []dto.MediaUploadResultDto
+	for i := 0; i < 2; i++ {
+		resp := doMultipart(t, server, "/media/", func(w *multipart.Writer) {
+			part, _ := w.CreateFormFile("files", "test.png")
+			part.Write(imgData)
+			w.WriteField("meta", meta)
+		}, cookie)
+		var envelope struct {
+			Data []dto.MediaUploadResultDto `json:"data"`
+		}
+		json.NewDecoder(resp.Body).Decode(&envelope)
+		resp.Body.Close()
+		statuses = append(statuses, resp.StatusCode)
+		results = append(results, envelope.Data...)
+	}
+
+	if statuses[0] != http.StatusOK || statuses[1] != http.StatusConflict || len(results) != 1 {
+		t.Errorf("expected 200 then 409, got %v", statuses)
+	}
+}
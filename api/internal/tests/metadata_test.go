@@ -0,0 +1,201 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"mime/multipart"
+	"net/http"
+	"testing"
+	"time"
+
+	"embox/internal/api/dto"
+)
+
+func TestMetadata_ExifOnUploadAndDetail(t *testing.T) {
+	server, db, _, teardown := SetupTestApp(t)
+	defer teardown()
+
+	_, cookie := CreateTestUser(t, db, server)
+
+	// Without date, the capture date is taken from the EXIF data
+	meta := `[{"fileName":"photo.jpg","type":"image/jpeg","caption":""}]`
+	resp := doMultipart(t, server, "/media/", func(w *multipart.Writer) {
+		part, _ := w.CreateFormFile("files", "photo.jpg")
+		part.Write(createTestExifJPEG(t))
+		w.WriteField("meta", meta)
+	}, cookie)
+	var uploaded struct {
+		Data []dto.MediaResponseDto `json:"data"`
+	}
+	json.NewDecoder(resp.Body).Decode(&uploaded)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(uploaded.Data) != 1 {
+		t.Fatalf("upload returned %d", resp.StatusCode)
+	}
+	id := uploaded.Data[0].Id
+
+	resp = doJSON(t, server, "GET", fmt.Sprintf("/media/%d", id), "", cookie)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("detail returned %d", resp.StatusCode)
+	}
+	var envelope struct {
+		Data dto.MediaDetailResponseDto `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		t.Fatalf("decode detail: %v", err)
+	}
+	detail := envelope.Data
+
+	date, err := time.Parse(time.RFC3339, detail.Date)
+	if err != nil || !date.Equal(time.Date(2023, 7, 14, 16, 30, 0, 0, time.UTC)) {
+		t.Errorf("expected capture date 2023-07-14T18:30:00+02:00, got %q", detail.Date)
+	}
+
+	m := detail.Metadata
+	if m == nil {
+		t.Fatal("expected metadata")
+	}
+	if m.CameraMake != "Embox" || m.CameraModel != "Test Camera" || m.LensModel != "50mm F1.8" {
+		t.Errorf("unexpected camera: %+v", m)
+	}
+	if m.FocalLength == nil || *m.FocalLength != 50 || m.FNumber == nil || *m.FNumber != 2.8 || m.ExposureTime != "1/250" || m.ISO == nil || *m.ISO != 200 {
+		t.Errorf("unexpected exposure: %+v", m)
+	}
+	// The image is 40x20, rotated by EXIF orientation 6
+	if m.Width != 20 || m.Height != 40 {
+		t.Errorf("expected 20x40, got %dx%d", m.Width, m.Height)
+	}
+	if m.Latitude == nil || *m.Latitude != 53.5 || m.Longitude == nil || *m.Longitude != 10 || m.Altitude == nil || *m.Altitude != 12 {
+		t.Errorf("unexpected location: %+v", m)
+	}
+	if m.OffsetTime != "+02:00" {
+		t.Errorf("expected offset +02:00, got %q", m.OffsetTime)
+	}
+
+	resp = doJSON(t, server, "GET", "/media/999", "", cookie)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown media, got %d", resp.StatusCode)
+	}
+}
+
+type exifEntry struct {
+	tag   uint16
+	typ   uint16 // 1 BYTE, 2 ASCII, 3 SHORT, 4 LONG, 5 RATIONAL
+	count uint32
+	data  []byte
+}
+
+func exifASCII(tag uint16, s string) exifEntry {
+	return exifEntry{tag, 2, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func exifShort(tag uint16, v uint16) exifEntry {
+	return exifEntry{tag, 3, 1, binary.BigEndian.AppendUint16(nil, v)}
+}
+
+func exifLong(tag uint16, v uint32) exifEntry {
+	return exifEntry{tag, 4, 1, binary.BigEndian.AppendUint32(nil, v)}
+}
+
+func exifRational(tag uint16, values ...uint32) exifEntry {
+	var data []byte
+	for _, v := range values {
+		data = binary.BigEndian.AppendUint32(data, v)
+	}
+	return exifEntry{tag, 5, uint32(len(values) / 2), data}
+}
+
+// exifIFDSize returns the size of an IFD with its out-of-line values.
+func exifIFDSize(entries []exifEntry) uint32 {
+	size := uint32(2 + 12*len(entries) + 4)
+	for _, e := range entries {
+		if len(e.data) > 4 {
+			size += uint32(len(e.data)+1) &^ 1
+		}
+	}
+	return size
+}
+
+// appendExifIFD appends an IFD, located at offset in the TIFF data, followed by its out-of-line values.
+func appendExifIFD(buf []byte, offset uint32, entries []exifEntry) []byte {
+	dataOffset := offset + uint32(2+12*len(entries)+4)
+	var data []byte
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(entries)))
+	for _, e := range entries {
+		buf = binary.BigEndian.AppendUint16(buf, e.tag)
+		buf = binary.BigEndian.AppendUint16(buf, e.typ)
+		buf = binary.BigEndian.AppendUint32(buf, e.count)
+		if len(e.data) <= 4 {
+			buf = append(buf, e.data...)
+			buf = append(buf, make([]byte, 4-len(e.data))...)
+			continue
+		}
+		buf = binary.BigEndian.AppendUint32(buf, dataOffset+uint32(len(data)))
+		data = append(data, e.data...)
+		if len(e.data)%2 == 1 {
+			data = append(data, 0)
+		}
+	}
+	buf = binary.BigEndian.AppendUint32(buf, 0) // no next IFD
+	return append(buf, data...)
+}
+
+// createTestExifJPEG returns a 40x20 JPEG with camera, exposure, GPS and capture date EXIF data.
+func createTestExifJPEG(t *testing.T) []byte {
+	t.Helper()
+
+	exifIFD := []exifEntry{
+		exifRational(0x829A, 1, 250), // ExposureTime
+		exifRational(0x829D, 28, 10), // FNumber
+		exifShort(0x8827, 200),       // ISOSpeedRatings
+		exifASCII(0x9003, "2023:07:14 18:30:00"),
+		exifASCII(0x9011, "+02:00"),    // OffsetTimeOriginal
+		exifRational(0x920A, 50, 1),    // FocalLength
+		exifASCII(0xA434, "50mm F1.8"), // LensModel
+	}
+	gpsIFD := []exifEntry{
+		exifASCII(0x1, "N"),
+		exifRational(0x2, 53, 1, 30, 1, 0, 1),
+		exifASCII(0x3, "E"),
+		exifRational(0x4, 10, 1, 0, 1, 0, 1),
+		{0x5, 1, 1, []byte{0}}, // GPSAltitudeRef: above sea level
+		exifRational(0x6, 12, 1),
+	}
+	ifd0 := []exifEntry{
+		exifASCII(0x010F, "Embox"),
+		exifASCII(0x0110, "Test Camera"),
+		exifShort(0x0112, 6), // Orientation: rotate 90° CW
+		exifLong(0x8769, 0),  // ExifIFDPointer, set below
+		exifLong(0x8825, 0),  // GPSInfo, set below
+	}
+	exifOffset := 8 + exifIFDSize(ifd0)
+	gpsOffset := exifOffset + exifIFDSize(exifIFD)
+	ifd0[3] = exifLong(0x8769, exifOffset)
+	ifd0[4] = exifLong(0x8825, gpsOffset)
+
+	tiffData := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiffData = appendExifIFD(tiffData, 8, ifd0)
+	tiffData = appendExifIFD(tiffData, exifOffset, exifIFD)
+	tiffData = appendExifIFD(tiffData, gpsOffset, gpsIFD)
+
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, 40, 20)), nil); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+
+	// Insert the APP1 segment right after SOI
+	app1 := append([]byte("Exif\x00\x00"), tiffData...)
+	var out bytes.Buffer
+	out.Write(img.Bytes()[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(app1)+2))
+	out.Write(app1)
+	out.Write(img.Bytes()[2:])
+	return out.Bytes()
+}
@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"embox/internal/api/dto"
+	"embox/internal/models"
+)
+
+func TestPlaceholder_LayoutAndBackfill(t *testing.T) {
+	server, db, cfg, teardown := SetupTestApp(t)
+	defer teardown()
+
+	email, cookie := CreateTestUser(t, db, server)
+	user := getUserFromDB(t, db, email)
+
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: color.RGBA{R: 255, A: 255}}, image.Point{}, draw.Src)
+	var data bytes.Buffer
+	png.Encode(&data, img)
+	id := uploadTestImage(t, server, "2024-03-01T10:00:00Z", data.Bytes(), cookie)
+
+	album := &models.Album{Name: "Red", UserID: &user.ID}
+	db.Create(album)
+	db.Create(&models.AlbumMedia{AlbumID: album.ID, MediaID: id, IsCover: true})
+	db.Create(&models.Favourite{UserID: user.ID, MediaID: id, CreatedAt: time.Now()})
+
+	// Computed with the reference encoder, 4x3 components of a red landscape image
+	const redHash = "LGTI:j,YfQ,Y|cjtfQjtfQfQfQfQ"
+	checkLayout := func(source string, layout dto.MediaLayoutDto, hashPrefix string) {
+		t.Helper()
+		if layout.Width != 40 || layout.Height != 20 || layout.AspectRatio != 2 || layout.DominantColor != "#ff0000" {
+			t.Errorf("%s: expected 40x20 red media, got %+v", source, layout)
+		}
+		if len(layout.BlurHash) != len(redHash) || !strings.HasPrefix(layout.BlurHash, hashPrefix) {
+			t.Errorf("%s: expected BlurHash like %q, got %q", source, redHash, layout.BlurHash)
+		}
+	}
+
+	var list struct {
+		Data []dto.MediaResponseDto `json:"data"`
+	}
+	getData(t, server, "/media/", cookie, &list)
+	if len(list.Data) != 1 {
+		t.Fatalf("expected one media, got %d", len(list.Data))
+	}
+	checkLayout("list", list.Data[0].MediaLayoutDto, redHash)
+
+	var albumEnvelope struct {
+		Data dto.AlbumResponseDto `json:"data"`
+	}
+	getData(t, server, fmt.Sprintf("/album/%d", album.ID), cookie, &albumEnvelope)
+	if len(albumEnvelope.Data.Media) != 1 {
+		t.Fatalf("expected one album media, got %+v", albumEnvelope.Data)
+	}
+	checkLayout("album", albumEnvelope.Data.Media[0].MediaLayoutDto, redHash)
+
+	var favourites struct {
+		Data dto.FavouritesResponseDto `json:"data"`
+	}
+	getData(t, server, "/favourite/user/"+user.ID.String(), cookie, &favourites)
+	if len(favourites.Data.Media) != 1 {
+		t.Fatalf("expected one favourite, got %+v", favourites.Data)
+	}
+	checkLayout("favourites", favourites.Data.Media[0].MediaLayoutDto, redHash)
+
+	// Media uploaded before get their placeholder from the backfill
+	db.Model(&models.Media{}).Where("id = ?", id).Updates(map[string]any{"width": 0, "height": 0, "blur_hash": "", "dominant_color": ""})
+	report := runBackfill(t, db, cfg)
+	if !slices.Equal(report.Placeholders, []uint{id}) {
+		t.Errorf("expected placeholder of media %d to be backfilled, got %v", id, report.Placeholders)
+	}
+	getData(t, server, "/media/", cookie, &list)
+	// Decoded from the lossy thumbnail, only the size and average colour are exact
+	checkLayout("backfilled", list.Data[0].MediaLayoutDto, redHash[:4])
+}
+
+// getData decodes the response envelope of a GET request into v.
+func getData(t *testing.T, server *httptest.Server, path, cookie string, v any) {
+	t.Helper()
+	resp := doJSON(t, server, "GET", path, "", cookie)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s returned %d", path, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("decode %s: %v", path, err)
+	}
+}
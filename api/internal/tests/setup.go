@@ -35,6 +35,13 @@ func SetupTestApp(t *testing.T) (*httptest.Server, *gorm.DB, *config.ApiConfig,
 	if err != nil {
 		t.Fatalf("SetupTestApp: open sqlite: %v", err)
 	}
+	// Every connection opens its own in-memory database, a single one is shared by all requests,
+	// also by the files of a multipart upload stored in parallel
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("SetupTestApp: get sql db: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
 	db.Exec("PRAGMA foreign_keys = ON")
 
 	if err := db.AutoMigrate(
@@ -43,6 +50,8 @@ func SetupTestApp(t *testing.T) (*httptest.Server, *gorm.DB, *config.ApiConfig,
 		&models.Album{},
 		&models.AlbumMedia{},
 		&models.Favourite{},
+		&models.MediaMetadata{},
+		&models.Job{},
 	); err != nil {
 		t.Fatalf("SetupTestApp: auto-migrate: %v", err)
 	}
@@ -91,9 +100,28 @@ func SetupTestApp(t *testing.T) (*httptest.Server, *gorm.DB, *config.ApiConfig,
 			From:     "test@example.com",
 			Password: "",
 		},
+		Upload: &config.UploadConfig{
+			StagingDir:      t.TempDir(),
+			Expiration:      3600,
+			DuplicatePolicy: "existing",
+			Concurrency:     4,
+		},
+		Media: &config.MediaConfig{
+			ThumbnailSizes: []int{256, 512, 1600},
+			Transcode:      false, // ffmpeg in the test environment may not encode H.264
+			HlsHeights:     []int{360, 720},
+			GeoNamesFile:   "testdata/geonames_cities.txt",
+			JobWorkers:     0, // uploads are processed within the request, see TestJobs_* for the workers
+			JobMaxAttempts: 3,
+			JobRetryDelay:  1,
+		},
+		Trash: &config.TrashConfig{
+			Retention:     30,
+			PurgeInterval: 0, // purged explicitly by the tests
+		},
 	}
 
-	router := routes.Init(db, cfg)
+	router, _ := routes.Init(db, cfg)
 	server := httptest.NewServer(router)
 
 	return server, db, cfg, func() { server.Close() }
@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"embox/internal/models"
+	"embox/internal/repositories"
+	"embox/internal/services"
+)
+
+func TestStorageMigration_CopiesAndResumes(t *testing.T) {
+	_, db, cfg, teardown := SetupTestApp(t)
+	defer teardown()
+
+	src := services.NewLocalStorageAdapter(cfg.Storage.LocalDir)
+	dstDir := t.TempDir()
+	dst := services.NewLocalStorageAdapter(dstDir)
+	stateFile := filepath.Join(t.TempDir(), "migrate.state")
+
+	first := createTestMedia(t, db, nil)
+	second := createTestMedia(t, db, nil)
+	missing := createTestMedia(t, db, nil)
+	writeTestFile(t, filepath.Join(cfg.Storage.LocalDir, first.RemotePath()))
+	writeTestFile(t, filepath.Join(cfg.Storage.LocalDir, second.RemotePath()))
+
+	migration := services.NewStorageMigration(src, dst, repositories.NewMediaRepository(db), stateFile, 2)
+	report, err := migration.Run()
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if report.Total != 3 || report.Copied != 2 || report.Skipped != 0 {
+		t.Errorf("expected 3 total, 2 copied, 0 skipped, got %+v", report)
+	}
+	if len(report.Failed) != 1 || report.Failed[0].Id != missing.ID {
+		t.Errorf("expected media %d to fail, got %+v", missing.ID, report.Failed)
+	}
+	if report.Bytes != int64(2*len("orphan")) {
+		t.Errorf("expected %d bytes, got %d", 2*len("orphan"), report.Bytes)
+	}
+
+	for _, media := range []string{first.RemotePath(), second.RemotePath()} {
+		data, err := os.ReadFile(filepath.Join(dstDir, media))
+		if err != nil || !bytes.Equal(data, []byte("orphan")) {
+			t.Errorf("%s not copied: %q, %v", media, data, err)
+		}
+	}
+
+	// A second run only retries the file that failed
+	report, err = migration.Run()
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if report.Copied != 0 || report.Skipped != 2 || len(report.Failed) != 1 {
+		t.Errorf("expected 2 skipped and 1 failed on resume, got %+v", report)
+	}
+}
+
+func TestStorageMigration_RemovesCorruptCopies(t *testing.T) {
+	_, db, cfg, teardown := SetupTestApp(t)
+	defer teardown()
+
+	src := services.NewLocalStorageAdapter(cfg.Storage.LocalDir)
+	dstDir := t.TempDir()
+	stateFile := filepath.Join(t.TempDir(), "migrate.state")
+
+	// The source file changed since it was uploaded
+	changed := createTestMedia(t, db, nil)
+	db.Model(&models.Media{}).Where("id = ?", changed.ID).
+		Update("content_hash", "0000000000000000000000000000000000000000000000000000000000000000")
+	writeTestFile(t, filepath.Join(cfg.Storage.LocalDir, changed.RemotePath()))
+
+	migration := services.NewStorageMigration(src, services.NewLocalStorageAdapter(dstDir), repositories.NewMediaRepository(db), stateFile, 1)
+	report, err := migration.Run()
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(report.Failed) != 1 || !strings.Contains(report.Failed[0].Error, "source checksum") {
+		t.Errorf("expected a source checksum failure, got %+v", report.Failed)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, changed.RemotePath())); !os.IsNotExist(err) {
+		t.Errorf("expected the copy with the wrong checksum to be removed, got %v", err)
+	}
+
+	// The destination stored something else than was sent
+	db.Model(&models.Media{}).Where("id = ?", changed.ID).Update("content_hash", "")
+	migration = services.NewStorageMigration(src, corruptingStorage{services.NewLocalStorageAdapter(dstDir)}, repositories.NewMediaRepository(db), stateFile, 1)
+	report, err = migration.Run()
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(report.Failed) != 1 || !strings.Contains(report.Failed[0].Error, "verification failed") {
+		t.Errorf("expected a verification failure, got %+v", report.Failed)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, changed.RemotePath())); !os.IsNotExist(err) {
+		t.Errorf("expected the corrupt copy to be removed, got %v", err)
+	}
+}
+
+// corruptingStorage appends a byte to every uploaded file.
+type corruptingStorage struct {
+	*services.LocalStorageAdapter
+}
+
+func (s corruptingStorage) Upload(r io.Reader, size int64, filePath string) error {
+	return s.LocalStorageAdapter.Upload(io.MultiReader(r, strings.NewReader("!")), size+1, filePath)
+}
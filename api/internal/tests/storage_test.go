@@ -0,0 +1,536 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"embox/internal/config"
+	"embox/internal/services"
+
+	"golang.org/x/net/webdav"
+)
+
+// newFakeS3Server starts an in-process S3 stand-in for path-style requests on a single bucket.
+// It supports PUT (including server-side copy), GET with Range, ListObjectsV2 and DELETE, and rejects unsigned requests.
+func newFakeS3Server(t *testing.T, bucket string) *httptest.Server {
+	t.Helper()
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=test-key/") || r.Header.Get("X-Amz-Date") == "" {
+			http.Error(w, "<Error><Code>AccessDenied</Code></Error>", http.StatusForbidden)
+			return
+		}
+
+		key, ok := strings.CutPrefix(r.URL.Path, "/"+bucket+"/")
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.Method {
+		case "PUT":
+			if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+				sourceKey, _ := url.PathUnescape(strings.TrimPrefix(source, "/"+bucket+"/"))
+				data, exists := objects[sourceKey]
+				if !exists {
+					http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+					return
+				}
+				objects[key] = data
+				io.WriteString(w, "<CopyObjectResult></CopyObjectResult>")
+				return
+			}
+			data, _ := io.ReadAll(r.Body)
+			objects[key] = data
+		case "GET":
+			if key == "" && r.URL.Query().Get("list-type") == "2" {
+				io.WriteString(w, "<ListBucketResult>")
+				for objectKey := range objects {
+					fmt.Fprintf(w, "<Contents><Key>%s</Key></Contents>", objectKey)
+				}
+				io.WriteString(w, "<IsTruncated>false</IsTruncated></ListBucketResult>")
+				return
+			}
+			data, exists := objects[key]
+			if !exists {
+				http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+				return
+			}
+			http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(data))
+		case "DELETE":
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestS3Storage_RoundTrip(t *testing.T) {
+	server := newFakeS3Server(t, "embox")
+	storage := services.NewStorage(&config.StorageConfig{
+		Adapter:     "s3",
+		S3Endpoint:  server.URL,
+		S3Region:    "garage",
+		S3Bucket:    "embox",
+		S3AccessKey: "test-key",
+		S3SecretKey: "test-secret",
+		S3PathStyle: true,
+	})
+
+	content := []byte("0123456789abcdefghij")
+	if err := storage.Upload(bytes.NewReader(content), int64(len(content)), "2024/06/01_1.mp4"); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	files, err := storage.List()
+	if err != nil || len(files) != 1 || files[0] != "2024/06/01_1.mp4" {
+		t.Errorf("expected list with the uploaded file, got %v (%v)", files, err)
+	}
+
+	data, _, err := storage.Download("2024/06/01_1.mp4")
+	if err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("expected %q, got %q", content, data)
+	}
+
+	// Range requests are passed through to S3
+	resp, err := storage.DownloadStream("2024/06/01_1.mp4", http.Header{"Range": {"bytes=10-13"}})
+	if err != nil {
+		t.Fatalf("download stream: %v", err)
+	}
+	partial, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(partial) != "abcd" {
+		t.Errorf("expected 206 with %q, got %d with %q", "abcd", resp.StatusCode, partial)
+	}
+
+	if err := storage.Move("2024/06/01_1.mp4", "2023/12/24_1.mp4"); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	if _, _, err := storage.Download("2024/06/01_1.mp4"); err == nil {
+		t.Error("expected old path to be gone after move")
+	}
+
+	if err := storage.Delete("2023/12/24_1.mp4"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, _, err := storage.Download("2023/12/24_1.mp4"); err == nil {
+		t.Error("expected object to be gone after delete")
+	}
+}
+
+func TestWebdavStorage_RoundTrip(t *testing.T) {
+	dav := &webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "test-user" || pass != "test-pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		dav.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	storage := services.NewStorage(&config.StorageConfig{
+		Adapter:   "webdav",
+		WebdavUrl: server.URL + "/",
+		Username:  "test-user",
+		Password:  "test-pass",
+	})
+
+	// Parent directories are created on upload
+	content := []byte("0123456789abcdefghij")
+	if err := storage.Upload(bytes.NewReader(content), int64(len(content)), "2024/06/01_1.mp4"); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	files, err := storage.List()
+	if err != nil || len(files) != 1 || files[0] != "2024/06/01_1.mp4" {
+		t.Errorf("expected list with the uploaded file, got %v (%v)", files, err)
+	}
+
+	resp, err := storage.DownloadStream("2024/06/01_1.mp4", http.Header{"Range": {"bytes=10-13"}})
+	if err != nil {
+		t.Fatalf("download stream: %v", err)
+	}
+	partial, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(partial) != "abcd" {
+		t.Errorf("expected 206 with %q, got %d with %q", "abcd", resp.StatusCode, partial)
+	}
+
+	if err := storage.Move("2024/06/01_1.mp4", "2023/12/24_1.mp4"); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	data, _, err := storage.Download("2023/12/24_1.mp4")
+	if err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("expected moved file with original content, got %q (%v)", data, err)
+	}
+
+	if err := storage.Delete("2023/12/24_1.mp4"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	exists, err := storage.(*services.WebdavStorageAdapter).Exists("2023/12/24_1.mp4")
+	if err != nil || exists {
+		t.Errorf("expected file to be gone after delete, exists=%v err=%v", exists, err)
+	}
+}
+
+// fakeSeafile is an in-process stand-in for the Seafile API used by StorageService.
+// Only the latest issued token is accepted; statuses queued in fail are answered to the next API requests.
+type fakeSeafile struct {
+	*httptest.Server
+	mu              sync.Mutex
+	token           string
+	logins          int
+	fail            []int
+	retryAfter      string
+	revokeAfterLink bool // revoke the token once an upload link has been handed out
+	apiCalls        int
+	files           map[string][]byte
+}
+
+func newFakeSeafile(t *testing.T) *fakeSeafile {
+	t.Helper()
+	f := &fakeSeafile{files: map[string][]byte{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeSeafile) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/auth-token/" {
+		f.logins++
+		f.token = fmt.Sprintf("token-%d", f.logins)
+		fmt.Fprintf(w, `{"token":%q}`, f.token)
+		return
+	}
+	if path, ok := strings.CutPrefix(r.URL.Path, "/files/"); ok {
+		http.ServeContent(w, r, path, time.Time{}, bytes.NewReader(f.files[path]))
+		return
+	}
+
+	f.apiCalls++
+	if len(f.fail) > 0 {
+		status := f.fail[0]
+		f.fail = f.fail[1:]
+		if f.retryAfter != "" {
+			w.Header().Set("Retry-After", f.retryAfter)
+		}
+		w.WriteHeader(status)
+		return
+	}
+	// Rejected before the body is read, so a client expecting 100-continue does not send it
+	if r.Header.Get("Authorization") != "Token "+f.token {
+		http.Error(w, `{"detail":"Invalid token"}`, http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/repos/repo/upload-link/":
+		fmt.Fprintf(w, "%q", f.URL+"/upload-api")
+		if f.revokeAfterLink {
+			f.revokeAfterLink = false
+			f.token = "revoked"
+		}
+	case r.URL.Path == "/upload-api":
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		f.files[strings.TrimPrefix(r.FormValue("relative_path"), "/")+header.Filename] = data
+	case r.URL.Path == "/repos/repo/file/" && r.Method == "GET":
+		fmt.Fprintf(w, "%q", f.URL+"/files/"+strings.TrimPrefix(r.URL.Query().Get("p"), "/"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// revoke invalidates the issued token, as if it had expired.
+func (f *fakeSeafile) revoke() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.token = "revoked"
+}
+
+func (f *fakeSeafile) storage(maxRetries int) *services.StorageService {
+	return services.NewStorageService(&config.StorageConfig{
+		Url:        f.URL,
+		Username:   "test-user",
+		Password:   "test-pass",
+		RepoID:     "repo",
+		Timeout:    10,
+		MaxRetries: maxRetries,
+		RetryDelay: 1,
+	})
+}
+
+func TestSeafileStorage_TokenRefresh(t *testing.T) {
+	seafile := newFakeSeafile(t)
+	storage := seafile.storage(0)
+
+	content := []byte("0123456789abcdefghij")
+	if err := storage.Upload(bytes.NewReader(content), int64(len(content)), "2024/06/01_1.jpg"); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	// A revoked token is dropped and requested again once
+	seafile.revoke()
+	data, _, err := storage.Download("2024/06/01_1.jpg")
+	if err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("expected download after token refresh, got %q (%v)", data, err)
+	}
+	if seafile.logins != 2 {
+		t.Errorf("expected 2 logins, got %d", seafile.logins)
+	}
+
+	// Uploads rejected with a revoked token are sent again with a new one,
+	// also from readers that cannot be rewound, as the content has not been sent yet
+	seafile.revokeAfterLink = true
+	stream := io.MultiReader(bytes.NewReader(content))
+	if err := storage.Upload(stream, int64(len(content)), "2024/06/02_2.jpg"); err != nil {
+		t.Fatalf("upload after token refresh: %v", err)
+	}
+	if !bytes.Equal(seafile.files["2024/06/02_2.jpg"], content) || seafile.logins != 3 {
+		t.Errorf("expected uploaded file after a third login, got %q after %d logins", seafile.files["2024/06/02_2.jpg"], seafile.logins)
+	}
+
+	// A token that is rejected again is not refreshed in a loop
+	seafile.fail = []int{http.StatusForbidden, http.StatusForbidden}
+	if _, _, err := storage.Download("2024/06/01_1.jpg"); err == nil {
+		t.Error("expected download to fail when the new token is rejected as well")
+	}
+	if seafile.logins != 4 {
+		t.Errorf("expected a single refresh, got %d logins", seafile.logins)
+	}
+}
+
+func TestSeafileStorage_Retry(t *testing.T) {
+	seafile := newFakeSeafile(t)
+	storage := seafile.storage(2)
+
+	content := []byte("retried")
+	if err := storage.Upload(bytes.NewReader(content), int64(len(content)), "2024/06/01_1.jpg"); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	// 429 and 5xx responses are retried
+	seafile.apiCalls = 0
+	seafile.fail = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+	if data, _, err := storage.Download("2024/06/01_1.jpg"); err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("expected download after retries, got %q (%v)", data, err)
+	}
+	if seafile.apiCalls != 3 {
+		t.Errorf("expected 3 requests, got %d", seafile.apiCalls)
+	}
+
+	// The delay requested by Retry-After replaces the backoff
+	seafile.fail = []int{http.StatusTooManyRequests}
+	seafile.retryAfter = "1"
+	start := time.Now()
+	if _, _, err := storage.Download("2024/06/01_1.jpg"); err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("expected the retry to wait for Retry-After, took %v", elapsed)
+	}
+	seafile.retryAfter = ""
+
+	// After MaxRetries the last response is returned
+	seafile.apiCalls = 0
+	seafile.fail = []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable}
+	if _, _, err := storage.Download("2024/06/01_1.jpg"); err == nil {
+		t.Error("expected download to fail after the retries")
+	}
+	if seafile.apiCalls != 3 {
+		t.Errorf("expected 3 requests, got %d", seafile.apiCalls)
+	}
+
+	// Client errors are not retried
+	seafile.apiCalls = 0
+	seafile.fail = []int{http.StatusBadRequest}
+	if _, _, err := storage.Download("2024/06/01_1.jpg"); err == nil || seafile.apiCalls != 1 {
+		t.Errorf("expected a single failed request, got %d (%v)", seafile.apiCalls, err)
+	}
+}
+
+func TestEncryptedStorage_RoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	content := make([]byte, 200_000) // several 64 KiB chunks
+	for i := range content {
+		content[i] = byte(i * 31)
+	}
+
+	storages := map[string]services.Storage{
+		"local":        services.NewLocalStorageAdapter(t.TempDir()),
+		"ignore-range": ignoreRangeStorage{services.NewLocalStorageAdapter(t.TempDir())},
+		"s3": services.NewS3StorageAdapter(&config.StorageConfig{
+			S3Endpoint:  newFakeS3Server(t, "embox").URL,
+			S3Region:    "garage",
+			S3Bucket:    "embox",
+			S3AccessKey: "test-key",
+			S3SecretKey: "test-secret",
+			S3PathStyle: true,
+		}),
+	}
+
+	for name, inner := range storages {
+		t.Run(name, func(t *testing.T) {
+			storage := services.NewEncryptedStorage(inner, key)
+			if err := storage.Upload(bytes.NewReader(content), int64(len(content)), "2024/06/01_1.mp4"); err != nil {
+				t.Fatalf("upload: %v", err)
+			}
+
+			raw, _, err := inner.Download("2024/06/01_1.mp4")
+			if err != nil {
+				t.Fatalf("raw download: %v", err)
+			}
+			if bytes.Contains(raw, content[1000:1100]) {
+				t.Error("stored file contains plaintext")
+			}
+
+			data, _, err := storage.Download("2024/06/01_1.mp4")
+			if err != nil || !bytes.Equal(data, content) {
+				t.Fatalf("decrypted content differs from original (%v)", err)
+			}
+
+			for _, tc := range []struct {
+				rangeHeader string
+				start, end  int
+			}{
+				{"bytes=65530-65545", 65530, 65545}, // crosses a chunk boundary
+				{"bytes=199990-", 199990, 199999},
+				{"bytes=-10", 199990, 199999},
+				{"bytes=0-0", 0, 0},
+			} {
+				resp, err := storage.DownloadStream("2024/06/01_1.mp4", http.Header{"Range": {tc.rangeHeader}})
+				if err != nil {
+					t.Fatalf("%s: %v", tc.rangeHeader, err)
+				}
+				partial, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				want := fmt.Sprintf("bytes %d-%d/%d", tc.start, tc.end, len(content))
+				if err != nil || resp.StatusCode != http.StatusPartialContent || resp.Header.Get("Content-Range") != want ||
+					!bytes.Equal(partial, content[tc.start:tc.end+1]) {
+					t.Errorf("%s: got %d %q (%v), want 206 %q", tc.rangeHeader, resp.StatusCode, resp.Header.Get("Content-Range"), err, want)
+				}
+			}
+
+			resp, err := storage.DownloadStream("2024/06/01_1.mp4", http.Header{"Range": {"bytes=300000-"}})
+			if err != nil || resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+				t.Errorf("expected 416 for a range past the end, got %v (%v)", resp, err)
+			}
+
+			// Tampered ciphertext must not decrypt
+			raw[len(raw)/2] ^= 1
+			if err := inner.Upload(bytes.NewReader(raw), int64(len(raw)), "2024/06/01_2.mp4"); err != nil {
+				t.Fatalf("raw upload: %v", err)
+			}
+			if _, _, err := storage.Download("2024/06/01_2.mp4"); err == nil {
+				t.Error("expected tampered file to fail decryption")
+			}
+
+			// Files stored before encryption was enabled are returned unchanged
+			if err := inner.Upload(bytes.NewReader([]byte("legacy")), 6, "2024/06/01_3.jpg"); err != nil {
+				t.Fatalf("raw upload: %v", err)
+			}
+			if legacy, _, err := storage.Download("2024/06/01_3.jpg"); err != nil || string(legacy) != "legacy" {
+				t.Errorf("expected unencrypted file to pass through, got %q (%v)", legacy, err)
+			}
+		})
+	}
+}
+
+// ignoreRangeStorage answers every download with the whole file, like storages without range support.
+type ignoreRangeStorage struct {
+	services.Storage
+}
+
+func (s ignoreRangeStorage) DownloadStream(path string, _ http.Header) (*http.Response, error) {
+	return s.Storage.DownloadStream(path, nil)
+}
+
+func TestLocalStorage_RangeAndConditional(t *testing.T) {
+	storage := services.NewLocalStorageAdapter(t.TempDir())
+	content := []byte("0123456789abcdefghij")
+	if err := storage.Upload(bytes.NewReader(content), int64(len(content)), "2024/06/01_1.mp4"); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	download := func(headers http.Header) (*http.Response, string) {
+		t.Helper()
+		resp, err := storage.DownloadStream("2024/06/01_1.mp4", headers)
+		if err != nil {
+			t.Fatalf("download stream: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	resp, body := download(nil)
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || body != string(content) || etag == "" || resp.Header.Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("expected 200 with full content, ETag and Accept-Ranges, got %d %q %v", resp.StatusCode, body, resp.Header)
+	}
+	if resp.Header.Get("Content-Type") != "video/mp4" {
+		t.Errorf("expected video/mp4, got %q", resp.Header.Get("Content-Type"))
+	}
+
+	resp, body = download(http.Header{"Range": {"bytes=10-13"}})
+	if resp.StatusCode != http.StatusPartialContent || body != "abcd" || resp.Header.Get("Content-Range") != "bytes 10-13/20" {
+		t.Errorf("expected 206 bytes 10-13, got %d %q %q", resp.StatusCode, body, resp.Header.Get("Content-Range"))
+	}
+
+	resp, body = download(http.Header{"Range": {"bytes=-3"}})
+	if resp.StatusCode != http.StatusPartialContent || body != "hij" {
+		t.Errorf("expected suffix range, got %d %q", resp.StatusCode, body)
+	}
+
+	resp, _ = download(http.Header{"Range": {"bytes=50-"}})
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable || resp.Header.Get("Content-Range") != "bytes */20" {
+		t.Errorf("expected 416, got %d %q", resp.StatusCode, resp.Header.Get("Content-Range"))
+	}
+
+	resp, _ = download(http.Header{"If-None-Match": {etag}})
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("expected 304 for matching ETag, got %d", resp.StatusCode)
+	}
+
+	// A stale If-Range validator returns the whole file
+	resp, body = download(http.Header{"Range": {"bytes=10-13"}, "If-Range": {`"stale"`}})
+	if resp.StatusCode != http.StatusOK || body != string(content) {
+		t.Errorf("expected 200 with full content for stale If-Range, got %d %q", resp.StatusCode, body)
+	}
+	resp, body = download(http.Header{"Range": {"bytes=10-13"}, "If-Range": {etag}})
+	if resp.StatusCode != http.StatusPartialContent || body != "abcd" {
+		t.Errorf("expected 206 for current If-Range, got %d %q", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,220 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"embox/internal/api/dto"
+	"embox/internal/services"
+)
+
+func TestStream_FallbackAndTranscodedFiles(t *testing.T) {
+	server, db, _, teardown := SetupTestApp(t)
+	defer teardown()
+
+	_, cookie := CreateTestUser(t, db, server)
+
+	media := createTestMedia(t, db, nil)
+	db.Model(media).Updates(map[string]any{"type": "video", "file_ext": "mov", "transcode_status": services.TranscodePending})
+	media.FileExt = "mov"
+
+	// While transcoding is pending, the original file is played instead
+	original := fmt.Sprintf("/media/%d/file", media.ID)
+	for _, path := range []string{"stream.m3u8", "video.mp4"} {
+		resp := doJSON(t, server, "GET", fmt.Sprintf("/media/%d/%s", media.ID, path), "", cookie)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusTemporaryRedirect || resp.Header.Get("Location") != original {
+			t.Errorf("%s: expected redirect to file, got %d %q", path, resp.StatusCode, resp.Header.Get("Location"))
+		}
+	}
+
+	hlsDir := filepath.Join(services.MediaDir, media.HlsDir())
+	if err := os.MkdirAll(hlsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		filepath.Join(hlsDir, "index.m3u8"):                    "#EXTM3U\nstream/360p.m3u8\n",
+		filepath.Join(hlsDir, "360p.m3u8"):                     "#EXTM3U\n360p_000.ts\n",
+		filepath.Join(hlsDir, "360p_000.ts"):                   "segment",
+		filepath.Join(services.MediaDir, media.WebVideoPath()): "mp4",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Model(media).Update("transcode_status", services.TranscodeDone)
+
+	for _, tc := range []struct {
+		path        string
+		contentType string
+		body        string
+	}{
+		{"stream.m3u8", "application/vnd.apple.mpegurl", "#EXTM3U\nstream/360p.m3u8\n"},
+		{"stream/360p.m3u8", "application/vnd.apple.mpegurl", "#EXTM3U\n360p_000.ts\n"},
+		{"stream/360p_000.ts", "video/mp2t", "segment"},
+		{"video.mp4", "video/mp4", "mp4"},
+	} {
+		resp := doJSON(t, server, "GET", fmt.Sprintf("/media/%d/%s", media.ID, tc.path), "", cookie)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", tc.path, resp.StatusCode)
+			continue
+		}
+		if got := resp.Header.Get("Content-Type"); got != tc.contentType {
+			t.Errorf("%s: expected content type %q, got %q", tc.path, tc.contentType, got)
+		}
+		if string(body) != tc.body {
+			t.Errorf("%s: expected body %q, got %q", tc.path, tc.body, body)
+		}
+	}
+
+	// Only files of the HLS ladder are served
+	for _, path := range []string{"stream/index.m3u8", "stream/..%2F..%2Fsecret.ts", "stream/360p.mp4"} {
+		resp := doJSON(t, server, "GET", fmt.Sprintf("/media/%d/%s", media.ID, path), "", cookie)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestVideoPreview_Files(t *testing.T) {
+	server, db, _, teardown := SetupTestApp(t)
+	defer teardown()
+
+	_, cookie := CreateTestUser(t, db, server)
+
+	media := createTestMedia(t, db, nil)
+	db.Model(media).Update("type", "video")
+
+	// Previews that could not be created yet are missing
+	resp := doJSON(t, server, "GET", fmt.Sprintf("/media/%d/preview", media.ID), "", cookie)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for missing preview, got %d", resp.StatusCode)
+	}
+
+	vtt := "WEBVTT\n\n00:00:00.000 --> 00:00:01.000\nsprites.jpg#xywh=0,0,160,90\n"
+	files := map[string]string{
+		media.PreviewPath():    "preview",
+		media.SpritesPath():    "sprites",
+		media.SpritesVttPath(): vtt,
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(services.MediaDir, path)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(services.MediaDir, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		path        string
+		contentType string
+		body        string
+	}{
+		{"preview", "image/webp", "preview"},
+		{"sprites.vtt", "text/vtt; charset=utf-8", vtt},
+		{"sprites.jpg", "image/jpeg", "sprites"},
+	} {
+		resp := doJSON(t, server, "GET", fmt.Sprintf("/media/%d/%s", media.ID, tc.path), "", cookie)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", tc.path, resp.StatusCode)
+			continue
+		}
+		if got := resp.Header.Get("Content-Type"); got != tc.contentType {
+			t.Errorf("%s: expected content type %q, got %q", tc.path, tc.contentType, got)
+		}
+		if string(body) != tc.body {
+			t.Errorf("%s: expected body %q, got %q", tc.path, tc.body, body)
+		}
+	}
+}
+
+func TestVideoPreview_Generated(t *testing.T) {
+	server, db, _, teardown := SetupTestApp(t)
+	defer teardown()
+
+	_, cookie := CreateTestUser(t, db, server)
+	video := createTestVideo(t, 12)
+
+	resp := doMultipart(t, server, "/media/", func(w *multipart.Writer) {
+		part, _ := w.CreateFormFile("files", "clip.mp4")
+		part.Write(video)
+		w.WriteField("meta", `[{"fileName":"clip.mp4","type":"video/mp4","date":"2024-06-01T12:00:00Z","caption":""}]`)
+	}, cookie)
+	var uploaded struct {
+		Data []dto.MediaUploadResultDto `json:"data"`
+	}
+	json.NewDecoder(resp.Body).Decode(&uploaded)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(uploaded.Data) != 1 {
+		t.Fatalf("upload returned %d", resp.StatusCode)
+	}
+	id := uploaded.Data[0].Id
+
+	get := func(name string) []byte {
+		t.Helper()
+		resp := doJSON(t, server, "GET", fmt.Sprintf("/media/%d/%s", id, name), "", cookie)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", name, resp.StatusCode)
+		}
+		return body
+	}
+
+	if preview := get("preview"); len(preview) < 12 || string(preview[8:12]) != "WEBP" {
+		t.Errorf("expected animated webp preview")
+	}
+
+	// 12 frames of one second: 10 columns in 2 rows of 160x90 sprites
+	sheet, err := jpeg.DecodeConfig(bytes.NewReader(get("sprites.jpg")))
+	if err != nil {
+		t.Fatalf("decode sprites: %v", err)
+	}
+	if sheet.Width != 10*160 || sheet.Height != 2*90 {
+		t.Errorf("expected 1600x180 sprite sheet, got %dx%d", sheet.Width, sheet.Height)
+	}
+	cues := strings.Split(strings.TrimPrefix(string(get("sprites.vtt")), "WEBVTT\n\n"), "\n\n")
+	if len(cues) != 12 {
+		t.Fatalf("expected 12 cues, got %d", len(cues))
+	}
+	if want := "00:00:11.000 --> 00:00:12.000\nsprites.jpg#xywh=160,90,160,90\n"; cues[11] != want {
+		t.Errorf("expected last cue %q, got %q", want, cues[11])
+	}
+}
+
+// createTestVideo returns an MPEG-4 test pattern video of 320x180 pixels, skipping the test
+// if ffmpeg cannot create it.
+func createTestVideo(t *testing.T, seconds int) []byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "clip.mp4")
+	out, err := exec.Command("ffmpeg", "-y", "-loglevel", "error",
+		"-f", "lavfi", "-i", fmt.Sprintf("testsrc=duration=%d:size=320x180:rate=10", seconds),
+		"-pix_fmt", "yuv420p", "-c:v", "mpeg4", "-g", "10", path, // a keyframe per second for the sprites
+	).CombinedOutput()
+	if err != nil {
+		t.Skipf("ffmpeg cannot create a test video: %v %s", err, out)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,294 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"embox/internal/api/dto"
+	"embox/internal/models"
+	"embox/internal/services"
+
+	"github.com/chai2010/webp"
+)
+
+func TestThumbnail_RenditionsAndBackfill(t *testing.T) {
+	server, db, cfg, teardown := SetupTestApp(t)
+	defer teardown()
+
+	_, cookie := CreateTestUser(t, db, server)
+
+	var imgData bytes.Buffer
+	png.Encode(&imgData, image.NewRGBA(image.Rect(0, 0, 2000, 100)))
+
+	meta := `[{"fileName":"wide.png","type":"image/png","date":"2024-06-01T12:00:00Z","caption":""}]`
+	resp := doMultipart(t, server, "/media/", func(w *multipart.Writer) {
+		part, _ := w.CreateFormFile("files", "wide.png")
+		part.Write(imgData.Bytes())
+		w.WriteField("meta", meta)
+	}, cookie)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload returned %d", resp.StatusCode)
+	}
+	var media models.Media
+	db.Order("id DESC").First(&media)
+
+	// Renditions are limited to the image width, the default thumbnail has 512px
+	for _, tc := range []struct {
+		query string
+		width int
+	}{
+		{"", 512},
+		{"?size=200", 256},
+		{"?size=1200", 1600},
+		{"?size=5000", 1600},
+	} {
+		width, cacheControl := getThumbnailWidth(t, server, media.ID, tc.query, cookie)
+		if width != tc.width {
+			t.Errorf("thumbnail%s: expected width %d, got %d", tc.query, tc.width, width)
+		}
+		if !strings.Contains(cacheControl, "immutable") {
+			t.Errorf("thumbnail%s: expected immutable caching, got %q", tc.query, cacheControl)
+		}
+	}
+
+	resp = doJSON(t, server, "GET", fmt.Sprintf("/media/%d/thumbnail?size=abc", media.ID), "", cookie)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid size, got %d", resp.StatusCode)
+	}
+
+	// A missing rendition falls back to the nearest existing one until it is backfilled
+	os.Remove(filepath.Join(services.MediaDir, fmt.Sprintf("%s_1600.webp", media.BasePath())))
+	width, cacheControl := getThumbnailWidth(t, server, media.ID, "?size=1200", cookie)
+	if width != 512 || strings.Contains(cacheControl, "immutable") {
+		t.Errorf("expected 512px fallback without immutable caching, got %dpx, %q", width, cacheControl)
+	}
+
+	report := runBackfill(t, db, cfg)
+	if len(report.Thumbnails) != 1 || report.Thumbnails[0] != media.ID || len(report.Failed) != 0 {
+		t.Errorf("expected renditions of media %d to be backfilled, got %+v", media.ID, report)
+	}
+	if width, _ := getThumbnailWidth(t, server, media.ID, "?size=1200", cookie); width != 1600 {
+		t.Errorf("expected backfilled 1600px rendition, got %d", width)
+	}
+}
+
+func getThumbnailWidth(t *testing.T, server *httptest.Server, id uint, query, cookie string) (int, string) {
+	t.Helper()
+	resp := doJSON(t, server, "GET", fmt.Sprintf("/media/%d/thumbnail%s", id, query), "", cookie)
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("thumbnail%s returned %d", query, resp.StatusCode)
+	}
+
+	config, err := webp.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("thumbnail%s: decode webp: %v", query, err)
+	}
+	return config.Width, resp.Header.Get("Cache-Control")
+}
+
+func TestThumbnail_AcceptNegotiation(t *testing.T) {
+	server, db, _, teardown := SetupTestApp(t)
+	defer teardown()
+
+	_, cookie := CreateTestUser(t, db, server)
+	meta := `[{"fileName":"test.png","type":"image/png","date":"2024-06-01T12:00:00Z","caption":""}]`
+	resp := doMultipart(t, server, "/media/", func(w *multipart.Writer) {
+		part, _ := w.CreateFormFile("files", "test.png")
+		part.Write(createTestPNG())
+		w.WriteField("meta", meta)
+	}, cookie)
+	resp.Body.Close()
+	var media models.Media
+	db.Order("id DESC").First(&media)
+
+	for _, tc := range []struct {
+		accept       string
+		contentTypes []string
+	}{
+		{"", []string{"image/webp"}},
+		{"image/webp,*/*", []string{"image/webp"}},
+		// Safari on iOS 13 does not list webp
+		{"image/png,image/svg+xml,image/*;q=0.8,video/*;q=0.8,*/*;q=0.5", []string{"image/jpeg"}},
+		{"image/jpeg", []string{"image/jpeg"}},
+		{"image/webp;q=0,*/*", []string{"image/jpeg"}},
+		// AVIF needs an ffmpeg with AV1 encoder, otherwise WebP is sent
+		{"image/avif,image/webp,image/apng,image/*,*/*;q=0.8", []string{"image/avif", "image/webp"}},
+	} {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("%s/media/%d/thumbnail", server.URL, media.ID), nil)
+		req.AddCookie(&http.Cookie{Name: "access_token", Value: cookie})
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET thumbnail: %v", err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		contentType := resp.Header.Get("Content-Type")
+		if !slices.Contains(tc.contentTypes, contentType) {
+			t.Errorf("Accept %q: expected %v, got %q", tc.accept, tc.contentTypes, contentType)
+		}
+		if resp.Header.Get("Vary") != "Accept" {
+			t.Errorf("Accept %q: expected Vary: Accept, got %q", tc.accept, resp.Header.Get("Vary"))
+		}
+		if contentType == "image/jpeg" {
+			if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+				t.Errorf("Accept %q: invalid jpeg: %v", tc.accept, err)
+			}
+		}
+	}
+
+	variantPath := filepath.Join(services.MediaDir, media.BasePath()+".jpg")
+	if _, err := os.Stat(variantPath); err != nil {
+		t.Errorf("expected jpeg variant to be cached: %v", err)
+	}
+
+	// Concurrent first requests each convert into their own temp file
+	os.Remove(variantPath)
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			contentType, data := getThumbnail(t, server, media.ID, "image/jpeg", cookie)
+			if _, err := jpeg.Decode(bytes.NewReader(data)); contentType != "image/jpeg" || err != nil {
+				t.Errorf("concurrent request: expected jpeg, got %q (%v)", contentType, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if leftovers, _ := filepath.Glob(variantPath + ".*.tmp"); len(leftovers) > 0 {
+		t.Errorf("expected no temp files, got %v", leftovers)
+	}
+
+	// The fallback for unknown media is sent in the negotiated format as well
+	if contentType, _ := getThumbnail(t, server, media.ID+1000, "image/jpeg", cookie); contentType != "image/jpeg" {
+		t.Errorf("expected jpeg fallback thumbnail, got %q", contentType)
+	}
+}
+
+func getThumbnail(t *testing.T, server *httptest.Server, id uint, accept, cookie string) (string, []byte) {
+	t.Helper()
+	req, _ := http.NewRequest("GET", fmt.Sprintf("%s/media/%d/thumbnail", server.URL, id), nil)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: cookie})
+	req.Header.Set("Accept", accept)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("GET thumbnail: %v", err)
+		return "", nil
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.Header.Get("Content-Type"), data
+}
+
+func TestThumbnail_RawPreview(t *testing.T) {
+	server, db, _, teardown := SetupTestApp(t)
+	defer teardown()
+
+	_, cookie := CreateTestUser(t, db, server)
+
+	// Browsers upload RAW files without MIME type, the extension makes them images
+	resp := doMultipart(t, server, "/media/", func(w *multipart.Writer) {
+		part, _ := w.CreateFormFile("files", "DSC_0001.NEF")
+		part.Write(createTestRawFile(t))
+		w.WriteField("meta", `[{"fileName":"DSC_0001.NEF","type":"application/octet-stream","caption":""}]`)
+	}, cookie)
+	var uploaded struct {
+		Data []dto.MediaResponseDto `json:"data"`
+	}
+	json.NewDecoder(resp.Body).Decode(&uploaded)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(uploaded.Data) != 1 {
+		t.Fatalf("upload returned %d", resp.StatusCode)
+	}
+	media := uploaded.Data[0]
+	if media.Type != "image" {
+		t.Errorf("expected image, got %q", media.Type)
+	}
+
+	// The 40x20 preview from the SubIFD, not the 8x4 one of IFD0, rotated by orientation 6
+	if width, _ := getThumbnailWidth(t, server, media.Id, "", cookie); width != 20 {
+		t.Errorf("expected thumbnail of the rotated large preview (20px), got %dpx", width)
+	}
+
+	resp = doJSON(t, server, "GET", fmt.Sprintf("/media/%d", media.Id), "", cookie)
+	var detail struct {
+		Data dto.MediaDetailResponseDto `json:"data"`
+	}
+	json.NewDecoder(resp.Body).Decode(&detail)
+	resp.Body.Close()
+	if m := detail.Data.Metadata; m == nil || m.CameraMake != "Embox" || m.Width != 3000 || m.Height != 6000 {
+		t.Errorf("expected metadata with sensor size 3000x6000, got %+v", m)
+	}
+	if !strings.HasPrefix(detail.Data.Date, "2023-07-14T18:30:00") {
+		t.Errorf("expected capture date from EXIF, got %q", detail.Data.Date)
+	}
+}
+
+// createTestRawFile returns a TIFF based RAW file with an 8x4 JPEG preview in IFD0 and a 40x20 one in a SubIFD,
+// orientation 6 and the 6000x3000 sensor size in the Exif IFD.
+func createTestRawFile(t *testing.T) []byte {
+	t.Helper()
+	encode := func(width, height int) []byte {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+			t.Fatalf("encode jpeg: %v", err)
+		}
+		return buf.Bytes()
+	}
+	small, large := encode(8, 4), encode(40, 20)
+
+	ifd0 := []exifEntry{
+		exifASCII(0x010F, "Embox"),
+		exifShort(0x0112, 6),
+		exifLong(0x014A, 0), // SubIFDs, set below
+		exifLong(0x0201, 0), // JPEGInterchangeFormat, set below
+		exifLong(0x0202, uint32(len(small))),
+		exifLong(0x8769, 0), // ExifIFDPointer, set below
+	}
+	exifIFD := []exifEntry{
+		exifASCII(0x9003, "2023:07:14 18:30:00"),
+		exifLong(0xA002, 6000), // PixelXDimension
+		exifLong(0xA003, 3000), // PixelYDimension
+	}
+	subIFD := []exifEntry{
+		exifLong(0x0201, 0), // set below
+		exifLong(0x0202, uint32(len(large))),
+	}
+	exifOffset := 8 + exifIFDSize(ifd0)
+	subOffset := exifOffset + exifIFDSize(exifIFD)
+	smallOffset := subOffset + exifIFDSize(subIFD)
+	largeOffset := smallOffset + uint32(len(small))
+	ifd0[2] = exifLong(0x014A, subOffset)
+	ifd0[3] = exifLong(0x0201, smallOffset)
+	ifd0[5] = exifLong(0x8769, exifOffset)
+	subIFD[0] = exifLong(0x0201, largeOffset)
+
+	data := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	data = appendExifIFD(data, 8, ifd0)
+	data = appendExifIFD(data, exifOffset, exifIFD)
+	data = appendExifIFD(data, subOffset, subIFD)
+	data = append(data, small...)
+	return append(data, large...)
+}
@@ -0,0 +1,240 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"embox/internal/api/dto"
+	"embox/internal/config"
+	"embox/internal/models"
+	"embox/internal/repositories"
+	"embox/internal/services"
+
+	"gorm.io/gorm"
+)
+
+func TestTrash_DeleteAndRestoreMedia(t *testing.T) {
+	server, db, _, teardown := SetupTestApp(t)
+	defer teardown()
+
+	email, cookie := CreateTestUser(t, db, server)
+	user := getUserFromDB(t, db, email)
+	media := createTestMedia(t, db, &user.ID)
+
+	resp := doJSON(t, server, "DELETE", "/media/", fmt.Sprintf(`{"ids":[%d]}`, media.ID), cookie)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("delete returned %d", resp.StatusCode)
+	}
+
+	if ids := getMediaListIDs(t, server, cookie); len(ids) != 0 {
+		t.Errorf("expected deleted media to be hidden from the list, got %v", ids)
+	}
+
+	trash := getTrash(t, server, cookie)
+	if len(trash.Media) != 1 || trash.Media[0].Id != media.ID {
+		t.Fatalf("expected media %d in trash, got %+v", media.ID, trash.Media)
+	}
+	if want := trash.Media[0].DeletedAt.Add(30 * 24 * time.Hour); !trash.Media[0].PurgeAt.Equal(want) {
+		t.Errorf("expected purge at %v, got %v", want, trash.Media[0].PurgeAt)
+	}
+
+	// Only the owner sees the media in the trash and may restore it, admins see the trash of all users
+	_, otherCookie := CreateTestUser(t, db, server)
+	if trash := getTrash(t, server, otherCookie); len(trash.Media) != 0 {
+		t.Errorf("expected another user's trash to be empty, got %+v", trash.Media)
+	}
+	adminEmail, adminCookie := CreateTestUser(t, db, server)
+	db.Model(&models.User{}).Where("email = ?", adminEmail).Update("is_admin", true)
+	if trash := getTrash(t, server, adminCookie); len(trash.Media) != 1 {
+		t.Errorf("expected admin to see media %d in trash, got %+v", media.ID, trash.Media)
+	}
+
+	body := fmt.Sprintf(`{"mediaIds":[%d]}`, media.ID)
+	resp = doJSON(t, server, "POST", "/trash/restore", body, otherCookie)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 when restoring another user's media, got %d", resp.StatusCode)
+	}
+
+	resp = doJSON(t, server, "POST", "/trash/restore", body, cookie)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("restore returned %d", resp.StatusCode)
+	}
+
+	if ids := getMediaListIDs(t, server, cookie); len(ids) != 1 || ids[0] != media.ID {
+		t.Errorf("expected restored media %d in list, got %v", media.ID, ids)
+	}
+	if trash := getTrash(t, server, cookie); len(trash.Media) != 0 {
+		t.Errorf("expected empty trash after restore, got %+v", trash.Media)
+	}
+}
+
+func TestTrash_PurgeRemovesFiles(t *testing.T) {
+	server, db, cfg, teardown := SetupTestApp(t)
+	defer teardown()
+
+	email, cookie := CreateTestUser(t, db, server)
+	user := getUserFromDB(t, db, email)
+	expired := createTestMedia(t, db, &user.ID)
+	recent := createTestMedia(t, db, &user.ID)
+
+	original := filepath.Join(cfg.Storage.LocalDir, expired.RemotePath())
+	thumbnail := filepath.Join(services.MediaDir, expired.Path())
+	writeTestFile(t, original)
+	writeTestFile(t, thumbnail)
+
+	resp := doJSON(t, server, "DELETE", "/media/", fmt.Sprintf(`{"ids":[%d,%d]}`, expired.ID, recent.ID), cookie)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("delete returned %d", resp.StatusCode)
+	}
+
+	// Files stay while the media is in the trash
+	if _, err := os.Stat(original); err != nil {
+		t.Fatalf("original removed before purge: %v", err)
+	}
+
+	db.Unscoped().Model(&models.Media{}).Where("id = ?", expired.ID).
+		UpdateColumn("deleted_at", time.Now().Add(-31*24*time.Hour))
+
+	media, albums, err := newTestTrashService(db, cfg).Purge(time.Now().Add(-30 * 24 * time.Hour))
+	if err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	if media != 1 || albums != 0 {
+		t.Errorf("expected 1 purged media and 0 albums, got %d and %d", media, albums)
+	}
+
+	if err := db.Unscoped().First(&models.Media{}, expired.ID).Error; err == nil {
+		t.Error("expired media still present in DB after purge")
+	}
+	if err := db.Unscoped().First(&models.Media{}, recent.ID).Error; err != nil {
+		t.Errorf("recently deleted media was purged: %v", err)
+	}
+	for _, path := range []string{original, thumbnail} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", path)
+		}
+	}
+}
+
+func TestTrash_AlbumMediaEntries(t *testing.T) {
+	server, db, cfg, teardown := SetupTestApp(t)
+	defer teardown()
+
+	email, cookie := CreateTestUser(t, db, server)
+	user := getUserFromDB(t, db, email)
+	cover := createTestMedia(t, db, &user.ID)
+	kept := createTestMedia(t, db, &user.ID)
+	album := &models.Album{Name: "Holiday", UserID: &user.ID}
+	db.Create(album)
+	db.Create(&models.AlbumMedia{AlbumID: album.ID, MediaID: cover.ID, IsCover: true})
+	db.Create(&models.AlbumMedia{AlbumID: album.ID, MediaID: kept.ID})
+
+	resp := doJSON(t, server, "DELETE", fmt.Sprintf("/album/%d", album.ID), "", cookie)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("delete album returned %d", resp.StatusCode)
+	}
+	resp = doJSON(t, server, "DELETE", "/media/", fmt.Sprintf(`{"ids":[%d]}`, cover.ID), cookie)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("delete media returned %d", resp.StatusCode)
+	}
+
+	// The cover is purged while the album is in the trash
+	db.Unscoped().Model(&models.Media{}).Where("id = ?", cover.ID).
+		UpdateColumn("deleted_at", time.Now().Add(-31*24*time.Hour))
+	trashService := newTestTrashService(db, cfg)
+	if media, albums, err := trashService.Purge(time.Now().Add(-30 * 24 * time.Hour)); err != nil || media != 1 || albums != 0 {
+		t.Fatalf("expected 1 purged media and 0 albums, got %d, %d, %v", media, albums, err)
+	}
+	countEntries := func() int64 {
+		var n int64
+		db.Model(&models.AlbumMedia{}).Where("album_id = ?", album.ID).Count(&n)
+		return n
+	}
+	if n := countEntries(); n != 1 {
+		t.Errorf("expected only the entry of media %d to remain, got %d entries", kept.ID, n)
+	}
+
+	body := fmt.Sprintf(`{"albumIds":[%d]}`, album.ID)
+	resp = doJSON(t, server, "POST", "/trash/restore", body, cookie)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("restore returned %d", resp.StatusCode)
+	}
+
+	var restored struct {
+		Data dto.AlbumResponseDto `json:"data"`
+	}
+	getData(t, server, fmt.Sprintf("/album/%d", album.ID), cookie, &restored)
+	if len(restored.Data.Media) != 1 || restored.Data.Media[0].Id != kept.ID {
+		t.Errorf("expected restored album with media %d, got %+v", kept.ID, restored.Data.Media)
+	}
+
+	// Purging the album removes its entries but keeps the media
+	resp = doJSON(t, server, "DELETE", fmt.Sprintf("/album/%d", album.ID), "", cookie)
+	resp.Body.Close()
+	db.Unscoped().Model(&models.Album{}).Where("id = ?", album.ID).
+		UpdateColumn("deleted_at", time.Now().Add(-31*24*time.Hour))
+	if media, albums, err := trashService.Purge(time.Now().Add(-30 * 24 * time.Hour)); err != nil || media != 0 || albums != 1 {
+		t.Fatalf("expected 0 purged media and 1 album, got %d, %d, %v", media, albums, err)
+	}
+	if n := countEntries(); n != 0 {
+		t.Errorf("expected no entries of the purged album, got %d", n)
+	}
+	if err := db.First(&models.Media{}, kept.ID).Error; err != nil {
+		t.Errorf("media of the purged album was deleted: %v", err)
+	}
+}
+
+func newTestTrashService(db *gorm.DB, cfg *config.ApiConfig) *services.TrashService {
+	repos := repositories.Init(db)
+	return services.NewTrashService(cfg.Trash, repos.User, repos.Media, repos.Album, newTestMediaService(db, cfg))
+}
+
+func getTrash(t *testing.T, server *httptest.Server, cookie string) dto.TrashResponseDto {
+	t.Helper()
+	resp := doJSON(t, server, "GET", "/trash/", "", cookie)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		t.Fatalf("trash returned %d: %s", resp.StatusCode, b)
+	}
+
+	var envelope struct {
+		Data dto.TrashResponseDto `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		t.Fatalf("decode trash: %v", err)
+	}
+	return envelope.Data
+}
+
+func getMediaListIDs(t *testing.T, server *httptest.Server, cookie string) []uint {
+	t.Helper()
+	resp := doJSON(t, server, "GET", "/media/", "", cookie)
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Data []dto.MediaResponseDto `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		t.Fatalf("decode media list: %v", err)
+	}
+	var ids []uint
+	for _, m := range envelope.Data {
+		ids = append(ids, m.Id)
+	}
+	return ids
+}
@@ -0,0 +1,233 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"embox/internal/models"
+	"embox/internal/services"
+)
+
+// doTus sends a tus request with CSRF token, auth cookie and the Tus-Resumable header.
+func doTus(t *testing.T, server *httptest.Server, method, path string, headers map[string]string, body []byte, cookie string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("doTus: NewRequest: %v", err)
+	}
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("X-XSRF-TOKEN", generateCSRFToken())
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: cookie})
+	resp, err := noRedirectClient.Do(req)
+	if err != nil {
+		t.Fatalf("doTus: Do: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp
+}
+
+func tusMetadata(pairs ...string) string {
+	var meta string
+	for i := 0; i < len(pairs); i += 2 {
+		if meta != "" {
+			meta += ","
+		}
+		meta += pairs[i] + " " + base64.StdEncoding.EncodeToString([]byte(pairs[i+1]))
+	}
+	return meta
+}
+
+func TestResumableUpload_InChunks(t *testing.T) {
+	server, db, cfg, teardown := SetupTestApp(t)
+	defer teardown()
+
+	_, cookie := CreateTestUser(t, db, server)
+	imgData := createTestPNG()
+
+	resp := doTus(t, server, "POST", "/media/uploads/", map[string]string{
+		"Upload-Length":   strconv.Itoa(len(imgData)),
+		"Upload-Metadata": tusMetadata("filename", "test.png", "filetype", "image/png", "date", "2024-06-01T12:00:00Z", "caption", "resumable"),
+	}, nil, cookie)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create returned %d", resp.StatusCode)
+	}
+	uploadPath := "/media/uploads/" + resp.Header.Get("Location")
+
+	// First chunk
+	half := len(imgData) / 2
+	resp = doTus(t, server, "PATCH", uploadPath, map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	}, imgData[:half], cookie)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("first patch returned %d", resp.StatusCode)
+	}
+
+	// Resume: the server must report the offset of the first chunk
+	resp = doTus(t, server, "HEAD", uploadPath, nil, nil, cookie)
+	if got := resp.Header.Get("Upload-Offset"); got != strconv.Itoa(half) {
+		t.Fatalf("expected Upload-Offset %d, got %q", half, got)
+	}
+
+	// A chunk at the wrong offset is rejected
+	resp = doTus(t, server, "PATCH", uploadPath, map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	}, imgData, cookie)
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected 409 for offset mismatch, got %d", resp.StatusCode)
+	}
+
+	// Final chunk completes the upload
+	resp = doTus(t, server, "PATCH", uploadPath, map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": strconv.Itoa(half),
+	}, imgData[half:], cookie)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("final patch returned %d", resp.StatusCode)
+	}
+
+	mediaID, err := strconv.Atoi(resp.Header.Get("X-Media-Id"))
+	if err != nil {
+		t.Fatalf("expected X-Media-Id header, got %q", resp.Header.Get("X-Media-Id"))
+	}
+
+	var media models.Media
+	if err := db.First(&media, mediaID).Error; err != nil {
+		t.Fatalf("media not found in DB: %v", err)
+	}
+	if media.Caption != "resumable" {
+		t.Errorf("expected caption %q, got %q", "resumable", media.Caption)
+	}
+
+	original, err := os.ReadFile(filepath.Join(cfg.Storage.LocalDir, media.RemotePath()))
+	if err != nil {
+		t.Fatalf("original file not found: %v", err)
+	}
+	if !bytes.Equal(original, imgData) {
+		t.Error("stored original differs from uploaded data")
+	}
+
+	// The staging files are removed after completion
+	resp = doTus(t, server, "HEAD", uploadPath, nil, nil, cookie)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for finished upload, got %d", resp.StatusCode)
+	}
+}
+
+func TestResumableUpload_Rejected(t *testing.T) {
+	server, db, _, teardown := SetupTestApp(t)
+	defer teardown()
+
+	_, cookie := CreateTestUser(t, db, server)
+	imgData := createTestPNG()
+
+	// Uploads that fail for good are removed, retrying the final PATCH cannot help
+	for _, tc := range []struct {
+		name, filetype, date string
+		status               int
+	}{
+		{"type mismatch", "video/mp4", "2024-06-01T12:00:00Z", http.StatusUnsupportedMediaType},
+		{"invalid date", "image/png", "yesterday", http.StatusBadRequest},
+	} {
+		resp := doTus(t, server, "POST", "/media/uploads/", map[string]string{
+			"Upload-Length":   strconv.Itoa(len(imgData)),
+			"Upload-Metadata": tusMetadata("filename", "test.png", "filetype", tc.filetype, "date", tc.date),
+		}, nil, cookie)
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("%s: create returned %d", tc.name, resp.StatusCode)
+		}
+		uploadPath := "/media/uploads/" + resp.Header.Get("Location")
+
+		resp = doTus(t, server, "PATCH", uploadPath, map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		}, imgData, cookie)
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.status, resp.StatusCode)
+		}
+		resp = doTus(t, server, "HEAD", uploadPath, nil, nil, cookie)
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: expected the upload to be removed, got %d", tc.name, resp.StatusCode)
+		}
+	}
+	if count := getMediaCount(t, db); count != 0 {
+		t.Errorf("expected no media, got %d", count)
+	}
+}
+
+func TestResumableUpload_OtherUser(t *testing.T) {
+	server, db, _, teardown := SetupTestApp(t)
+	defer teardown()
+
+	_, cookie1 := CreateTestUser(t, db, server)
+	_, cookie2 := CreateTestUser(t, db, server)
+
+	resp := doTus(t, server, "POST", "/media/uploads/", map[string]string{
+		"Upload-Length":   "10",
+		"Upload-Metadata": tusMetadata("filename", "test.png", "filetype", "image/png"),
+	}, nil, cookie1)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create returned %d", resp.StatusCode)
+	}
+
+	resp = doTus(t, server, "HEAD", fmt.Sprintf("/media/uploads/%s", resp.Header.Get("Location")), nil, nil, cookie2)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for another user's upload, got %d", resp.StatusCode)
+	}
+}
+
+func TestResumableUpload_RemoveExpired(t *testing.T) {
+	server, db, cfg, teardown := SetupTestApp(t)
+	defer teardown()
+
+	_, cookie := CreateTestUser(t, db, server)
+	var ids []string
+	for range 2 {
+		resp := doTus(t, server, "POST", "/media/uploads/", map[string]string{
+			"Upload-Length":   "10",
+			"Upload-Metadata": tusMetadata("filename", "test.png", "filetype", "image/png"),
+		}, nil, cookie)
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("create returned %d", resp.StatusCode)
+		}
+		ids = append(ids, resp.Header.Get("Location"))
+	}
+	expired, active := ids[0], ids[1]
+
+	infoPath := filepath.Join(cfg.Upload.StagingDir, expired+".info")
+	data, err := os.ReadFile(infoPath)
+	if err != nil {
+		t.Fatalf("read upload info: %v", err)
+	}
+	var info map[string]any
+	json.Unmarshal(data, &info)
+	info["expiresAt"] = time.Now().Add(-time.Minute)
+	data, _ = json.Marshal(info)
+	os.WriteFile(infoPath, data, 0644)
+
+	services.NewUploadService(cfg.Upload, newTestMediaService(db, cfg)).RemoveExpired()
+
+	for _, ext := range []string{".info", ".bin"} {
+		if _, err := os.Stat(filepath.Join(cfg.Upload.StagingDir, expired+ext)); !os.IsNotExist(err) {
+			t.Errorf("expected %s%s of the expired upload to be removed", expired, ext)
+		}
+		if _, err := os.Stat(filepath.Join(cfg.Upload.StagingDir, active+ext)); err != nil {
+			t.Errorf("expected %s%s of the active upload to be kept: %v", active, ext, err)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// Package geocoder finds the nearest populated place of a position offline,
+// using a GeoNames cities export (https://download.geonames.org/export/dump/, e.g. cities15000.zip).
+package geocoder
+
+import (
+	"archive/zip"
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const earthRadius = 6371.0 // km
+
+// Place is a populated place of the dataset.
+type Place struct {
+	Name        string
+	CountryCode string // ISO 3166-1 alpha-2
+	Latitude    float64
+	Longitude   float64
+}
+
+// Country returns the English name of the country of the place, or its code if the code is unknown.
+func (p *Place) Country() string {
+	if name, ok := countryNames[p.CountryCode]; ok {
+		return name
+	}
+	return p.CountryCode
+}
+
+// Geocoder looks up places in a k-d tree of their positions on the unit sphere,
+// so distances are not distorted near the poles and the antimeridian.
+type Geocoder struct {
+	nodes []node // the tree, each subtree is stored with its root in the middle
+}
+
+type node struct {
+	point [3]float64
+	place *Place
+}
+
+// New builds a geocoder for the places.
+func New(places []Place) *Geocoder {
+	nodes := make([]node, len(places))
+	for i := range places {
+		nodes[i] = node{point: unitVector(places[i].Latitude, places[i].Longitude), place: &places[i]}
+	}
+	build(nodes, 0)
+	return &Geocoder{nodes: nodes}
+}
+
+// Load reads the populated places of a GeoNames export: a tab-separated cities file or a zip archive containing one.
+func Load(path string) (*Geocoder, error) {
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		archive, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, err
+		}
+		defer archive.Close()
+		for _, file := range archive.File {
+			if strings.EqualFold(filepath.Ext(file.Name), ".txt") {
+				r, err := file.Open()
+				if err != nil {
+					return nil, err
+				}
+				defer r.Close()
+				return read(r)
+			}
+		}
+		return nil, fmt.Errorf("no .txt file found in %s", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return read(f)
+}
+
+// read parses the GeoNames "geoname" table, keeping only populated places (feature class P).
+// Columns: 1 name, 4 latitude, 5 longitude, 6 feature class, 8 country code.
+func read(r io.Reader) (*Geocoder, error) {
+	var places []Place
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024) // alternate names can be long
+	for line := 1; scanner.Scan(); line++ {
+		columns := strings.Split(scanner.Text(), "\t")
+		if len(columns) < 9 || columns[6] != "P" {
+			continue
+		}
+		lat, err := strconv.ParseFloat(columns[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid latitude: %w", line, err)
+		}
+		lon, err := strconv.ParseFloat(columns[5], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid longitude: %w", line, err)
+		}
+		places = append(places, Place{Name: columns[1], CountryCode: columns[8], Latitude: lat, Longitude: lon})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(places) == 0 {
+		return nil, errors.New("no populated places found")
+	}
+	return New(places), nil
+}
+
+// Len returns the number of places.
+func (g *Geocoder) Len() int {
+	return len(g.nodes)
+}
+
+// Nearest returns the place nearest to the position, or nil if there is none within maxDistance kilometres.
+func (g *Geocoder) Nearest(lat, lon, maxDistance float64) *Place {
+	// Compare squared chord lengths on the unit sphere instead of great-circle distances
+	chord := 2 * math.Sin(min(maxDistance/earthRadius, math.Pi)/2)
+	s := search{target: unitVector(lat, lon), bestDist: chord * chord}
+	s.visit(g.nodes, 0)
+	if s.best == nil {
+		return nil
+	}
+	return s.best.place
+}
+
+// build arranges the nodes as a balanced k-d tree, splitting on the axis of the depth.
+func build(nodes []node, depth int) {
+	if len(nodes) <= 1 {
+		return
+	}
+	axis := depth % 3
+	slices.SortFunc(nodes, func(a, b node) int {
+		return cmp.Compare(a.point[axis], b.point[axis])
+	})
+	mid := len(nodes) / 2
+	build(nodes[:mid], depth+1)
+	build(nodes[mid+1:], depth+1)
+}
+
+type search struct {
+	target   [3]float64
+	best     *node
+	bestDist float64
+}
+
+func (s *search) visit(nodes []node, depth int) {
+	if len(nodes) == 0 {
+		return
+	}
+	mid := len(nodes) / 2
+	n := &nodes[mid]
+	if d := squaredDistance(n.point, s.target); d <= s.bestDist {
+		s.best, s.bestDist = n, d
+	}
+
+	axis := depth % 3
+	diff := s.target[axis] - n.point[axis]
+	near, far := nodes[:mid], nodes[mid+1:]
+	if diff > 0 {
+		near, far = far, near
+	}
+	s.visit(near, depth+1)
+	if diff*diff <= s.bestDist {
+		s.visit(far, depth+1)
+	}
+}
+
+func unitVector(lat, lon float64) [3]float64 {
+	lat, lon = lat*math.Pi/180, lon*math.Pi/180
+	return [3]float64{math.Cos(lat) * math.Cos(lon), math.Cos(lat) * math.Sin(lon), math.Sin(lat)}
+}
+
+func squaredDistance(a, b [3]float64) float64 {
+	dx, dy, dz := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dx*dx + dy*dy + dz*dz
+}